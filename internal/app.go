@@ -22,10 +22,15 @@ func NewApp() *App {
 // Run 运行应用
 func (a *App) Run() {
 	service.InitConfig()
+	service.InitSecurity()
 	service.InitRedis()
 	service.InitDB()
 	gin.SetMode(gin.ReleaseMode)
 	e := gin.New()
+	// 登录锁定和授权策略的IP条件依赖客户端IP，只信任配置的代理转发的请求头
+	if err := e.SetTrustedProxies(service.Cfg.Application.TrustedProxies); err != nil {
+		panic("trusted proxies config invalid: " + err.Error())
+	}
 	e.Use(gin.Recovery())
 	service.InitRoute(e)
 	addr := getAddress()
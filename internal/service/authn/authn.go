@@ -0,0 +1,74 @@
+package authn
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrUserNotFound 该认证源中不存在此用户，认证链继续尝试下一个认证源
+	ErrUserNotFound = errors.New("user not found")
+	// ErrInvalidCredentials 用户存在但密码错误，认证链立即失败
+	ErrInvalidCredentials = errors.New("username or password is incorrect")
+)
+
+// Authenticator 用户名密码认证源
+type Authenticator interface {
+	// Name 认证源名称，用于日志
+	Name() string
+	// Authenticate 校验用户名密码，成功时返回本地用户
+	Authenticate(ctx context.Context, username, password string) (*user.User, error)
+}
+
+// Chain 按顺序尝试多个认证源
+type Chain struct {
+	l              *slog.Logger
+	authenticators []Authenticator
+}
+
+func NewChain(l *slog.Logger, authenticators ...Authenticator) *Chain {
+	return &Chain{l: l, authenticators: authenticators}
+}
+
+// New 根据配置创建认证链，本地账号优先，其次为LDAP
+func New(l *slog.Logger, db *gorm.DB, cfg Config) (*Chain, error) {
+	authenticators := []Authenticator{NewLocal(l.With("authenticator", "local"), db)}
+	if cfg.LDAP.Enable {
+		ldap, err := NewLDAP(l.With("authenticator", "ldap"), db, cfg.LDAP)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, ldap)
+	}
+	return NewChain(l, authenticators...), nil
+}
+
+// Authenticate 依次尝试各认证源，某个认证源不可用时继续尝试其余认证源
+// 所有认证源都不认识该用户时返回ErrUserNotFound，否则返回第一个不可用认证源的错误
+func (c *Chain) Authenticate(ctx context.Context, username, password string) (*user.User, error) {
+	var unavailable error
+	for _, a := range c.authenticators {
+		u, err := a.Authenticate(ctx, username, password)
+		if err == nil {
+			return u, nil
+		}
+		if errors.Is(err, ErrUserNotFound) {
+			continue
+		}
+		if errors.Is(err, ErrInvalidCredentials) {
+			return nil, err
+		}
+		c.l.Error("authenticator unavailable", "authenticator", a.Name(), "username", username, "err", err)
+		if unavailable == nil {
+			unavailable = err
+		}
+	}
+	if unavailable != nil {
+		return nil, unavailable
+	}
+	return nil, ErrUserNotFound
+}
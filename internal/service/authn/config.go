@@ -0,0 +1,77 @@
+package authn
+
+import "time"
+
+// Config 认证源配置
+type Config struct {
+	LDAP LDAPConfig `json:"ldap"`
+}
+
+// LDAPConfig LDAP/Active Directory认证配置
+type LDAPConfig struct {
+	Enable bool `json:"enable"`
+	// URL 目录服务地址，如 ldap://ad.example.com:389 或 ldaps://ad.example.com:636
+	URL string `json:"url"`
+	// StartTLS 使用ldap://连接时升级为TLS
+	StartTLS bool `json:"starttls"`
+	// CAFile 校验服务端证书的CA，为空时使用系统证书
+	CAFile             string `json:"cafile"`
+	InsecureSkipVerify bool   `json:"insecureskipverify"`
+	// BindDN 用于查找用户的服务账号，为空时匿名查找
+	BindDN       string `json:"binddn"`
+	BindPassword string `json:"bindpassword"`
+	// BaseDN 用户查找的根节点
+	BaseDN string `json:"basedn"`
+	// UserFilter 用户查找条件，%s 替换为转义后的用户名
+	UserFilter string `json:"userfilter"`
+	// GroupBaseDN 与 GroupFilter 同时配置时通过查找组获取用户所属组，否则读取用户的memberOf属性
+	GroupBaseDN string `json:"groupbasedn"`
+	// GroupFilter 组查找条件，%s 替换为转义后的用户DN
+	GroupFilter string     `json:"groupfilter"`
+	Attributes  Attributes `json:"attributes"`
+	// GroupRoles 组DN与角色的映射，每次登录时按所属组同步映射中出现的角色
+	GroupRoles []GroupRole `json:"grouproles"`
+	// DefaultRoles 首次登录创建账号时授予的角色
+	DefaultRoles []uint        `json:"defaultroles"`
+	Timeout      time.Duration `json:"timeout"`
+}
+
+// Attributes 同步到本地账号的目录属性
+type Attributes struct {
+	Username string `json:"username"`
+	Fullname string `json:"fullname"`
+	Email    string `json:"email"`
+	Phone    string `json:"phone"`
+	MemberOf string `json:"memberof"`
+}
+
+// GroupRole 目录组映射的角色
+type GroupRole struct {
+	Group   string `json:"group"`
+	RoleIDs []uint `json:"roleids"`
+}
+
+func (c LDAPConfig) withDefaults() LDAPConfig {
+	if c.UserFilter == "" {
+		c.UserFilter = "(&(objectClass=person)(sAMAccountName=%s))"
+	}
+	if c.Attributes.Username == "" {
+		c.Attributes.Username = "sAMAccountName"
+	}
+	if c.Attributes.Fullname == "" {
+		c.Attributes.Fullname = "displayName"
+	}
+	if c.Attributes.Email == "" {
+		c.Attributes.Email = "mail"
+	}
+	if c.Attributes.Phone == "" {
+		c.Attributes.Phone = "telephoneNumber"
+	}
+	if c.Attributes.MemberOf == "" {
+		c.Attributes.MemberOf = "memberOf"
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+	return c
+}
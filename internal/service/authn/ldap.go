@@ -0,0 +1,240 @@
+package authn
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+// LDAP 通过LDAP/AD目录认证，首次登录时创建本地账号，之后每次登录同步属性和组映射的角色
+type LDAP struct {
+	l   *slog.Logger
+	db  *gorm.DB
+	cfg LDAPConfig
+	tls *tls.Config
+}
+
+// directoryUser 目录中查找到的用户
+type directoryUser struct {
+	DN       string
+	Username string
+	Fullname string
+	Email    string
+	Phone    string
+	Groups   []string
+}
+
+func NewLDAP(l *slog.Logger, db *gorm.DB, cfg LDAPConfig) (*LDAP, error) {
+	cfg = cfg.withDefaults()
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		return nil, errors.New("ldap url and basedn are required")
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldap url: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ldap ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in ldap ca file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	for _, gr := range cfg.GroupRoles {
+		if _, err := ldap.ParseDN(gr.Group); err != nil {
+			return nil, fmt.Errorf("invalid ldap group %q: %w", gr.Group, err)
+		}
+	}
+
+	return &LDAP{l: l, db: db, cfg: cfg, tls: tlsConfig}, nil
+}
+
+func (a *LDAP) Name() string {
+	return "ldap"
+}
+
+// Authenticate 使用服务账号查找用户DN，再以用户DN和密码绑定校验密码
+func (a *LDAP) Authenticate(ctx context.Context, username, password string) (*user.User, error) {
+	// 空密码会被目录服务当作匿名绑定而成功
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	du, err := a.lookup(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(du.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap bind user: %w", err)
+	}
+
+	if a.cfg.GroupBaseDN != "" && a.cfg.GroupFilter != "" {
+		if du.Groups, err = a.searchGroups(conn, du.DN); err != nil {
+			return nil, err
+		}
+	}
+
+	return a.provision(ctx, du)
+}
+
+func (a *LDAP) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.cfg.Timeout}),
+		ldap.DialWithTLSConfig(a.tls),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ldap dial: %w", err)
+	}
+	conn.SetTimeout(a.cfg.Timeout)
+
+	if a.cfg.StartTLS {
+		if err := conn.StartTLS(a.tls); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap start tls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// bindService 以服务账号绑定，未配置时使用匿名绑定
+func (a *LDAP) bindService(conn *ldap.Conn) error {
+	var err error
+	if a.cfg.BindDN == "" {
+		err = conn.UnauthenticatedBind("")
+	} else {
+		err = conn.Bind(a.cfg.BindDN, a.cfg.BindPassword)
+	}
+	if err != nil {
+		return fmt.Errorf("ldap bind service account: %w", err)
+	}
+	return nil
+}
+
+// lookup 查找用户，不存在或匹配到多个条目时视为用户不存在
+func (a *LDAP) lookup(conn *ldap.Conn, username string) (*directoryUser, error) {
+	if err := a.bindService(conn); err != nil {
+		return nil, err
+	}
+
+	attrs := a.cfg.Attributes
+	req := ldap.NewSearchRequest(
+		a.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(a.cfg.Timeout.Seconds()), false,
+		fmt.Sprintf(a.cfg.UserFilter, ldap.EscapeFilter(username)),
+		[]string{attrs.Username, attrs.Fullname, attrs.Email, attrs.Phone, attrs.MemberOf},
+		nil,
+	)
+	res, err := conn.Search(req)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("ldap search user: %w", err)
+	}
+	if res == nil || len(res.Entries) != 1 {
+		if res != nil && len(res.Entries) > 1 {
+			a.l.Warn("ldap user filter matched multiple entries", "username", username)
+		}
+		return nil, ErrUserNotFound
+	}
+
+	entry := res.Entries[0]
+	du := &directoryUser{
+		DN:       entry.DN,
+		Username: entry.GetAttributeValue(attrs.Username),
+		Fullname: entry.GetAttributeValue(attrs.Fullname),
+		Email:    entry.GetAttributeValue(attrs.Email),
+		Phone:    entry.GetAttributeValue(attrs.Phone),
+		Groups:   entry.GetAttributeValues(attrs.MemberOf),
+	}
+	if du.Username == "" {
+		du.Username = username
+	}
+	return du, nil
+}
+
+// searchGroups 查找包含该用户的组，使用服务账号的权限查找
+func (a *LDAP) searchGroups(conn *ldap.Conn, userDN string) ([]string, error) {
+	if err := a.bindService(conn); err != nil {
+		return nil, err
+	}
+
+	req := ldap.NewSearchRequest(
+		a.cfg.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(a.cfg.Timeout.Seconds()), false,
+		fmt.Sprintf(a.cfg.GroupFilter, ldap.EscapeFilter(userDN)),
+		[]string{"dn"},
+		nil,
+	)
+	res, err := conn.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("ldap search groups: %w", err)
+	}
+	groups := make([]string, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		groups = append(groups, entry.DN)
+	}
+	return groups, nil
+}
+
+// provision 创建或更新本地账号并同步组映射的角色，不接管同名的本地账号
+func (a *LDAP) provision(ctx context.Context, du *directoryUser) (*user.User, error) {
+	dns := make([]*ldap.DN, 0, len(du.Groups))
+	for _, g := range du.Groups {
+		dn, err := ldap.ParseDN(g)
+		if err != nil {
+			a.l.Warn("skip invalid ldap group dn", "group", g, "err", err)
+			continue
+		}
+		dns = append(dns, dn)
+	}
+	roles := MapRoles(a.cfg.GroupRoles, func(group string) bool {
+		dn, err := ldap.ParseDN(group)
+		return err == nil && slices.ContainsFunc(dns, dn.EqualFold)
+	})
+	roles.Defaults = a.cfg.DefaultRoles
+
+	u, err := Provision(ctx, a.db, user.SourceLDAP, Profile{
+		Username: du.Username,
+		Fullname: du.Fullname,
+		Email:    du.Email,
+		Phone:    du.Phone,
+	}, roles)
+	if errors.Is(err, ErrAccountConflict) {
+		a.l.Warn("ldap user conflicts with a local account", "username", du.Username)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a.l.Info("ldap user synced", "userId", u.ID, "username", u.Username, "roles", roles.Granted)
+	return u, nil
+}
@@ -0,0 +1,275 @@
+package authn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/z876730060/auth/internal/service/role"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testServiceDN = "cn=svc,dc=example,dc=com"
+	testAdminsDN  = "cn=admins,ou=groups,dc=example,dc=com"
+	testDevsDN    = "cn=devs,ou=groups,dc=example,dc=com"
+	testAliceDN   = "uid=alice,ou=people,dc=example,dc=com"
+	testBobDN     = "uid=bob,ou=people,dc=example,dc=com"
+
+	roleAdmin   uint = 1
+	roleDev     uint = 2
+	roleManual  uint = 5
+	roleDefault uint = 9
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&role.Role{}, &user.User{}, &user.UserRole{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestLDAP 启动包含服务账号、两个用户和两个组的目录
+func newTestLDAP(t *testing.T, configure func(cfg *LDAPConfig)) (*LDAP, *testDirectory, *gorm.DB) {
+	t.Helper()
+	dir := newTestDirectory(t,
+		&testEntry{dn: testServiceDN, password: "svc-secret", attrs: map[string][]string{"objectClass": {"applicationProcess"}}},
+		&testEntry{dn: testAliceDN, password: "alice-secret", attrs: map[string][]string{
+			"objectClass":     {"person"},
+			"uid":             {"alice"},
+			"displayName":     {"Alice Liddell"},
+			"mail":            {"alice@example.com"},
+			"telephoneNumber": {"10086"},
+			"memberOf":        {"CN=Admins,OU=Groups,DC=example,DC=com"},
+		}},
+		&testEntry{dn: testBobDN, password: "bob-secret", attrs: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"bob"},
+			"displayName": {"Bob"},
+		}},
+		&testEntry{dn: "uid=twin1,ou=people,dc=example,dc=com", password: "twin-secret", attrs: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"twin"},
+		}},
+		&testEntry{dn: "uid=twin2,ou=people,dc=example,dc=com", password: "twin-secret", attrs: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"twin"},
+		}},
+		&testEntry{dn: testAdminsDN, attrs: map[string][]string{"objectClass": {"groupOfNames"}, "member": {testAliceDN}}},
+		&testEntry{dn: testDevsDN, attrs: map[string][]string{"objectClass": {"groupOfNames"}, "member": {testBobDN}}},
+	)
+
+	cfg := LDAPConfig{
+		Enable:       true,
+		URL:          dir.url(),
+		BindDN:       testServiceDN,
+		BindPassword: "svc-secret",
+		BaseDN:       "ou=people,dc=example,dc=com",
+		UserFilter:   "(&(objectClass=person)(uid=%s))",
+		Attributes:   Attributes{Username: "uid"},
+		GroupRoles: []GroupRole{
+			{Group: testAdminsDN, RoleIDs: []uint{roleAdmin}},
+			{Group: testDevsDN, RoleIDs: []uint{roleDev}},
+		},
+		DefaultRoles: []uint{roleDefault},
+	}
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	db := newTestDB(t)
+	a, err := NewLDAP(slog.New(slog.NewTextHandler(io.Discard, nil)), db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, dir, db
+}
+
+func userRoles(t *testing.T, db *gorm.DB, userID uint) []uint {
+	t.Helper()
+	var ids []uint
+	if err := db.Model(&user.UserRole{}).Where("user_id = ?", userID).Pluck("role_id", &ids).Error; err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	a, _, db := newTestLDAP(t, nil)
+
+	u, err := a.Authenticate(context.Background(), "alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if u.Username != "alice" || u.Fullname != "Alice Liddell" || u.Email != "alice@example.com" ||
+		u.Phone != "10086" || u.Source != user.SourceLDAP {
+		t.Fatalf("unexpected user: %+v", u)
+	}
+	// memberOf中的组DN大小写与映射不同
+	if got, want := userRoles(t, db, u.ID), []uint{roleAdmin, roleDefault}; !slices.Equal(got, want) {
+		t.Fatalf("roles = %v, want %v", got, want)
+	}
+}
+
+func TestLDAPInvalidCredentials(t *testing.T) {
+	a, _, db := newTestLDAP(t, nil)
+
+	for _, password := range []string{"wrong", ""} {
+		if _, err := a.Authenticate(context.Background(), "alice", password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Authenticate(%q) error = %v, want ErrInvalidCredentials", password, err)
+		}
+	}
+	var count int64
+	db.Model(&user.User{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d users provisioned after failed binds", count)
+	}
+}
+
+func TestLDAPUserNotFound(t *testing.T) {
+	a, _, _ := newTestLDAP(t, nil)
+
+	// 用户名中的过滤器元字符必须被转义，匹配到多个条目时不能任选其一
+	for _, username := range []string{"carol", "*", "alice)(uid=*", "twin"} {
+		if _, err := a.Authenticate(context.Background(), username, "twin-secret"); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("Authenticate(%q) error = %v, want ErrUserNotFound", username, err)
+		}
+	}
+}
+
+func TestLDAPServiceBindFailure(t *testing.T) {
+	a, _, _ := newTestLDAP(t, func(cfg *LDAPConfig) { cfg.BindPassword = "wrong" })
+
+	_, err := a.Authenticate(context.Background(), "alice", "alice-secret")
+	if err == nil || errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate() error = %v, want service bind error", err)
+	}
+}
+
+func TestLDAPGroupSearch(t *testing.T) {
+	a, _, db := newTestLDAP(t, func(cfg *LDAPConfig) {
+		cfg.GroupBaseDN = "ou=groups,dc=example,dc=com"
+		cfg.GroupFilter = "(&(objectClass=groupOfNames)(member=%s))"
+	})
+
+	u, err := a.Authenticate(context.Background(), "bob", "bob-secret")
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if got, want := userRoles(t, db, u.ID), []uint{roleDev, roleDefault}; !slices.Equal(got, want) {
+		t.Fatalf("roles = %v, want %v", got, want)
+	}
+}
+
+func TestLDAPRoleSync(t *testing.T) {
+	a, dir, db := newTestLDAP(t, nil)
+	ctx := context.Background()
+
+	u, err := a.Authenticate(ctx, "alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 手动授予的角色和默认角色不受组映射影响
+	if err := db.Create(&user.UserRole{UserID: u.ID, RoleID: roleManual}).Error; err != nil {
+		t.Fatal(err)
+	}
+	dir.update(testAliceDN, func(e *testEntry) {
+		e.attrs["memberOf"] = []string{testDevsDN}
+		e.attrs["displayName"] = []string{"Alice Kingsleigh"}
+	})
+
+	u, err = a.Authenticate(ctx, "alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Fullname != "Alice Kingsleigh" {
+		t.Fatalf("fullname not synced: %q", u.Fullname)
+	}
+	if got, want := userRoles(t, db, u.ID), []uint{roleDev, roleManual, roleDefault}; !slices.Equal(got, want) {
+		t.Fatalf("roles = %v, want %v", got, want)
+	}
+}
+
+func TestLDAPDefaultRoleKept(t *testing.T) {
+	// 默认角色同时出现在组映射中，用户不在该组时也不移除
+	a, _, db := newTestLDAP(t, func(cfg *LDAPConfig) {
+		cfg.GroupRoles = append(cfg.GroupRoles, GroupRole{Group: testDevsDN, RoleIDs: []uint{roleDefault}})
+	})
+	ctx := context.Background()
+
+	for range 2 {
+		u, err := a.Authenticate(ctx, "alice", "alice-secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := userRoles(t, db, u.ID), []uint{roleAdmin, roleDefault}; !slices.Equal(got, want) {
+			t.Fatalf("roles = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestLDAPSuperAdminRoleSync(t *testing.T) {
+	a, dir, db := newTestLDAP(t, nil)
+	ctx := context.Background()
+	if err := db.Create(&role.Role{Model: gorm.Model{ID: roleAdmin}, Name: "admin", IsSuperAdmin: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 组映射不能授予超级管理员角色
+	if _, err := a.Authenticate(ctx, "alice", "alice-secret"); !errors.Is(err, user.ErrSuperAdminRequired) {
+		t.Fatalf("Authenticate() error = %v, want ErrSuperAdminRequired", err)
+	}
+	var count int64
+	db.Model(&user.User{}).Where("username = ?", "alice").Count(&count)
+	if count != 0 {
+		t.Fatal("user created with super admin role")
+	}
+
+	// 也不能移除手动授予的超级管理员角色
+	dir.update(testAliceDN, func(e *testEntry) { e.attrs["memberOf"] = nil })
+	u, err := a.Authenticate(ctx, "alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&user.UserRole{UserID: u.ID, RoleID: roleAdmin}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(ctx, "alice", "alice-secret"); !errors.Is(err, user.ErrSuperAdminRequired) {
+		t.Fatalf("Authenticate() error = %v, want ErrSuperAdminRequired", err)
+	}
+	if got, want := userRoles(t, db, u.ID), []uint{roleAdmin, roleDefault}; !slices.Equal(got, want) {
+		t.Fatalf("roles = %v, want %v", got, want)
+	}
+}
+
+func TestLDAPLocalAccountConflict(t *testing.T) {
+	a, _, db := newTestLDAP(t, nil)
+	local := &user.User{Username: "alice", Fullname: "Local Alice", Password: "hashed"}
+	if err := db.Create(local).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Authenticate(context.Background(), "alice", "alice-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate() error = %v, want ErrInvalidCredentials", err)
+	}
+	var got user.User
+	db.First(&got, local.ID)
+	if got.Fullname != "Local Alice" || got.Source != "" {
+		t.Fatalf("local account taken over: %+v", got)
+	}
+	if roles := userRoles(t, db, local.ID); len(roles) != 0 {
+		t.Fatalf("roles granted to local account: %v", roles)
+	}
+}
@@ -0,0 +1,197 @@
+package authn
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// testEntry 目录条目，属性名不区分大小写
+type testEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+func (e *testEntry) values(attr string) []string {
+	for name, vals := range e.attrs {
+		if strings.EqualFold(name, attr) {
+			return vals
+		}
+	}
+	return nil
+}
+
+// match 支持与、或、非、等值和存在判断，足以覆盖用户和组的查找条件
+func (e *testEntry) match(f *ber.Packet) bool {
+	switch f.Tag {
+	case ldap.FilterAnd:
+		for _, child := range f.Children {
+			if !e.match(child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range f.Children {
+			if e.match(child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !e.match(f.Children[0])
+	case ldap.FilterEqualityMatch:
+		want := f.Children[1].Value.(string)
+		for _, v := range e.values(f.Children[0].Value.(string)) {
+			if strings.EqualFold(v, want) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(e.values(f.Data.String())) > 0
+	default:
+		return false
+	}
+}
+
+// testDirectory 进程内的LDAP服务，只实现简单绑定和查找
+type testDirectory struct {
+	mu      sync.Mutex
+	entries []*testEntry
+	addr    string
+}
+
+func newTestDirectory(t *testing.T, entries ...*testEntry) *testDirectory {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	d := &testDirectory{entries: entries, addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *testDirectory) url() string {
+	return "ldap://" + d.addr
+}
+
+// update 在锁内修改目录条目
+func (d *testDirectory) update(dn string, fn func(e *testEntry)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range d.entries {
+		if strings.EqualFold(e.dn, dn) {
+			fn(e)
+		}
+	}
+}
+
+func (d *testDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		var replies []*ber.Packet
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			replies = append(replies, ldapResult(ldap.ApplicationBindResponse, d.bind(op)))
+		case ldap.ApplicationSearchRequest:
+			replies = d.search(op)
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			continue
+		}
+		for _, reply := range replies {
+			msg := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+			msg.AppendChild(reply)
+			if _, err := conn.Write(msg.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// bind 空DN空密码为匿名绑定，其余按条目密码校验
+func (d *testDirectory) bind(op *ber.Packet) uint16 {
+	name := op.Children[1].Value.(string)
+	password := op.Children[2].Data.String()
+	if name == "" && password == "" {
+		return ldap.LDAPResultSuccess
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range d.entries {
+		if strings.EqualFold(e.dn, name) && e.password != "" && e.password == password {
+			return ldap.LDAPResultSuccess
+		}
+	}
+	return ldap.LDAPResultInvalidCredentials
+}
+
+// search 返回根节点下匹配的条目，超过数量限制时返回sizeLimitExceeded
+func (d *testDirectory) search(op *ber.Packet) []*ber.Packet {
+	base := strings.ToLower(op.Children[0].Value.(string))
+	limit := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var replies []*ber.Packet
+	for _, e := range d.entries {
+		if !strings.HasSuffix(strings.ToLower(e.dn), ","+base) || !e.match(filter) {
+			continue
+		}
+		if limit > 0 && int64(len(replies)) == limit {
+			return append(replies, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSizeLimitExceeded))
+		}
+		entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+		entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, ""))
+		attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		for name, vals := range e.attrs {
+			attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, v := range vals {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+			}
+			attr.AppendChild(set)
+			attrs.AppendChild(attr)
+		}
+		entry.AppendChild(attrs)
+		replies = append(replies, entry)
+	}
+	return append(replies, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+}
+
+func ldapResult(tag ber.Tag, code uint16) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return op
+}
@@ -0,0 +1,68 @@
+package authn
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/z876730060/auth/internal/service/password"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+// Local 使用user表中的密码哈希认证本地账号
+type Local struct {
+	l  *slog.Logger
+	db *gorm.DB
+}
+
+func NewLocal(l *slog.Logger, db *gorm.DB) *Local {
+	return &Local{l: l, db: db}
+}
+
+func (a *Local) Name() string {
+	return "local"
+}
+
+// Authenticate 校验本地密码，外部目录同步的账号交给对应的认证源
+func (a *Local) Authenticate(ctx context.Context, username, plain string) (*user.User, error) {
+	var u user.User
+	if err := a.db.WithContext(ctx).Where(user.User{Username: username}).First(&u).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if u.Source != "" {
+		return nil, ErrUserNotFound
+	}
+
+	// 已移除密码的账号只能使用凭证登录
+	ok, rehash, err := password.Verify(u.Password, plain)
+	if err != nil {
+		a.l.Error("verify password failed", "username", u.Username, "err", err)
+	}
+	if !ok || u.Password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	// 历史明文密码或哈希参数变更时重新生成哈希
+	if rehash {
+		a.rehashPassword(ctx, &u, plain)
+	}
+	return &u, nil
+}
+
+// rehashPassword 使用当前算法重新生成密码哈希，失败时不影响登录
+func (a *Local) rehashPassword(ctx context.Context, u *user.User, plain string) {
+	hashed, err := password.Hash(plain)
+	if err != nil {
+		a.l.Error("rehash password failed", "username", u.Username, "err", err)
+		return
+	}
+	if err := a.db.WithContext(ctx).Model(u).Update("password", hashed).Error; err != nil {
+		a.l.Error("save rehashed password failed", "username", u.Username, "err", err)
+		return
+	}
+	a.l.Info("password rehashed", "username", u.Username)
+}
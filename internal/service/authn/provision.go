@@ -0,0 +1,112 @@
+package authn
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+// ErrAccountConflict 外部身份与其他来源的同名账号冲突，不会接管该账号
+var ErrAccountConflict = errors.New("account belongs to another source")
+
+// Profile 外部身份源提供的用户信息
+type Profile struct {
+	Username string
+	Fullname string
+	Email    string
+	Phone    string
+}
+
+// Roles 外部身份源映射的角色
+type Roles struct {
+	// Granted 按所属组授予的角色
+	Granted []uint
+	// Managed 组映射中出现的全部角色，不在Granted和Defaults中的会被移除
+	Managed []uint
+	// Defaults 首次创建账号时授予的角色
+	Defaults []uint
+}
+
+// MapRoles 按组映射计算授予的角色，member判断用户是否属于该组
+func MapRoles(mapping []GroupRole, member func(group string) bool) Roles {
+	var roles Roles
+	for _, gr := range mapping {
+		in := member(gr.Group)
+		for _, id := range gr.RoleIDs {
+			if !slices.Contains(roles.Managed, id) {
+				roles.Managed = append(roles.Managed, id)
+			}
+			if in && !slices.Contains(roles.Granted, id) {
+				roles.Granted = append(roles.Granted, id)
+			}
+		}
+	}
+	return roles
+}
+
+// Provision 按来源创建或更新本地账号并同步映射的角色，手动授予的其他角色保持不变
+// 同名账号属于其他来源时返回ErrAccountConflict，同步会授予或移除超级管理员角色时返回user.ErrSuperAdminRequired
+func Provision(ctx context.Context, db *gorm.DB, source string, p Profile, roles Roles) (*user.User, error) {
+	var u user.User
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where(user.User{Username: p.Username}).First(&u).Error
+		created := errors.Is(err, gorm.ErrRecordNotFound)
+		if err != nil && !created {
+			return err
+		}
+		if !created && u.Source != source {
+			return ErrAccountConflict
+		}
+
+		u.Username = p.Username
+		u.Fullname = p.Fullname
+		u.Email = p.Email
+		u.Phone = p.Phone
+		u.Source = source
+		if err := tx.Save(&u).Error; err != nil {
+			return err
+		}
+
+		var current []uint
+		if err := tx.Model(&user.UserRole{}).Where("user_id = ?", u.ID).Pluck("role_id", &current).Error; err != nil {
+			return err
+		}
+		want := roles.Granted
+		if created {
+			want = append(slices.Clone(roles.Defaults), roles.Granted...)
+		}
+		granted := make([]uint, 0)
+		for _, id := range want {
+			if !slices.Contains(current, id) && !slices.Contains(granted, id) {
+				granted = append(granted, id)
+			}
+		}
+		// 默认角色只在创建时授予，之后即使出现在组映射中也不移除
+		revoked := make([]uint, 0)
+		for _, id := range roles.Managed {
+			if slices.Contains(current, id) && !slices.Contains(want, id) && !slices.Contains(roles.Defaults, id) {
+				revoked = append(revoked, id)
+			}
+		}
+		if err := user.CheckRoleSync(tx, granted, revoked); err != nil {
+			return err
+		}
+
+		for _, id := range granted {
+			if err := tx.Create(&user.UserRole{UserID: u.ID, RoleID: id}).Error; err != nil {
+				return err
+			}
+		}
+		if len(revoked) > 0 {
+			return tx.Where("user_id = ? AND role_id IN ?", u.ID, revoked).Delete(&user.UserRole{}).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/z876730060/auth/internal/service/authz"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+// subjectAttributes 授权策略条件中可以使用的调用方属性，如 subject.source、subject.email
+func subjectAttributes(ctx context.Context, sub *authz.Subject) (map[string]string, error) {
+	if sub.ServiceAccountID != 0 {
+		return map[string]string{"type": common.PrincipalServiceAccount}, nil
+	}
+
+	var u user.User
+	if err := db.WithContext(ctx).Where("id = ?", sub.UserID).First(&u).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return map[string]string{"type": common.PrincipalUser}, nil
+		}
+		return nil, err
+	}
+	return map[string]string{
+		"type":     common.PrincipalUser,
+		"username": u.Username,
+		"fullname": u.Fullname,
+		"email":    u.Email,
+		"phone":    u.Phone,
+		"source":   u.Source,
+	}, nil
+}
@@ -0,0 +1,147 @@
+package authz
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/common"
+	"gorm.io/gorm"
+)
+
+// Authorizer 授权引擎，所有接口、菜单和网关的权限判断都通过它完成
+type Authorizer interface {
+	// Authorize 判断主体能否在域内对资源执行操作
+	Authorize(ctx context.Context, req *Request) (bool, error)
+	// Reload 策略数据变更后重新加载，并通知其他实例
+	Reload(ctx context.Context) error
+}
+
+// Source 策略来源，引擎加载时合并所有来源的策略
+type Source interface {
+	Policies(ctx context.Context, db *gorm.DB) ([]Policy, error)
+}
+
+// SourceFunc 函数形式的策略来源
+type SourceFunc func(ctx context.Context, db *gorm.DB) ([]Policy, error)
+
+func (f SourceFunc) Policies(ctx context.Context, db *gorm.DB) ([]Policy, error) {
+	return f(ctx, db)
+}
+
+// AttributeLoader 按需加载主体属性，只有策略条件用到未知的 subject.* 属性时才调用
+type AttributeLoader func(ctx context.Context, sub *Subject) (map[string]string, error)
+
+// Subject 调用方，用户或服务账号及其角色
+type Subject struct {
+	UserID           uint
+	ServiceAccountID uint
+	Roles            []uint
+	// Attrs 调用方属性，条件中以 subject. 前缀引用
+	Attrs  map[string]string
+	loaded bool
+}
+
+// Request 授权请求
+type Request struct {
+	Subject *Subject
+	Domain  string
+	Object  string
+	Action  string
+	// Env 请求上下文属性，条件中以 env. 前缀引用，包括 ip、method、path、hour、weekday
+	Env map[string]string
+}
+
+// keys 主体对应的策略主体，包括自身、所有角色以及 *
+func (s *Subject) keys() []string {
+	keys := make([]string, 0, len(s.Roles)+2)
+	if s.ServiceAccountID != 0 {
+		keys = append(keys, "service_account:"+strconv.FormatUint(uint64(s.ServiceAccountID), 10))
+	} else {
+		keys = append(keys, "user:"+strconv.FormatUint(uint64(s.UserID), 10))
+	}
+	for _, id := range s.Roles {
+		keys = append(keys, RoleSubject(id))
+	}
+	return append(keys, Any)
+}
+
+// SubjectFromContext 认证中间件写入上下文的调用方，同一请求内复用已加载的属性
+func SubjectFromContext(c *gin.Context) *Subject {
+	if v, ok := c.Get("subject"); ok {
+		return v.(*Subject)
+	}
+	sub := &Subject{
+		UserID:           c.GetUint("userId"),
+		ServiceAccountID: c.GetUint("serviceAccountId"),
+		Roles:            c.GetUintSlice("role"),
+		Attrs:            map[string]string{"username": c.GetString("username")},
+	}
+	c.Set("subject", sub)
+	return sub
+}
+
+// NewRequest 本服务接口和按钮的授权请求，域固定为空，不能通过请求头切换到微应用的域
+func NewRequest(c *gin.Context, object, action string) *Request {
+	return NewDomainRequest(c, "", object, action)
+}
+
+// NewDomainRequest 微应用菜单的授权请求，domain必须由服务端根据菜单数据或网关配置确定
+func NewDomainRequest(c *gin.Context, domain, object, action string) *Request {
+	return &Request{
+		Subject: SubjectFromContext(c),
+		Domain:  domain,
+		Object:  object,
+		Action:  action,
+		Env:     NewEnv(c, c.Request.Method, c.Request.URL.Path),
+	}
+}
+
+// NewEnv 请求上下文属性，网关转发认证时使用原始请求的方法和路径
+// ip 只采用 application.trustedproxies 中代理转发的 X-Forwarded-For
+func NewEnv(c *gin.Context, method, path string) map[string]string {
+	now := time.Now()
+	return map[string]string{
+		"ip":      c.ClientIP(),
+		"method":  method,
+		"path":    path,
+		"hour":    strconv.Itoa(now.Hour()),
+		"weekday": strconv.Itoa(int(now.Weekday())),
+	}
+}
+
+// RequirePermission 要求当前调用方拥有全部指定的接口权限码，否则返回403
+// 必须注册在认证中间件之后
+func RequirePermission(a Authorizer, info common.Info, codes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, code := range codes {
+			ok, err := a.Authorize(c, NewRequest(c, code, ActionCall))
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, common.RespErr(err.Error(), info))
+				return
+			}
+			if !ok {
+				c.AbortWithStatusJSON(http.StatusForbidden, common.RespDenied(code, info))
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// Allowed 从候选资源中筛选出允许执行操作的资源
+func Allowed(c *gin.Context, a Authorizer, action string, objects []string) ([]string, error) {
+	allowed := make([]string, 0, len(objects))
+	for _, object := range objects {
+		ok, err := a.Authorize(c, NewRequest(c, object, action))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			allowed = append(allowed, object)
+		}
+	}
+	return allowed, nil
+}
@@ -0,0 +1,16 @@
+package authz
+
+import "time"
+
+// Config 授权策略缓存配置
+type Config struct {
+	// ReloadInterval 定期全量重新加载策略，兜底未收到变更通知的情况
+	ReloadInterval time.Duration `json:"reloadinterval"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.ReloadInterval == 0 {
+		c.ReloadInterval = 5 * time.Minute
+	}
+	return c
+}
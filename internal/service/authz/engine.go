@@ -0,0 +1,201 @@
+package authz
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"maps"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// reloadChannel 策略变更通知，消息内容为发起重新加载的实例ID
+const reloadChannel = "authz:policy:reload"
+
+// Engine 基于数据库策略的授权引擎，策略按主体索引缓存在内存中
+type Engine struct {
+	l          *slog.Logger
+	db         *gorm.DB
+	rdb        *redis.Client
+	cfg        Config
+	sources    []Source
+	attributes AttributeLoader
+	// instance 当前实例ID，忽略自己发出的变更通知
+	instance string
+	policies atomic.Pointer[map[string][]Policy]
+	mu       sync.Mutex
+}
+
+// NewEngine 创建授权引擎并加载策略，authz_policy 表总是作为第一个策略来源
+func NewEngine(l *slog.Logger, db *gorm.DB, rdb *redis.Client, cfg Config, attributes AttributeLoader, sources ...Source) (*Engine, error) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	e := &Engine{
+		l:          l,
+		db:         db,
+		rdb:        rdb,
+		cfg:        cfg.withDefaults(),
+		sources:    append([]Source{SourceFunc(tablePolicies)}, sources...),
+		attributes: attributes,
+		instance:   hex.EncodeToString(b),
+	}
+	if err := e.load(context.Background()); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func tablePolicies(ctx context.Context, db *gorm.DB) ([]Policy, error) {
+	var policies []Policy
+	err := db.WithContext(ctx).Find(&policies).Error
+	return policies, err
+}
+
+// Authorize 匹配主体的所有策略，任一deny策略生效时拒绝，否则至少一条allow策略生效时允许
+func (e *Engine) Authorize(ctx context.Context, req *Request) (bool, error) {
+	index := *e.policies.Load()
+	allowed := false
+	for _, key := range req.Subject.keys() {
+		for i := range index[key] {
+			p := &index[key][i]
+			if !p.applies(req) {
+				continue
+			}
+			ok, err := e.satisfied(ctx, req, p.Conditions)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				continue
+			}
+			if p.Effect == EffectDeny {
+				return false, nil
+			}
+			allowed = true
+		}
+	}
+	return allowed, nil
+}
+
+// applies 域、资源和操作是否匹配
+func (p *Policy) applies(req *Request) bool {
+	if p.Domain != "" && p.Domain != Any && p.Domain != req.Domain {
+		return false
+	}
+	return matchPattern(p.Action, req.Action) && matchPattern(p.Object, req.Object)
+}
+
+// satisfied 是否满足全部条件
+func (e *Engine) satisfied(ctx context.Context, req *Request, conditions []Condition) (bool, error) {
+	for _, cond := range conditions {
+		var value string
+		if name, ok := strings.CutPrefix(cond.Attr, attrEnv); ok {
+			value = req.Env[name]
+		} else {
+			name := strings.TrimPrefix(cond.Attr, attrSubject)
+			v, err := e.attribute(ctx, req.Subject, name)
+			if err != nil {
+				return false, err
+			}
+			value = v
+		}
+		if !cond.match(value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// attribute 主体属性，首次用到未知属性时加载完整属性
+func (e *Engine) attribute(ctx context.Context, sub *Subject, name string) (string, error) {
+	if v, ok := sub.Attrs[name]; ok || sub.loaded || e.attributes == nil {
+		return v, nil
+	}
+	attrs, err := e.attributes(ctx, sub)
+	if err != nil {
+		return "", err
+	}
+	if sub.Attrs == nil {
+		sub.Attrs = make(map[string]string, len(attrs))
+	}
+	maps.Copy(sub.Attrs, attrs)
+	sub.loaded = true
+	return sub.Attrs[name], nil
+}
+
+// Reload 重新加载策略并通知其他实例
+func (e *Engine) Reload(ctx context.Context) error {
+	if err := e.load(ctx); err != nil {
+		return err
+	}
+	if e.rdb == nil {
+		return nil
+	}
+	return e.rdb.Publish(ctx, reloadChannel, e.instance).Err()
+}
+
+// load 从所有来源加载策略，加载失败时保留原有策略
+func (e *Engine) load(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	index := make(map[string][]Policy)
+	// 未启用数据库时没有任何策略，所有授权请求都被拒绝
+	if e.db == nil {
+		e.policies.Store(&index)
+		return nil
+	}
+	count := 0
+	for _, source := range e.sources {
+		policies, err := source.Policies(ctx, e.db)
+		if err != nil {
+			return err
+		}
+		for _, p := range policies {
+			index[p.Subject] = append(index[p.Subject], p)
+		}
+		count += len(policies)
+	}
+	e.policies.Store(&index)
+	e.l.Info("authorization policies loaded", "count", count)
+	return nil
+}
+
+// Watch 监听其他实例的变更通知并定期全量加载，ctx结束时退出
+func (e *Engine) Watch(ctx context.Context) {
+	var notified <-chan *redis.Message
+	if e.rdb != nil {
+		pubsub := e.rdb.Subscribe(ctx, reloadChannel)
+		defer pubsub.Close()
+		notified = pubsub.Channel()
+	}
+	ticker := time.NewTicker(e.cfg.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-notified:
+			if !ok {
+				return
+			}
+			if msg.Payload == e.instance {
+				continue
+			}
+			if err := e.load(ctx); err != nil {
+				e.l.Error("reload authorization policies failed", "err", err)
+			}
+		case <-ticker.C:
+			if err := e.load(ctx); err != nil {
+				e.l.Error("reload authorization policies failed", "err", err)
+			}
+		}
+	}
+}
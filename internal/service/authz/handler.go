@@ -0,0 +1,174 @@
+package authz
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/common"
+	"gorm.io/gorm"
+)
+
+// PolicyHandler 授权策略管理，修改后立即重新加载
+type PolicyHandler struct {
+	l     *slog.Logger
+	db    *gorm.DB
+	authz Authorizer
+	info  common.Info
+}
+
+func NewPolicyHandler(l *slog.Logger, db *gorm.DB, authz Authorizer, info common.Info) *PolicyHandler {
+	return &PolicyHandler{l: l, db: db, authz: authz, info: info}
+}
+
+func (h *PolicyHandler) Register(e *gin.Engine) {
+	e.POST("/policy/list", RequirePermission(h.authz, h.info, common.PermPolicyList), h.List)
+	e.POST("/policy", RequirePermission(h.authz, h.info, common.PermPolicyCreate), h.Add)
+	e.GET("/policy/:id", RequirePermission(h.authz, h.info, common.PermPolicyList), h.GetDetail)
+	e.PUT("/policy", RequirePermission(h.authz, h.info, common.PermPolicyUpdate), h.Update)
+	e.DELETE("/policy/:id", RequirePermission(h.authz, h.info, common.PermPolicyDelete), h.Del)
+	e.POST("/policy/reload", RequirePermission(h.authz, h.info, common.PermPolicyUpdate), h.Reload)
+}
+
+// List 策略列表，可按主体过滤
+func (h *PolicyHandler) List(c *gin.Context) {
+	type rBody struct {
+		common.Page
+		Subject string `json:"subject"`
+	}
+	var req rBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	query := h.db.Model(&Policy{})
+	if req.Subject != "" {
+		query = query.Where("subject = ?", req.Subject)
+	}
+	var data []Policy
+	var count int64
+	query.Count(&count).Order("id").Offset((req.Page.Page - 1) * req.Size).Limit(req.Size).Find(&data)
+
+	c.JSON(http.StatusOK, common.RespOk("get policy list success", gin.H{
+		"records": data,
+		"total":   count,
+	}, h.info))
+}
+
+// Add 添加策略
+func (h *PolicyHandler) Add(c *gin.Context) {
+	var p Policy
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+	if err := p.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+	p.Model = gorm.Model{}
+
+	if err := h.db.Create(&p).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("policy added", "id", p.ID, "subject", p.Subject, "object", p.Object, "action", p.Action, "effect", p.Effect, common.Operator(c))
+	h.reload(c)
+	c.JSON(http.StatusOK, common.RespOk("add policy success", p, h.info))
+}
+
+// GetDetail 策略详情
+func (h *PolicyHandler) GetDetail(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	var p Policy
+	if err := h.db.Where("id = ?", id).First(&p).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("policy not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("get policy detail success", p, h.info))
+}
+
+// Update 修改策略
+func (h *PolicyHandler) Update(c *gin.Context) {
+	var req Policy
+	if err := c.ShouldBindJSON(&req); err != nil || req.ID == 0 {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	var p Policy
+	if err := h.db.Where("id = ?", req.ID).First(&p).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("policy not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	req.Model = p.Model
+
+	if err := h.db.Save(&req).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("policy updated", "id", req.ID, "subject", req.Subject, "object", req.Object, "action", req.Action, "effect", req.Effect, common.Operator(c))
+	h.reload(c)
+	c.JSON(http.StatusOK, common.RespOk("update policy success", req, h.info))
+}
+
+// Del 删除策略
+func (h *PolicyHandler) Del(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	result := h.db.Unscoped().Delete(&Policy{}, id)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(result.Error.Error(), h.info))
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, common.RespErr("policy not found", h.info))
+		return
+	}
+
+	h.l.Info("policy deleted", "id", id, common.Operator(c))
+	h.reload(c)
+	c.JSON(http.StatusOK, common.RespOk("delete policy success", nil, h.info))
+}
+
+// Reload 手动重新加载策略，用于直接修改数据库之后
+func (h *PolicyHandler) Reload(c *gin.Context) {
+	if err := h.authz.Reload(c); err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	c.JSON(http.StatusOK, common.RespOk("reload policy success", nil, h.info))
+}
+
+// reload 数据已保存，重新加载失败时由定期加载兜底
+func (h *PolicyHandler) reload(c *gin.Context) {
+	if err := h.authz.Reload(c); err != nil {
+		h.l.Error("reload authorization policies failed", "err", err)
+	}
+}
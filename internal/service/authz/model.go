@@ -0,0 +1,186 @@
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 策略效果，deny优先于allow
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// 内置操作，接口权限码使用call，菜单和按钮使用view
+const (
+	ActionCall = "call"
+	ActionView = "view"
+)
+
+// Any 匹配任意主体、资源或操作
+const Any = "*"
+
+// 条件运算符
+const (
+	OpEq     = "eq"
+	OpNe     = "ne"
+	OpIn     = "in"
+	OpNotIn  = "not_in"
+	OpPrefix = "prefix"
+	// OpCIDR 属性值为IP且在任一网段内
+	OpCIDR = "cidr"
+	// OpBetween 属性值为整数且在[values[0], values[1]]内，如工作时间 env.hour
+	OpBetween = "between"
+)
+
+// 条件属性前缀，subject.* 为调用方属性，env.* 为请求上下文
+const (
+	attrSubject = "subject."
+	attrEnv     = "env."
+)
+
+var subjectPattern = regexp.MustCompile(`^(\*|(role|user|service_account):[0-9]+)$`)
+
+// Policy 授权策略，主体在域内对资源执行操作，满足全部条件时生效
+type Policy struct {
+	gorm.Model
+	// Subject 主体，role:1、user:1、service_account:1 或 *
+	Subject string `json:"subject" gorm:"size:64;index"`
+	// Domain 为空时对所有域生效，微应用菜单使用菜单所属微应用的key作为域，本服务接口的域为空
+	Domain string `json:"domain" gorm:"size:64"`
+	// Object 资源，接口权限码或菜单key，以*结尾时按前缀匹配
+	Object string `json:"object" gorm:"size:200"`
+	// Action 操作，call、view 或 *
+	Action     string      `json:"action" gorm:"size:32"`
+	Effect     string      `json:"effect" gorm:"size:8"`
+	Conditions []Condition `json:"conditions" gorm:"serializer:json"`
+	Remark     string      `json:"remark"`
+}
+
+// Condition 属性条件，如 {"attr":"env.ip","op":"cidr","values":["10.0.0.0/8"]}
+type Condition struct {
+	Attr   string   `json:"attr"`
+	Op     string   `json:"op"`
+	Values []string `json:"values"`
+}
+
+func (Policy) TableName() string {
+	return "authz_policy"
+}
+
+func InitPolicyTable(db *gorm.DB) {
+	db.AutoMigrate(&Policy{})
+}
+
+// RoleSubject 角色主体
+func RoleSubject(id uint) string {
+	return "role:" + strconv.FormatUint(uint64(id), 10)
+}
+
+func (p *Policy) Validate() error {
+	if !subjectPattern.MatchString(p.Subject) {
+		return errors.New("subject must be role:<id>, user:<id>, service_account:<id> or *")
+	}
+	if p.Object == "" {
+		return errors.New("object is required")
+	}
+	if p.Action == "" {
+		return errors.New("action is required")
+	}
+	if p.Effect == "" {
+		p.Effect = EffectAllow
+	}
+	if p.Effect != EffectAllow && p.Effect != EffectDeny {
+		return fmt.Errorf("unsupported effect: %s", p.Effect)
+	}
+	for _, cond := range p.Conditions {
+		if err := cond.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c Condition) validate() error {
+	if !strings.HasPrefix(c.Attr, attrSubject) && !strings.HasPrefix(c.Attr, attrEnv) {
+		return fmt.Errorf("condition attr must start with subject. or env.: %s", c.Attr)
+	}
+	switch c.Op {
+	case OpEq, OpNe, OpPrefix:
+		if len(c.Values) != 1 {
+			return fmt.Errorf("%s requires exactly one value", c.Op)
+		}
+	case OpIn, OpNotIn:
+		if len(c.Values) == 0 {
+			return fmt.Errorf("%s requires values", c.Op)
+		}
+	case OpCIDR:
+		for _, v := range c.Values {
+			if _, _, err := net.ParseCIDR(v); err != nil {
+				return fmt.Errorf("invalid cidr: %s", v)
+			}
+		}
+	case OpBetween:
+		if len(c.Values) != 2 {
+			return errors.New("between requires two values")
+		}
+		for _, v := range c.Values {
+			if _, err := strconv.Atoi(v); err != nil {
+				return fmt.Errorf("between requires integers: %s", v)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported condition op: %s", c.Op)
+	}
+	return nil
+}
+
+// match 属性值是否满足条件，属性不存在时按空字符串处理
+func (c Condition) match(value string) bool {
+	switch c.Op {
+	case OpEq:
+		return value == c.Values[0]
+	case OpNe:
+		return value != c.Values[0]
+	case OpIn:
+		return slices.Contains(c.Values, value)
+	case OpNotIn:
+		return !slices.Contains(c.Values, value)
+	case OpPrefix:
+		return strings.HasPrefix(value, c.Values[0])
+	case OpCIDR:
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return false
+		}
+		return slices.ContainsFunc(c.Values, func(v string) bool {
+			_, n, err := net.ParseCIDR(v)
+			return err == nil && n.Contains(ip)
+		})
+	case OpBetween:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return false
+		}
+		lo, _ := strconv.Atoi(c.Values[0])
+		hi, _ := strconv.Atoi(c.Values[1])
+		return n >= lo && n <= hi
+	}
+	return false
+}
+
+// matchPattern 精确匹配，* 匹配任意值，以*结尾时按前缀匹配
+func matchPattern(pattern, value string) bool {
+	if pattern == Any || pattern == value {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(pattern, Any)
+	return ok && strings.HasPrefix(value, prefix)
+}
@@ -0,0 +1,330 @@
+package common
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const defaultIssuer = "my-app"
+
+// JWTConfig JWT签名配置
+type JWTConfig struct {
+	Issuer    string `json:"issuer"`
+	ActiveKid string `json:"activekid"`
+	// IDTokenKid OIDC ID token使用的非对称密钥，为空时优先使用当前密钥，其次使用第一个非对称密钥
+	IDTokenKid string   `json:"idtokenkid"`
+	Keys       []JWTKey `json:"keys"`
+}
+
+// JWTKey 签名密钥，仅配置公钥时只用于验签
+type JWTKey struct {
+	Kid            string `json:"kid"`
+	Algorithm      string `json:"algorithm"`
+	Secret         string `json:"secret"`
+	PrivateKey     string `json:"privatekey"`
+	PrivateKeyFile string `json:"privatekeyfile"`
+	PublicKey      string `json:"publickey"`
+	PublicKeyFile  string `json:"publickeyfile"`
+}
+
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private any
+	public  any
+}
+
+// KeySet 签名和验签使用的密钥集合
+type KeySet struct {
+	issuer string
+	active *signingKey
+	// idToken ID token需要客户端通过JWKS验签，不能使用HS256密钥
+	idToken *signingKey
+	keys    map[string]*signingKey
+	// ordered 按配置顺序保存，用于稳定输出JWKS
+	ordered []*signingKey
+	// legacy 用于验证不带kid的HS256令牌，兼容Java服务
+	legacy []*signingKey
+}
+
+// JWK JSON Web Key
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var keySet = &KeySet{issuer: defaultIssuer, keys: map[string]*signingKey{}}
+
+// InitKeySet 加载密钥并设置为全局密钥集合
+func InitKeySet(cfg JWTConfig) error {
+	ks, err := NewKeySet(cfg)
+	if err != nil {
+		return err
+	}
+	keySet = ks
+	return nil
+}
+
+// NewKeySet 根据配置加载密钥
+func NewKeySet(cfg JWTConfig) (*KeySet, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("jwt keys not configured")
+	}
+
+	ks := &KeySet{
+		issuer: cfg.Issuer,
+		keys:   make(map[string]*signingKey, len(cfg.Keys)),
+	}
+	if ks.issuer == "" {
+		ks.issuer = defaultIssuer
+	}
+
+	for _, k := range cfg.Keys {
+		if k.Kid == "" {
+			return nil, errors.New("jwt key kid is required")
+		}
+		if _, ok := ks.keys[k.Kid]; ok {
+			return nil, fmt.Errorf("jwt key kid duplicated: %s", k.Kid)
+		}
+		sk, err := loadKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("load jwt key %s failed: %w", k.Kid, err)
+		}
+		ks.keys[k.Kid] = sk
+		ks.ordered = append(ks.ordered, sk)
+		if sk.method == jwt.SigningMethodHS256 {
+			ks.legacy = append(ks.legacy, sk)
+		}
+	}
+
+	activeKid := cfg.ActiveKid
+	if activeKid == "" {
+		activeKid = cfg.Keys[0].Kid
+	}
+	ks.active = ks.keys[activeKid]
+	if ks.active == nil {
+		return nil, fmt.Errorf("active jwt key not found: %s", activeKid)
+	}
+	if ks.active.private == nil {
+		return nil, fmt.Errorf("active jwt key has no private key: %s", activeKid)
+	}
+
+	if cfg.IDTokenKid != "" {
+		ks.idToken = ks.keys[cfg.IDTokenKid]
+		if ks.idToken == nil || ks.idToken.private == nil || !asymmetric(ks.idToken) {
+			return nil, fmt.Errorf("id token key must be an asymmetric key with private key: %s", cfg.IDTokenKid)
+		}
+	} else {
+		for _, k := range append([]*signingKey{ks.active}, ks.ordered...) {
+			if k.private != nil && asymmetric(k) {
+				ks.idToken = k
+				break
+			}
+		}
+	}
+	return ks, nil
+}
+
+// Issuer 令牌签发者
+func (ks *KeySet) Issuer() string {
+	return ks.issuer
+}
+
+// Sign 使用当前密钥签名，并写入kid头
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if ks.active == nil {
+		return "", errors.New("jwt key set not initialized")
+	}
+	token := jwt.NewWithClaims(ks.active.method, claims)
+	token.Header["kid"] = ks.active.kid
+	return token.SignedString(ks.active.private)
+}
+
+// SignIDToken 使用ID token密钥签名
+func (ks *KeySet) SignIDToken(claims jwt.Claims) (string, error) {
+	if ks.idToken == nil {
+		return "", errors.New("no asymmetric key configured for id token")
+	}
+	token := jwt.NewWithClaims(ks.idToken.method, claims)
+	token.Header["kid"] = ks.idToken.kid
+	return token.SignedString(ks.idToken.private)
+}
+
+// IDTokenAlg ID token的签名算法，未配置非对称密钥时为空
+func (ks *KeySet) IDTokenAlg() string {
+	if ks.idToken == nil {
+		return ""
+	}
+	return ks.idToken.method.Alg()
+}
+
+// Keyfunc 根据kid和算法选择验签密钥
+func (ks *KeySet) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		for _, k := range ks.legacy {
+			if k.method.Alg() == token.Method.Alg() {
+				return k.public, nil
+			}
+		}
+		return nil, fmt.Errorf("意外的签名方法: %v", token.Header["alg"])
+	}
+
+	k, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("未知的密钥: %s", kid)
+	}
+	if k.method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("意外的签名方法: %v", token.Header["alg"])
+	}
+	return k.public, nil
+}
+
+// JWKS 导出非对称密钥的公钥，HS256密钥不会导出
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.ordered))}
+	for _, k := range ks.ordered {
+		jwk, ok := toJWK(k)
+		if ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	return jwks
+}
+
+func asymmetric(k *signingKey) bool {
+	return k.method != jwt.SigningMethodHS256
+}
+
+// GetKeySet 获取全局密钥集合
+func GetKeySet() *KeySet {
+	return keySet
+}
+
+func loadKey(k JWTKey) (*signingKey, error) {
+	method := jwt.GetSigningMethod(k.Algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("algorithm not support: %s", k.Algorithm)
+	}
+	sk := &signingKey{kid: k.Kid, method: method}
+
+	if method == jwt.SigningMethodHS256 {
+		if k.Secret == "" {
+			return nil, errors.New("secret is required")
+		}
+		// 短密钥可以被离线暴力破解
+		if len(k.Secret) < 32 {
+			return nil, errors.New("secret must be at least 32 bytes")
+		}
+		secret := []byte(k.Secret)
+		sk.private, sk.public = secret, secret
+		return sk, nil
+	}
+
+	privatePEM, err := readPEM(k.PrivateKey, k.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	publicPEM, err := readPEM(k.PublicKey, k.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if privatePEM == nil && publicPEM == nil {
+		return nil, errors.New("private key or public key is required")
+	}
+
+	switch method {
+	case jwt.SigningMethodRS256:
+		if privatePEM != nil {
+			key, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+			if err != nil {
+				return nil, err
+			}
+			sk.private, sk.public = key, &key.PublicKey
+		} else if sk.public, err = jwt.ParseRSAPublicKeyFromPEM(publicPEM); err != nil {
+			return nil, err
+		}
+	case jwt.SigningMethodES256:
+		if privatePEM != nil {
+			key, err := jwt.ParseECPrivateKeyFromPEM(privatePEM)
+			if err != nil {
+				return nil, err
+			}
+			sk.private, sk.public = key, &key.PublicKey
+		} else if sk.public, err = jwt.ParseECPublicKeyFromPEM(publicPEM); err != nil {
+			return nil, err
+		}
+		if sk.public.(*ecdsa.PublicKey).Curve != elliptic.P256() {
+			return nil, errors.New("ES256 requires a P-256 key")
+		}
+	case jwt.SigningMethodEdDSA:
+		if privatePEM != nil {
+			key, err := jwt.ParseEdPrivateKeyFromPEM(privatePEM)
+			if err != nil {
+				return nil, err
+			}
+			sk.private, sk.public = key, key.(crypto.Signer).Public()
+		} else if sk.public, err = jwt.ParseEdPublicKeyFromPEM(publicPEM); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("algorithm not support: %s", k.Algorithm)
+	}
+	return sk, nil
+}
+
+// readPEM 优先使用配置内容，其次读取文件
+func readPEM(content, file string) ([]byte, error) {
+	if content != "" {
+		return []byte(content), nil
+	}
+	if file == "" {
+		return nil, nil
+	}
+	return os.ReadFile(file)
+}
+
+func toJWK(k *signingKey) (JWK, bool) {
+	jwk := JWK{Use: "sig", Alg: k.method.Alg(), Kid: k.kid}
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
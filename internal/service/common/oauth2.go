@@ -0,0 +1,33 @@
+package common
+
+import (
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientCredentials 读取client_secret_basic或client_secret_post方式提交的客户端凭据，basic表示使用了HTTP Basic认证
+// RFC 6749 2.3.1 要求Basic认证中的client_id和密钥做表单编码，解码失败时返回空的client_id
+func ClientCredentials(c *gin.Context) (clientID, secret string, basic bool) {
+	clientID, secret, basic = c.Request.BasicAuth()
+	if !basic {
+		return c.PostForm("client_id"), c.PostForm("client_secret"), false
+	}
+	id, err := url.QueryUnescape(clientID)
+	if err != nil {
+		return "", "", true
+	}
+	if secret, err = url.QueryUnescape(secret); err != nil {
+		return "", "", true
+	}
+	return id, secret, true
+}
+
+// TokenErr OAuth2令牌端点的错误响应，见RFC 6749 5.2
+func TokenErr(c *gin.Context, status int, code, desc string) {
+	resp := gin.H{"error": code}
+	if desc != "" {
+		resp["error_description"] = desc
+	}
+	c.JSON(status, resp)
+}
@@ -0,0 +1,34 @@
+package common
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 调用方类型
+const (
+	PrincipalUser           = "user"
+	PrincipalServiceAccount = "service_account"
+)
+
+// Operator 审计日志中的操作人，区分用户和服务账号
+func Operator(c *gin.Context) slog.Attr {
+	if id := c.GetUint("serviceAccountId"); id != 0 {
+		return slog.Group("operator", "type", PrincipalServiceAccount, "id", id, "name", c.GetString("username"))
+	}
+	return slog.Group("operator", "type", PrincipalUser, "id", c.GetUint("userId"), "name", c.GetString("username"))
+}
+
+// RequireUser 只允许用户调用，服务账号没有密码、会话和认证器，也不能代替用户管理个人令牌
+// 必须注册在认证中间件之后
+func RequireUser(info Info) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetUint("serviceAccountId") != 0 || c.GetUint("userId") == 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, RespErr("only users can call this api", info))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,146 @@
+package common
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ErrCodePermissionDenied 缺少接口权限时返回的错误码
+const ErrCodePermissionDenied = "PERMISSION_DENIED"
+
+// PermissionAll 拥有全部权限，resource:* 表示拥有该资源下的全部权限
+const PermissionAll = "*"
+
+// 接口权限码，格式为 资源:操作
+const (
+	PermUserList   = "user:list"
+	PermUserCreate = "user:create"
+	PermUserUpdate = "user:update"
+	PermUserDelete = "user:delete"
+	// PermUserRevoke 撤销用户令牌、会话以及解除登录锁定
+	PermUserRevoke = "user:revoke"
+
+	PermRoleList   = "role:list"
+	PermRoleCreate = "role:create"
+	PermRoleUpdate = "role:update"
+	PermRoleDelete = "role:delete"
+
+	PermMenuList   = "menu:list"
+	PermMenuCreate = "menu:create"
+	PermMenuUpdate = "menu:update"
+	PermMenuDelete = "menu:delete"
+
+	PermMicroAppList   = "micro-app:list"
+	PermMicroAppCreate = "micro-app:create"
+	PermMicroAppUpdate = "micro-app:update"
+	PermMicroAppDelete = "micro-app:delete"
+
+	PermOAuthClientList   = "oauth-client:list"
+	PermOAuthClientCreate = "oauth-client:create"
+	PermOAuthClientUpdate = "oauth-client:update"
+	PermOAuthClientDelete = "oauth-client:delete"
+
+	PermSAMLIdPList   = "saml-idp:list"
+	PermSAMLIdPCreate = "saml-idp:create"
+	PermSAMLIdPUpdate = "saml-idp:update"
+	PermSAMLIdPDelete = "saml-idp:delete"
+
+	PermFederationProviderList   = "federation-provider:list"
+	PermFederationProviderCreate = "federation-provider:create"
+	PermFederationProviderUpdate = "federation-provider:update"
+	PermFederationProviderDelete = "federation-provider:delete"
+
+	PermServiceAccountList   = "service-account:list"
+	PermServiceAccountCreate = "service-account:create"
+	PermServiceAccountUpdate = "service-account:update"
+	PermServiceAccountDelete = "service-account:delete"
+
+	PermPolicyList   = "policy:list"
+	PermPolicyCreate = "policy:create"
+	PermPolicyUpdate = "policy:update"
+	PermPolicyDelete = "policy:delete"
+
+	// PermPATManage 管理所有用户的个人访问令牌
+	PermPATManage = "pat:manage"
+)
+
+// Permission 权限码及说明，用于角色授权时展示
+type Permission struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// Permissions 所有接口权限码
+var Permissions = []Permission{
+	{PermUserList, "view users"},
+	{PermUserCreate, "create users"},
+	{PermUserUpdate, "update users and their roles"},
+	{PermUserDelete, "delete users"},
+	{PermUserRevoke, "revoke user sessions and unlock users"},
+	{PermRoleList, "view roles and permissions"},
+	{PermRoleCreate, "create roles"},
+	{PermRoleUpdate, "update roles"},
+	{PermRoleDelete, "delete roles"},
+	{PermMenuList, "view menus"},
+	{PermMenuCreate, "create menus"},
+	{PermMenuUpdate, "update menus"},
+	{PermMenuDelete, "delete menus"},
+	{PermMicroAppList, "view micro apps"},
+	{PermMicroAppCreate, "create micro apps"},
+	{PermMicroAppUpdate, "update micro apps"},
+	{PermMicroAppDelete, "delete micro apps"},
+	{PermOAuthClientList, "view oauth clients"},
+	{PermOAuthClientCreate, "register oauth clients"},
+	{PermOAuthClientUpdate, "update oauth clients and rotate secrets"},
+	{PermOAuthClientDelete, "delete oauth clients"},
+	{PermSAMLIdPList, "view saml identity providers"},
+	{PermSAMLIdPCreate, "create saml identity providers"},
+	{PermSAMLIdPUpdate, "update saml identity providers"},
+	{PermSAMLIdPDelete, "delete saml identity providers"},
+	{PermFederationProviderList, "view federation providers"},
+	{PermFederationProviderCreate, "create federation providers"},
+	{PermFederationProviderUpdate, "update federation providers"},
+	{PermFederationProviderDelete, "delete federation providers"},
+	{PermServiceAccountList, "view service accounts"},
+	{PermServiceAccountCreate, "create service accounts"},
+	{PermServiceAccountUpdate, "update service accounts and rotate secrets"},
+	{PermServiceAccountDelete, "delete service accounts"},
+	{PermPolicyList, "view authorization policies"},
+	{PermPolicyCreate, "create authorization policies"},
+	{PermPolicyUpdate, "update and reload authorization policies"},
+	{PermPolicyDelete, "delete authorization policies"},
+	{PermPATManage, "manage personal access tokens of all users"},
+}
+
+// ValidPermission 校验角色可以绑定的权限码，支持 * 和 resource:*
+func ValidPermission(code string) bool {
+	if code == PermissionAll {
+		return true
+	}
+	for _, p := range Permissions {
+		if p.Code == code || resourceOf(p.Code)+":*" == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsWildcardPermission 是否为 * 或 resource:* 通配权限码
+func IsWildcardPermission(code string) bool {
+	return code == PermissionAll || strings.HasSuffix(code, ":*")
+}
+
+// RespDenied 缺少权限的响应，返回缺少的权限码
+func RespDenied(code string, info any) map[string]any {
+	return map[string]any{
+		"code":      http.StatusForbidden,
+		"errorCode": ErrCodePermissionDenied,
+		"message":   "permission denied: " + code,
+		"info":      info,
+	}
+}
+
+func resourceOf(code string) string {
+	resource, _, _ := strings.Cut(code, ":")
+	return resource
+}
@@ -1,17 +1,19 @@
 package common
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var JWTSecret = []byte("123456")
-
 // ParseID 将字符串ID转换为uint类型，包含错误处理和边界检查
 func ParseID(id string) (uint, error) {
 	if id == "" {
@@ -32,6 +34,16 @@ func ParseID(id string) (uint, error) {
 	return uint(uid), nil
 }
 
+// ParseRootURL 校验配置的外部访问地址，必须包含协议和主机，返回去掉末尾斜杠的地址
+// 不根据请求的Host和X-Forwarded-Proto推断，避免客户端伪造签发者和回调地址
+func ParseRootURL(name, raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("%s must be an absolute url", name)
+	}
+	return strings.TrimSuffix(raw, "/"), nil
+}
+
 func RespOk(message string, data any, info any) map[string]any {
 	return map[string]any{
 		"code":    http.StatusOK,
@@ -50,45 +62,47 @@ func RespErr(message string, info any) map[string]any {
 }
 
 type CompatibleClaims struct {
-	UserID   uint     `json:"userId"`
-	Username string   `json:"username"`
-	Roles    []string `json:"roles"`
+	UserID    uint     `json:"userId"`
+	Username  string   `json:"username"`
+	Roles     []string `json:"roles"`
+	SessionID string   `json:"sid,omitempty"`
+	// ClientID 通过OAuth2客户端签发的令牌所属客户端
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	// ServiceAccountID 签发给服务账号的令牌所属账号，此时UserID为0
+	ServiceAccountID uint `json:"service_account_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// 生成与 Java 兼容的 JWT
-func GenerateCompatibleToken(userID uint, username string, roles []string) (string, error) {
-	// 确保密钥长度
-	secretKey := ensureKeyLength(JWTSecret)
-
-	claims := CompatibleClaims{
+// NewCompatibleClaims 构造与 Java 兼容的 claims
+func NewCompatibleClaims(userID uint, username string, roles []string, ttl time.Duration) CompatibleClaims {
+	return CompatibleClaims{
 		UserID:   userID,
 		Username: username,
 		Roles:    roles,
 		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    "my-app",                               // 必须与 Java 一致
+			Issuer:    keySet.Issuer(),                        // 必须与 Java 一致
 			Subject:   strconv.FormatUint(uint64(userID), 10), // 必须设置
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ID:        generateJWTID(), // 可选：JWT ID
 		},
 	}
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secretKey)
+// 生成与 Java 兼容的 JWT
+func GenerateCompatibleToken(userID uint, username string, roles []string) (string, error) {
+	return SignClaims(NewCompatibleClaims(userID, username, roles, 24*time.Hour))
+}
+
+// SignClaims 使用当前密钥签名
+func SignClaims(claims jwt.Claims) (string, error) {
+	return keySet.Sign(claims)
 }
 
 // 验证 Java 生成的 JWT
 func ValidateJavaJWT(tokenString string) (*CompatibleClaims, error) {
-	secretKey := ensureKeyLength(JWTSecret)
-
-	token, err := jwt.ParseWithClaims(tokenString, &CompatibleClaims{}, func(token *jwt.Token) (interface{}, error) {
-		// 验证签名算法
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("意外的签名方法: %v", token.Header["alg"])
-		}
-		return secretKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &CompatibleClaims{}, keySet.Keyfunc)
 
 	if err != nil {
 		return nil, err
@@ -96,7 +110,7 @@ func ValidateJavaJWT(tokenString string) (*CompatibleClaims, error) {
 
 	if claims, ok := token.Claims.(*CompatibleClaims); ok && token.Valid {
 		// 验证必要字段
-		if claims.Issuer != "my-app" {
+		if claims.Issuer != keySet.Issuer() {
 			return nil, fmt.Errorf("签发者不匹配")
 		}
 		if claims.Subject == "" {
@@ -108,17 +122,9 @@ func ValidateJavaJWT(tokenString string) (*CompatibleClaims, error) {
 	return nil, fmt.Errorf("令牌无效")
 }
 
-// 确保密钥长度合适
-func ensureKeyLength(secret []byte) []byte {
-	if len(secret) < 32 {
-		// 填充到 32 字节
-		newSecret := make([]byte, 32)
-		copy(newSecret, secret)
-		return newSecret
-	}
-	return secret
-}
-
+// generateJWTID 随机生成 JWT ID，用于撤销单个令牌
 func generateJWTID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
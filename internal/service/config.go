@@ -1,11 +1,29 @@
 package service
 
+import (
+	"github.com/z876730060/auth/internal/service/authn"
+	"github.com/z876730060/auth/internal/service/authz"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/federation"
+	"github.com/z876730060/auth/internal/service/login"
+	"github.com/z876730060/auth/internal/service/mailer"
+	"github.com/z876730060/auth/internal/service/oauth"
+	"github.com/z876730060/auth/internal/service/passkey"
+	"github.com/z876730060/auth/internal/service/password"
+	"github.com/z876730060/auth/internal/service/reset"
+	"github.com/z876730060/auth/internal/service/saml"
+	"github.com/z876730060/auth/internal/service/serviceaccount"
+	"github.com/z876730060/auth/internal/service/session"
+)
+
 // Config 配置
 type Config struct {
-	Application Application `json:"application"`
-	Cloud       Cloud       `json:"cloud"`
-	Redis       Redis       `json:"redis"`
-	DB          DB          `json:"db"`
+	Application Application   `json:"application"`
+	Cloud       Cloud         `json:"cloud"`
+	Redis       Redis         `json:"redis"`
+	DB          DB            `json:"db"`
+	Mail        mailer.Config `json:"mail"`
+	Security    Security      `json:"security"`
 }
 
 // Application 应用配置
@@ -15,6 +33,8 @@ type Application struct {
 	Port    int    `json:"port"`
 	Env     string `json:"env"`
 	Version string `json:"version"`
+	// TrustedProxies 信任其 X-Forwarded-For/X-Real-IP 的反向代理IP或网段，为空时不信任任何代理，客户端IP取连接地址
+	TrustedProxies []string `json:"trustedproxies"`
 }
 
 // Cloud 微服务配置
@@ -60,3 +80,37 @@ type Redis struct {
 	Port   uint64 `json:"port"`
 	DB     int    `json:"db"`
 }
+
+// Security 安全配置
+type Security struct {
+	Password       password.Config       `json:"password"`
+	JWT            common.JWTConfig      `json:"jwt"`
+	Token          session.Config        `json:"token"`
+	Login          login.Config          `json:"login"`
+	WebAuthn       passkey.Config        `json:"webauthn"`
+	OAuth          oauth.Config          `json:"oauth"`
+	Gateway        Gateway               `json:"gateway"`
+	Authn          authn.Config          `json:"authn"`
+	SAML           saml.Config           `json:"saml"`
+	Federation     federation.Config     `json:"federation"`
+	ServiceAccount serviceaccount.Config `json:"serviceaccount"`
+	Reset          reset.Config          `json:"reset"`
+	Authz          authz.Config          `json:"authz"`
+}
+
+// Gateway 网关转发认证配置
+type Gateway struct {
+	// CheckMenu 校验转发的URI是否在用户角色的菜单权限内，未对应任何菜单且不在PublicPaths中的URI拒绝访问
+	CheckMenu bool `json:"checkmenu"`
+	// PublicPaths 登录后即可访问、不需要菜单权限的路径前缀
+	PublicPaths []string `json:"publicpaths"`
+	// Apps 按网关转发的Host确定微应用，未配置的Host在所有菜单中匹配
+	Apps []GatewayApp `json:"apps"`
+}
+
+// GatewayApp 网关Host对应的微应用
+type GatewayApp struct {
+	Host string `json:"host"`
+	// App 微应用key
+	App string `json:"app"`
+}
@@ -0,0 +1,33 @@
+package federation
+
+import "time"
+
+// Config 外部身份源登录配置
+type Config struct {
+	// RootURL 本服务的外部访问地址，用于生成回调地址 {rooturl}/federation/{key}/callback
+	RootURL string `json:"rooturl"`
+	// CallbackURL 前端回调页，登录成功时带上code参数，绑定成功时带上linked参数，失败时带上error参数
+	CallbackURL string `json:"callbackurl"`
+	// StateTTL 跳转到身份源后完成登录的时限
+	StateTTL time.Duration `json:"statettl"`
+	// Timeout 请求身份源的超时时间
+	Timeout time.Duration `json:"timeout"`
+	// Providers 配置文件中的身份源，优先于数据库中同名的身份源，不能通过接口修改
+	Providers []Provider `json:"providers"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.CallbackURL == "" {
+		c.CallbackURL = "/sso/callback"
+	}
+	if c.StateTTL == 0 {
+		c.StateTTL = 10 * time.Minute
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+	for i := range c.Providers {
+		c.Providers[i].Enabled = true
+	}
+	return c
+}
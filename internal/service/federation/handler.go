@@ -0,0 +1,459 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-contrib/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+const stateKey = "federation:state:%s"
+
+// 回调前端时的错误码
+const (
+	errInvalidProvider = "invalid_provider"
+	errInvalidState    = "invalid_state"
+	errAccessDenied    = "access_denied"
+	errInvalidResponse = "invalid_response"
+	errNotLinked       = "not_linked"
+	errIdentityInUse   = "identity_in_use"
+	errAccountConflict = "account_conflict"
+	errMissingUsername = "missing_username"
+	errServerError     = "server_error"
+)
+
+var (
+	errUsernameTaken = errors.New("username already exists")
+	errNoUsername    = errors.New("external user has no username")
+	// errLastLoginMethod 没有密码的账号至少保留一个外部身份
+	errLastLoginMethod = errors.New("cannot unlink the last login method")
+)
+
+// CodeIssuer 签发一次性登录码，由login.Handler实现
+type CodeIssuer interface {
+	NewSSOCode(ctx context.Context, u *user.User) (string, error)
+}
+
+// Handler 外部身份源登录和身份绑定
+type Handler struct {
+	l      *slog.Logger
+	db     *gorm.DB
+	rdb    *redis.Client
+	codes  CodeIssuer
+	info   common.Info
+	cfg    Config
+	client *http.Client
+
+	mu        sync.Mutex
+	upstreams map[string]cachedUpstream
+}
+
+// cachedUpstream 缓存OIDC发现结果，身份源配置变化后重新创建
+type cachedUpstream struct {
+	updatedAt time.Time
+	upstream  upstream
+}
+
+func NewHandler(l *slog.Logger, db *gorm.DB, rdb *redis.Client, codes CodeIssuer, info common.Info, cfg Config) (*Handler, error) {
+	cfg = cfg.withDefaults()
+	var err error
+	if cfg.RootURL, err = common.ParseRootURL("federation rooturl", cfg.RootURL); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, p := range cfg.Providers {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("federation provider %q: %w", p.Key, err)
+		}
+		if seen[p.Key] {
+			return nil, fmt.Errorf("duplicate federation provider %q", p.Key)
+		}
+		seen[p.Key] = true
+	}
+	return &Handler{
+		l:         l,
+		db:        db,
+		rdb:       rdb,
+		codes:     codes,
+		info:      info,
+		cfg:       cfg,
+		client:    &http.Client{Timeout: cfg.Timeout},
+		upstreams: make(map[string]cachedUpstream),
+	}, nil
+}
+
+func (h *Handler) Register(e *gin.Engine) {
+	e.GET("/federation/providers", h.Providers)
+	e.GET("/federation/:provider/login", h.Login)
+	e.GET("/federation/:provider/callback", h.Callback)
+}
+
+func (h *Handler) RegisterProtected(e *gin.Engine) {
+	e.GET("/federation/identity", h.Identities)
+	e.POST("/federation/:provider/link", h.Link)
+	e.DELETE("/federation/identity/:id", h.Unlink)
+}
+
+// Providers 登录页展示的身份源列表
+func (h *Handler) Providers(c *gin.Context) {
+	type item struct {
+		Key  string `json:"key"`
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+
+	var stored []Provider
+	if err := h.db.Where("enabled = ?", true).Order("id").Find(&stored).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	data := make([]item, 0, len(h.cfg.Providers)+len(stored))
+	seen := make(map[string]bool)
+	for _, p := range slices.Concat(h.cfg.Providers, stored) {
+		if seen[p.Key] {
+			continue
+		}
+		seen[p.Key] = true
+		data = append(data, item{Key: p.Key, Name: p.Name, Type: p.Type})
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("get federation provider list success", data, h.info))
+}
+
+// Login 跳转到身份源登录，redirect参数为登录完成后前端跳转的页面
+func (h *Handler) Login(c *gin.Context) {
+	p, err := h.findProvider(c.Param("provider"))
+	if err != nil {
+		h.fail(c, errInvalidProvider, err)
+		return
+	}
+	location, err := h.authorize(c, p, c.Query("redirect"), 0)
+	if err != nil {
+		h.fail(c, errServerError, err)
+		return
+	}
+	c.Redirect(http.StatusFound, location)
+}
+
+// Callback 身份源回调，校验state后换取用户信息，登录或绑定身份后跳回前端
+func (h *Handler) Callback(c *gin.Context) {
+	p, err := h.findProvider(c.Param("provider"))
+	if err != nil {
+		h.fail(c, errInvalidProvider, err)
+		return
+	}
+
+	// state只能使用一次，且必须由同一身份源发出
+	pending, err := h.takeState(c, c.Query("state"))
+	if err != nil {
+		h.fail(c, errServerError, err)
+		return
+	}
+	if pending == nil || pending.Provider != p.Key {
+		h.fail(c, errInvalidState, errors.New("unknown or expired state"))
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		h.fail(c, errAccessDenied, fmt.Errorf("provider returned error: %s", e))
+		return
+	}
+
+	up, err := h.upstream(c, p)
+	if err != nil {
+		h.fail(c, errServerError, err)
+		return
+	}
+	ext, err := up.exchange(oidc.ClientContext(c, h.client), c.Query("code"), pending)
+	if err != nil {
+		h.fail(c, errInvalidResponse, err)
+		return
+	}
+	if ext.Subject == "" {
+		h.fail(c, errInvalidResponse, errors.New("external user has no subject"))
+		return
+	}
+
+	if pending.LinkUserID != 0 {
+		h.link(c, p, ext, pending)
+		return
+	}
+	h.login(c, p, ext, pending)
+}
+
+// login 已绑定的身份直接登录，未绑定时按配置自动创建账号
+func (h *Handler) login(c *gin.Context, p *Provider, ext *externalUser, pending *pendingState) {
+	u, err := h.linkedUser(c, p, ext)
+	if err != nil {
+		h.fail(c, errServerError, err)
+		return
+	}
+	if u == nil {
+		if !p.allowProvision(ext) {
+			h.fail(c, errNotLinked, fmt.Errorf("identity %s is not linked to any user", ext.Subject))
+			return
+		}
+		u, err = h.provision(c, p, ext)
+		if err != nil {
+			switch {
+			case errors.Is(err, errUsernameTaken):
+				h.fail(c, errAccountConflict, err)
+			case errors.Is(err, errNoUsername):
+				h.fail(c, errMissingUsername, err)
+			default:
+				h.fail(c, errServerError, err)
+			}
+			return
+		}
+		h.l.Info("federated user provisioned", "provider", p.Key, "userId", u.ID, "username", u.Username, "requestId", requestid.Get(c))
+	}
+
+	code, err := h.codes.NewSSOCode(c, u)
+	if err != nil {
+		h.fail(c, errServerError, err)
+		return
+	}
+
+	h.l.Info("federated login", "provider", p.Key, "userId", u.ID, "username", u.Username, "requestId", requestid.Get(c))
+	c.Redirect(http.StatusFound, h.callback(url.Values{"code": {code}, "redirect": {pending.Redirect}}))
+}
+
+// link 为发起绑定的用户记录外部身份，已绑定其他用户的身份不能重复绑定
+func (h *Handler) link(c *gin.Context, p *Provider, ext *externalUser, pending *pendingState) {
+	var identity Identity
+	err := h.db.Where(Identity{Provider: p.Key, Subject: ext.Subject}).First(&identity).Error
+	switch {
+	case err == nil && identity.UserID != pending.LinkUserID:
+		h.fail(c, errIdentityInUse, fmt.Errorf("identity %s is linked to user %d", ext.Subject, identity.UserID))
+		return
+	case err == nil:
+		identity.Username, identity.Email = ext.Username, ext.Email
+		err = h.db.Save(&identity).Error
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		identity = Identity{UserID: pending.LinkUserID, Provider: p.Key, Subject: ext.Subject, Username: ext.Username, Email: ext.Email}
+		err = h.db.Create(&identity).Error
+	}
+	if err != nil {
+		h.fail(c, errServerError, err)
+		return
+	}
+
+	h.l.Info("federated identity linked", "provider", p.Key, "userId", pending.LinkUserID, "subject", ext.Subject, "requestId", requestid.Get(c))
+	c.Redirect(http.StatusFound, h.callback(url.Values{"linked": {p.Key}, "redirect": {pending.Redirect}}))
+}
+
+// linkedUser 查找外部身份绑定的用户并更新身份信息，未绑定时返回nil
+func (h *Handler) linkedUser(ctx context.Context, p *Provider, ext *externalUser) (*user.User, error) {
+	var identity Identity
+	err := h.db.WithContext(ctx).Where(Identity{Provider: p.Key, Subject: ext.Subject}).First(&identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var u user.User
+	err = h.db.WithContext(ctx).Where("id = ?", identity.UserID).First(&u).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	identity.Username, identity.Email, identity.LastLoginAt = ext.Username, ext.Email, &now
+	if err := h.db.WithContext(ctx).Save(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// provision 自动创建账号并绑定外部身份，不会接管同名的已有账号
+func (h *Handler) provision(ctx context.Context, p *Provider, ext *externalUser) (*user.User, error) {
+	username := ext.Username
+	if username == "" {
+		username, _, _ = strings.Cut(ext.Email, "@")
+	}
+	if username == "" {
+		return nil, errNoUsername
+	}
+
+	u := user.User{
+		Username: username,
+		Fullname: ext.Fullname,
+		Email:    ext.Email,
+		Phone:    ext.Phone,
+		Source:   p.Source(),
+	}
+	err := h.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&user.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: %s", errUsernameTaken, username)
+		}
+		if err := user.CheckRoleSync(tx, p.DefaultRoles, nil); err != nil {
+			return err
+		}
+		if err := tx.Create(&u).Error; err != nil {
+			return err
+		}
+		for _, id := range p.DefaultRoles {
+			if err := tx.Create(&user.UserRole{UserID: u.ID, RoleID: id}).Error; err != nil {
+				return err
+			}
+		}
+
+		// 绑定的用户已删除时身份记录仍然存在，先清理
+		if err := tx.Unscoped().Where(Identity{Provider: p.Key, Subject: ext.Subject}).Delete(&Identity{}).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		return tx.Create(&Identity{
+			UserID:      u.ID,
+			Provider:    p.Key,
+			Subject:     ext.Subject,
+			Username:    ext.Username,
+			Email:       ext.Email,
+			LastLoginAt: &now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// authorize 生成state、nonce和PKCE校验码并返回身份源授权地址
+func (h *Handler) authorize(c *gin.Context, p *Provider, redirect string, linkUserID uint) (string, error) {
+	up, err := h.upstream(c, p)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := common.RandomString(32)
+	if err != nil {
+		return "", err
+	}
+	pending := pendingState{
+		Provider:   p.Key,
+		Redirect:   safeRedirect(redirect),
+		LinkUserID: linkUserID,
+	}
+	if pending.Nonce, err = common.RandomString(32); err != nil {
+		return "", err
+	}
+	if pending.Verifier, err = common.RandomString(32); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return "", err
+	}
+	if err := h.rdb.Set(c, fmt.Sprintf(stateKey, common.HashKey(state)), data, h.cfg.StateTTL).Err(); err != nil {
+		return "", err
+	}
+	return up.authCodeURL(state, pending.Nonce, pending.Verifier), nil
+}
+
+// takeState 取出并删除state对应的请求
+func (h *Handler) takeState(ctx context.Context, state string) (*pendingState, error) {
+	if state == "" {
+		return nil, nil
+	}
+	data, err := h.rdb.GetDel(ctx, fmt.Sprintf(stateKey, common.HashKey(state))).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pending pendingState
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, err
+	}
+	return &pending, nil
+}
+
+// findProvider 配置文件中的身份源优先，其次为数据库中启用的身份源
+func (h *Handler) findProvider(key string) (*Provider, error) {
+	for i := range h.cfg.Providers {
+		if h.cfg.Providers[i].Key == key {
+			return &h.cfg.Providers[i], nil
+		}
+	}
+	var p Provider
+	if err := h.db.Where(&Provider{Key: key, Enabled: true}).First(&p).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// upstream 获取身份源的协议实现，OIDC发现结果在配置未变化时复用
+func (h *Handler) upstream(c *gin.Context, p *Provider) (upstream, error) {
+	h.mu.Lock()
+	cached, ok := h.upstreams[p.Key]
+	h.mu.Unlock()
+	if ok && cached.updatedAt.Equal(p.UpdatedAt) {
+		return cached.upstream, nil
+	}
+
+	up, err := newUpstream(oidc.ClientContext(c, h.client), p, h.cfg.RootURL+"/federation/"+p.Key+"/callback")
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	h.upstreams[p.Key] = cachedUpstream{updatedAt: p.UpdatedAt, upstream: up}
+	h.mu.Unlock()
+	return up, nil
+}
+
+// fail 记录错误并带错误码跳回前端回调页
+func (h *Handler) fail(c *gin.Context, code string, err error) {
+	if code == errServerError {
+		h.l.Error("federated login failed", "provider", c.Param("provider"), "err", err, "requestId", requestid.Get(c))
+	} else {
+		h.l.Warn("federated login rejected", "provider", c.Param("provider"), "error", code, "err", err, "requestId", requestid.Get(c))
+	}
+	c.Redirect(http.StatusFound, h.callback(url.Values{"error": {code}}))
+}
+
+func (h *Handler) callback(params url.Values) string {
+	u, err := url.Parse(h.cfg.CallbackURL)
+	if err != nil {
+		return h.cfg.CallbackURL
+	}
+	query := u.Query()
+	for k, v := range params {
+		if len(v) > 0 && v[0] != "" {
+			query[k] = v
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// safeRedirect 只允许站内相对路径，防止开放重定向
+func safeRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return ""
+	}
+	return redirect
+}
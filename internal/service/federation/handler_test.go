@@ -0,0 +1,516 @@
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/role"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testRootURL  = "http://auth.test"
+	testClientID = "auth-client"
+)
+
+// testIdP httptest实现的OIDC身份源，授权码按PKCE校验码一次性换取id_token
+type testIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]idpGrant
+	// userinfo access token对应的userinfo响应
+	userinfo map[string]map[string]any
+}
+
+// idpGrant 签发授权码时记录的请求
+type idpGrant struct {
+	nonce     string
+	challenge string
+	claims    map[string]any
+	// thin id_token只包含sub，其余信息通过userinfo返回
+	thin bool
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{t: t, key: key, codes: make(map[string]idpGrant), userinfo: make(map[string]map[string]any)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"userinfo_endpoint":                     idp.server.URL + "/userinfo",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "k1", Algorithm: "RS256", Use: "sig"}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		claims, ok := idp.userinfo[r.Header.Get("Authorization")]
+		idp.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeJSON(w, claims)
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// grant 模拟用户在身份源完成登录，返回授权码
+func (idp *testIdP) grant(g idpGrant) string {
+	code, err := common.RandomString(16)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.codes[code] = g
+	idp.mu.Unlock()
+	return code
+}
+
+func (idp *testIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	idp.mu.Lock()
+	g, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge ||
+		r.PostForm.Get("redirect_uri") != testRootURL+"/federation/test/callback" {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss":   idp.server.URL,
+		"aud":   testClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Minute).Unix(),
+		"nonce": g.nonce,
+		"sub":   g.claims["sub"],
+	}
+	if !g.thin {
+		maps.Copy(claims, g.claims)
+	}
+	accessToken, err := common.RandomString(16)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	idp.mu.Lock()
+	idp.userinfo["Bearer "+accessToken] = g.claims
+	idp.mu.Unlock()
+
+	writeJSON(w, map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idp.sign(claims),
+	})
+}
+
+func (idp *testIdP) sign(claims map[string]any) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: idp.key, KeyID: "k1"}}, nil)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	raw, err := jws.CompactSerialize()
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return raw
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// fakeCodes 以用户名作为一次性登录码
+type fakeCodes struct{}
+
+func (fakeCodes) NewSSOCode(_ context.Context, u *user.User) (string, error) {
+	return "code-" + u.Username, nil
+}
+
+type testEnv struct {
+	idp    *testIdP
+	db     *gorm.DB
+	engine *gin.Engine
+}
+
+// newTestEnv 配置test和other两个指向同一身份源的OIDC身份源，test允许自动创建账号
+func newTestEnv(t *testing.T, configure func(p *Provider)) *testEnv {
+	t.Helper()
+	idp := newTestIdP(t)
+
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&role.Role{}, &user.User{}, &user.UserRole{}, &Provider{}, &Identity{}); err != nil {
+		t.Fatal(err)
+	}
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	provider := Provider{
+		Key:           "test",
+		Name:          "Test",
+		Type:          TypeOIDC,
+		Issuer:        idp.server.URL,
+		ClientID:      testClientID,
+		ClientSecret:  "secret",
+		AutoProvision: true,
+		DefaultRoles:  []uint{7},
+	}
+	if configure != nil {
+		configure(&provider)
+	}
+	other := Provider{Key: "other", Name: "Other", Type: TypeOIDC, Issuer: idp.server.URL, ClientID: testClientID}
+
+	h, err := NewHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), db, rdb, fakeCodes{}, common.Info{}, Config{
+		RootURL:   testRootURL,
+		Providers: []Provider{provider, other},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	h.Register(e)
+	// 绑定接口由X-User-Id模拟已登录的用户
+	e.POST("/federation/:provider/link", func(c *gin.Context) {
+		id, _ := common.ParseID(c.GetHeader("X-User-Id"))
+		c.Set("userId", id)
+	}, h.Link)
+	return &testEnv{idp: idp, db: db, engine: e}
+}
+
+func (env *testEnv) do(t *testing.T, method, target string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	if method == http.MethodPost {
+		req = httptest.NewRequest(method, target, strings.NewReader(`{"redirect":"/profile"}`))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	maps.Copy(req.Header, header)
+	w := httptest.NewRecorder()
+	env.engine.ServeHTTP(w, req)
+	return w
+}
+
+// authorizeQuery 跳转到身份源的授权参数
+func authorizeQuery(t *testing.T, location string) url.Values {
+	t.Helper()
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("state") == "" || q.Get("nonce") == "" || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorize url: %s", location)
+	}
+	return q
+}
+
+// login 发起登录并返回state和授权参数
+func (env *testEnv) login(t *testing.T, provider string) url.Values {
+	t.Helper()
+	w := env.do(t, http.MethodGet, "/federation/"+provider+"/login?redirect=/home", nil)
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d, body = %s", w.Code, w.Body)
+	}
+	return authorizeQuery(t, w.Header().Get("Location"))
+}
+
+// callback 携带授权码回调，返回跳转到前端回调页的参数
+func (env *testEnv) callback(t *testing.T, provider, state, code string) url.Values {
+	t.Helper()
+	w := env.do(t, http.MethodGet, "/federation/"+provider+"/callback?"+url.Values{"state": {state}, "code": {code}}.Encode(), nil)
+	if w.Code != http.StatusFound {
+		t.Fatalf("callback status = %d, body = %s", w.Code, w.Body)
+	}
+	u, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/sso/callback" {
+		t.Fatalf("callback redirected to %s", u)
+	}
+	return u.Query()
+}
+
+// signIn 完成一次完整的登录流程
+func (env *testEnv) signIn(t *testing.T, claims map[string]any) url.Values {
+	t.Helper()
+	q := env.login(t, "test")
+	code := env.idp.grant(idpGrant{nonce: q.Get("nonce"), challenge: q.Get("code_challenge"), claims: claims})
+	return env.callback(t, "test", q.Get("state"), code)
+}
+
+func aliceClaims() map[string]any {
+	return map[string]any{
+		"sub":                "alice-sub",
+		"preferred_username": "alice",
+		"name":               "Alice",
+		"email":              "alice@example.com",
+		"email_verified":     true,
+	}
+}
+
+func expectError(t *testing.T, got url.Values, want string) {
+	t.Helper()
+	if got.Get("error") != want || got.Get("code") != "" {
+		t.Fatalf("callback params = %v, want error %s", got, want)
+	}
+}
+
+func TestCallbackProvision(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	got := env.signIn(t, aliceClaims())
+	if got.Get("code") != "code-alice" || got.Get("redirect") != "/home" {
+		t.Fatalf("callback params = %v", got)
+	}
+
+	var u user.User
+	if err := env.db.Where("username = ?", "alice").First(&u).Error; err != nil {
+		t.Fatal(err)
+	}
+	if u.Source != "federation:test" || u.Fullname != "Alice" || u.Email != "alice@example.com" {
+		t.Fatalf("unexpected user: %+v", u)
+	}
+	var roles []uint
+	env.db.Model(&user.UserRole{}).Where("user_id = ?", u.ID).Pluck("role_id", &roles)
+	if !slices.Equal(roles, []uint{7}) {
+		t.Fatalf("roles = %v, want [7]", roles)
+	}
+
+	// 再次登录使用已绑定的身份，不重复创建账号
+	if got := env.signIn(t, aliceClaims()); got.Get("code") != "code-alice" {
+		t.Fatalf("second login params = %v", got)
+	}
+	var count int64
+	env.db.Model(&user.User{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("%d users after second login, want 1", count)
+	}
+}
+
+func TestCallbackProvisionSuperAdminRole(t *testing.T) {
+	env := newTestEnv(t, nil)
+	// 默认角色是超级管理员角色时不能自动创建账号
+	if err := env.db.Create(&role.Role{Model: gorm.Model{ID: 7}, Name: "admin", IsSuperAdmin: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	expectError(t, env.signIn(t, aliceClaims()), errServerError)
+	var count int64
+	env.db.Model(&user.User{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d users provisioned, want 0", count)
+	}
+}
+
+func TestCallbackUserInfo(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	q := env.login(t, "test")
+	code := env.idp.grant(idpGrant{nonce: q.Get("nonce"), challenge: q.Get("code_challenge"), claims: aliceClaims(), thin: true})
+	if got := env.callback(t, "test", q.Get("state"), code); got.Get("code") != "code-alice" {
+		t.Fatalf("callback params = %v", got)
+	}
+}
+
+func TestCallbackState(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	expectError(t, env.callback(t, "test", "", "x"), errInvalidState)
+	expectError(t, env.callback(t, "test", "forged", "x"), errInvalidState)
+
+	// state只能使用一次
+	q := env.login(t, "test")
+	code := env.idp.grant(idpGrant{nonce: q.Get("nonce"), challenge: q.Get("code_challenge"), claims: aliceClaims()})
+	if got := env.callback(t, "test", q.Get("state"), code); got.Get("code") == "" {
+		t.Fatalf("callback params = %v", got)
+	}
+	expectError(t, env.callback(t, "test", q.Get("state"), code), errInvalidState)
+
+	// 其他身份源发出的state不能用于本身份源的回调
+	q = env.login(t, "other")
+	code = env.idp.grant(idpGrant{nonce: q.Get("nonce"), challenge: q.Get("code_challenge"), claims: aliceClaims()})
+	expectError(t, env.callback(t, "test", q.Get("state"), code), errInvalidState)
+}
+
+func TestCallbackProviderError(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	q := env.login(t, "test")
+	w := env.do(t, http.MethodGet, "/federation/test/callback?"+url.Values{"state": {q.Get("state")}, "error": {"access_denied"}}.Encode(), nil)
+	u, _ := url.Parse(w.Header().Get("Location"))
+	expectError(t, u.Query(), errAccessDenied)
+}
+
+func TestCallbackNonce(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	q := env.login(t, "test")
+	code := env.idp.grant(idpGrant{nonce: "replayed-nonce", challenge: q.Get("code_challenge"), claims: aliceClaims()})
+	expectError(t, env.callback(t, "test", q.Get("state"), code), errInvalidResponse)
+}
+
+func TestCallbackPKCE(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	// 授权码被截获后由其他会话的state兑换
+	victim := env.login(t, "test")
+	code := env.idp.grant(idpGrant{nonce: victim.Get("nonce"), challenge: victim.Get("code_challenge"), claims: aliceClaims()})
+	attacker := env.login(t, "test")
+	expectError(t, env.callback(t, "test", attacker.Get("state"), code), errInvalidResponse)
+}
+
+func TestCallbackNotLinked(t *testing.T) {
+	env := newTestEnv(t, func(p *Provider) { p.AutoProvision = false })
+
+	expectError(t, env.signIn(t, aliceClaims()), errNotLinked)
+	var count int64
+	env.db.Model(&user.User{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d users provisioned without auto provision", count)
+	}
+}
+
+func TestCallbackAccountConflict(t *testing.T) {
+	env := newTestEnv(t, nil)
+	local := user.User{Username: "alice", Fullname: "Local Alice", Password: "hashed"}
+	if err := env.db.Create(&local).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	expectError(t, env.signIn(t, aliceClaims()), errAccountConflict)
+	var count int64
+	env.db.Model(&Identity{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("identity linked to existing local account")
+	}
+}
+
+func TestCallbackAllowedDomains(t *testing.T) {
+	env := newTestEnv(t, func(p *Provider) { p.AllowedDomains = []string{"example.com"} })
+
+	claims := aliceClaims()
+	claims["email_verified"] = false
+	expectError(t, env.signIn(t, claims), errNotLinked)
+
+	claims = aliceClaims()
+	claims["email"] = "alice@example.com.evil.io"
+	expectError(t, env.signIn(t, claims), errNotLinked)
+
+	claims = aliceClaims()
+	claims["email"] = "alice@EXAMPLE.com"
+	claims["email_verified"] = "true"
+	if got := env.signIn(t, claims); got.Get("code") != "code-alice" {
+		t.Fatalf("callback params = %v", got)
+	}
+}
+
+func TestCallbackLink(t *testing.T) {
+	env := newTestEnv(t, func(p *Provider) { p.AutoProvision = false })
+	bob := user.User{Username: "bob", Password: "hashed"}
+	carol := user.User{Username: "carol", Password: "hashed"}
+	if err := env.db.Create(&bob).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := env.db.Create(&carol).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	link := func(u user.User) url.Values {
+		t.Helper()
+		w := env.do(t, http.MethodPost, "/federation/test/link", http.Header{"X-User-Id": {strconv.FormatUint(uint64(u.ID), 10)}})
+		var resp struct {
+			Data struct {
+				URL string `json:"url"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("link status = %d, body = %s", w.Code, w.Body)
+		}
+		q := authorizeQuery(t, resp.Data.URL)
+		code := env.idp.grant(idpGrant{nonce: q.Get("nonce"), challenge: q.Get("code_challenge"), claims: aliceClaims()})
+		return env.callback(t, "test", q.Get("state"), code)
+	}
+
+	if got := link(bob); got.Get("linked") != "test" || got.Get("redirect") != "/profile" {
+		t.Fatalf("link params = %v", got)
+	}
+	// 已绑定的身份直接登录为绑定的用户
+	if got := env.signIn(t, aliceClaims()); got.Get("code") != "code-bob" {
+		t.Fatalf("login params = %v", got)
+	}
+	// 同一身份不能再绑定到其他用户
+	expectError(t, link(carol), errIdentityInUse)
+
+	var identities []Identity
+	env.db.Find(&identities)
+	if len(identities) != 1 || identities[0].UserID != bob.ID || identities[0].Subject != "alice-sub" {
+		t.Fatalf("identities = %+v", identities)
+	}
+}
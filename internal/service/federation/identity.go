@@ -0,0 +1,94 @@
+package federation
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+// Identities 当前用户绑定的外部身份
+func (h *Handler) Identities(c *gin.Context) {
+	var data []Identity
+	if err := h.db.Where("user_id = ?", c.GetUint("userId")).Order("id").Find(&data).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	c.JSON(http.StatusOK, common.RespOk("get identity list success", data, h.info))
+}
+
+// Link 为当前用户绑定外部身份，返回身份源授权地址，由前端跳转
+func (h *Handler) Link(c *gin.Context) {
+	type rBody struct {
+		Redirect string `json:"redirect"`
+	}
+	var req rBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	p, err := h.findProvider(c.Param("provider"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("federation provider not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	location, err := h.authorize(c, p, req.Redirect, c.GetUint("userId"))
+	if err != nil {
+		h.l.Error("start identity link failed", "provider", p.Key, "err", err)
+		c.JSON(http.StatusInternalServerError, common.RespErr("start identity link failed", h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("start identity link success", gin.H{"url": location}, h.info))
+}
+
+// Unlink 解绑当前用户的外部身份，没有密码时不能解绑最后一个身份
+func (h *Handler) Unlink(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+	uid := c.GetUint("userId")
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var identity Identity
+		if err := tx.Where("id = ? AND user_id = ?", id, uid).First(&identity).Error; err != nil {
+			return err
+		}
+		var u user.User
+		if err := tx.Where("id = ?", uid).First(&u).Error; err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&Identity{}).Where("user_id = ?", uid).Count(&count).Error; err != nil {
+			return err
+		}
+		if u.Password == "" && count <= 1 {
+			return errLastLoginMethod
+		}
+		return tx.Unscoped().Delete(&identity).Error
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, common.RespErr("identity not found", h.info))
+		case errors.Is(err, errLastLoginMethod):
+			c.JSON(http.StatusConflict, common.RespErr(err.Error(), h.info))
+		default:
+			c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		}
+		return
+	}
+
+	h.l.Info("federated identity unlinked", "id", id, "userId", uid)
+	c.JSON(http.StatusOK, common.RespOk("unlink identity success", nil, h.info))
+}
@@ -0,0 +1,146 @@
+package federation
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 身份源类型
+const (
+	// TypeOIDC 标准OpenID Connect身份源，如GitLab、Keycloak
+	TypeOIDC = "oidc"
+	// TypeGitHub GitHub OAuth应用，不支持OIDC，通过API读取用户信息
+	TypeGitHub = "github"
+)
+
+// reservedKeys 与管理接口的路径冲突
+var reservedKeys = []string{"provider", "providers", "identity"}
+
+var keyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,19}$`)
+
+// Provider 外部OIDC/OAuth2身份源
+type Provider struct {
+	gorm.Model
+	// Key 出现在回调地址中，如 /federation/{key}/callback，同时作为账号来源的一部分，创建后不可修改
+	Key     string `json:"key" gorm:"size:20;uniqueIndex"`
+	Name    string `json:"name"`
+	Type    string `json:"type" gorm:"size:16"`
+	Enabled bool   `json:"enabled"`
+	// Issuer OIDC签发者地址，用于发现端点；GitHub类型为GitHub Enterprise地址，为空时使用github.com
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"-"`
+	// Scopes 为空时OIDC使用openid profile email，GitHub使用read:user user:email
+	Scopes []string `json:"scopes" gorm:"serializer:json"`
+	// AutoProvision 首次登录时自动创建账号，否则只能由已登录的用户绑定后使用
+	AutoProvision bool `json:"autoProvision"`
+	// AllowedDomains 自动创建账号时要求已验证邮箱属于这些域名，为空时不限制
+	AllowedDomains []string `json:"allowedDomains" gorm:"serializer:json"`
+	// DefaultRoles 自动创建账号时授予的角色
+	DefaultRoles []uint `json:"defaultRoles" gorm:"serializer:json"`
+}
+
+func (Provider) TableName() string {
+	return "federation_provider"
+}
+
+// Source 该身份源自动创建的本地账号来源
+func (p *Provider) Source() string {
+	return "federation:" + p.Key
+}
+
+func (p *Provider) scopes() []string {
+	if len(p.Scopes) > 0 {
+		return p.Scopes
+	}
+	if p.Type == TypeGitHub {
+		return []string{"read:user", "user:email"}
+	}
+	return []string{"openid", "profile", "email"}
+}
+
+// allowProvision 判断外部用户能否自动创建账号
+func (p *Provider) allowProvision(ext *externalUser) bool {
+	if !p.AutoProvision {
+		return false
+	}
+	if len(p.AllowedDomains) == 0 {
+		return true
+	}
+	if !ext.EmailVerified {
+		return false
+	}
+	_, domain, ok := strings.Cut(ext.Email, "@")
+	return ok && slices.ContainsFunc(p.AllowedDomains, func(d string) bool {
+		return strings.EqualFold(d, domain)
+	})
+}
+
+// Identity 用户绑定的外部身份，一个用户可以绑定多个身份源
+type Identity struct {
+	gorm.Model
+	UserID   uint   `json:"userId" gorm:"index"`
+	Provider string `json:"provider" gorm:"size:20;uniqueIndex:idx_identity_subject"`
+	// Subject 身份源中的用户唯一标识，OIDC为sub，GitHub为用户ID
+	Subject     string     `json:"subject" gorm:"size:255;uniqueIndex:idx_identity_subject"`
+	Username    string     `json:"username"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"lastLoginAt"`
+}
+
+func (Identity) TableName() string {
+	return "user_identity"
+}
+
+// externalUser 身份源返回的用户信息
+type externalUser struct {
+	Subject       string
+	Username      string
+	Fullname      string
+	Email         string
+	EmailVerified bool
+	Phone         string
+}
+
+// pendingState 已跳转到身份源等待回调的登录或绑定请求
+type pendingState struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	// Redirect 完成后前端跳转的页面
+	Redirect string `json:"redirect"`
+	// LinkUserID 非0时为已登录用户绑定身份，否则为登录
+	LinkUserID uint `json:"linkUserId"`
+}
+
+func InitFederationTable(db *gorm.DB) {
+	db.AutoMigrate(&Provider{})
+	db.AutoMigrate(&Identity{})
+}
+
+func (p *Provider) Validate() error {
+	if !keyPattern.MatchString(p.Key) || slices.Contains(reservedKeys, p.Key) {
+		return errors.New("invalid key")
+	}
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	switch p.Type {
+	case TypeOIDC:
+		if p.Issuer == "" {
+			return errors.New("issuer is required")
+		}
+	case TypeGitHub:
+	default:
+		return errors.New("unsupported provider type")
+	}
+	if p.ClientID == "" {
+		return errors.New("client id is required")
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package federation
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/authz"
+	"github.com/z876730060/auth/internal/service/common"
+	"gorm.io/gorm"
+)
+
+// ProviderHandler 数据库中的外部身份源管理，配置文件中的身份源不在此管理
+type ProviderHandler struct {
+	l     *slog.Logger
+	db    *gorm.DB
+	authz authz.Authorizer
+	info  common.Info
+}
+
+func NewProviderHandler(l *slog.Logger, db *gorm.DB, authorizer authz.Authorizer, info common.Info) *ProviderHandler {
+	return &ProviderHandler{l: l, db: db, authz: authorizer, info: info}
+}
+
+func (h *ProviderHandler) Register(e *gin.Engine) {
+	e.POST("/federation/provider/list", authz.RequirePermission(h.authz, h.info, common.PermFederationProviderList), h.List)
+	e.POST("/federation/provider", authz.RequirePermission(h.authz, h.info, common.PermFederationProviderCreate), h.Add)
+	e.GET("/federation/provider/:id", authz.RequirePermission(h.authz, h.info, common.PermFederationProviderList), h.GetDetail)
+	e.PUT("/federation/provider", authz.RequirePermission(h.authz, h.info, common.PermFederationProviderUpdate), h.Update)
+	e.DELETE("/federation/provider/:id", authz.RequirePermission(h.authz, h.info, common.PermFederationProviderDelete), h.Del)
+}
+
+// providerReq 客户端密钥只写不读
+type providerReq struct {
+	Provider
+	ClientSecret string `json:"clientSecret"`
+}
+
+// List 身份源列表
+func (h *ProviderHandler) List(c *gin.Context) {
+	type rBody struct {
+		common.Page
+	}
+	var req rBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	var data []Provider
+	var count int64
+	h.db.Model(&Provider{}).Count(&count).Order("id").Offset((req.Page.Page - 1) * req.Size).Limit(req.Size).Find(&data)
+
+	c.JSON(http.StatusOK, common.RespOk("get federation provider list success", gin.H{
+		"records": data,
+		"total":   count,
+	}, h.info))
+}
+
+// Add 添加身份源
+func (h *ProviderHandler) Add(c *gin.Context) {
+	var req providerReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+	p := req.Provider
+	p.Model = gorm.Model{}
+	p.ClientSecret = req.ClientSecret
+	if err := p.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	if err := h.db.Create(&p).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, common.RespErr("key already exists", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("federation provider added", "key", p.Key, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("add federation provider success", p, h.info))
+}
+
+// GetDetail 身份源详情
+func (h *ProviderHandler) GetDetail(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	var p Provider
+	if err := h.db.Where("id = ?", id).First(&p).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("federation provider not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("get federation provider detail success", p, h.info))
+}
+
+// Update 修改身份源，key决定回调地址和已绑定的身份，不可修改；密钥为空时保持不变
+func (h *ProviderHandler) Update(c *gin.Context) {
+	var req providerReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.ID == 0 {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	var old Provider
+	if err := h.db.Where("id = ?", req.ID).First(&old).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("federation provider not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	p := req.Provider
+	p.Model = old.Model
+	p.Key = old.Key
+	p.ClientSecret = old.ClientSecret
+	if req.ClientSecret != "" {
+		p.ClientSecret = req.ClientSecret
+	}
+	if err := p.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	if err := h.db.Save(&p).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("federation provider updated", "key", p.Key, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("update federation provider success", p, h.info))
+}
+
+// Del 删除身份源及其绑定的身份，已创建的本地账号保留
+func (h *ProviderHandler) Del(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var p Provider
+		if err := tx.Where("id = ?", id).First(&p).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where(Identity{Provider: p.Key}).Delete(&Identity{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&p).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("federation provider not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("federation provider deleted", "id", id, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("delete federation provider success", nil, h.info))
+}
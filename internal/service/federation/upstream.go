@@ -0,0 +1,234 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// upstream 身份源协议实现
+type upstream interface {
+	// authCodeURL 跳转到身份源的授权地址
+	authCodeURL(state, nonce, verifier string) string
+	// exchange 用授权码换取令牌并读取用户信息
+	exchange(ctx context.Context, code string, pending *pendingState) (*externalUser, error)
+}
+
+// newUpstream 按身份源类型创建协议实现，OIDC身份源会请求发现端点
+func newUpstream(ctx context.Context, p *Provider, redirectURL string) (upstream, error) {
+	cfg := oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       p.scopes(),
+	}
+	switch p.Type {
+	case TypeOIDC:
+		provider, err := oidc.NewProvider(ctx, p.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("discover oidc provider: %w", err)
+		}
+		cfg.Endpoint = provider.Endpoint()
+		return &oidcUpstream{
+			cfg:      cfg,
+			provider: provider,
+			verifier: provider.Verifier(&oidc.Config{ClientID: p.ClientID}),
+		}, nil
+	case TypeGitHub:
+		web, api := "https://github.com", "https://api.github.com"
+		if p.Issuer != "" {
+			web = strings.TrimSuffix(p.Issuer, "/")
+			api = web + "/api/v3"
+		}
+		cfg.Endpoint = oauth2.Endpoint{
+			AuthURL:  web + "/login/oauth/authorize",
+			TokenURL: web + "/login/oauth/access_token",
+		}
+		return &githubUpstream{cfg: cfg, api: api}, nil
+	default:
+		return nil, errors.New("unsupported provider type")
+	}
+}
+
+// oidcUpstream OpenID Connect身份源，校验id_token的签名、受众和nonce
+type oidcUpstream struct {
+	cfg      oauth2.Config
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+}
+
+func (u *oidcUpstream) authCodeURL(state, nonce, verifier string) string {
+	return u.cfg.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+func (u *oidcUpstream) exchange(ctx context.Context, code string, pending *pendingState) (*externalUser, error) {
+	token, err := u.cfg.Exchange(ctx, code, oauth2.VerifierOption(pending.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+	idToken, err := u.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verify id_token: %w", err)
+	}
+	if idToken.Nonce != pending.Nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	// 部分身份源的id_token只包含sub，其余信息从userinfo读取
+	if (claims.PreferredUsername == "" || claims.Email == "") && u.provider.UserInfoEndpoint() != "" {
+		info, err := u.provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return nil, fmt.Errorf("get userinfo: %w", err)
+		}
+		if info.Subject != idToken.Subject {
+			return nil, errors.New("userinfo subject mismatch")
+		}
+		var more oidcClaims
+		if err := info.Claims(&more); err != nil {
+			return nil, err
+		}
+		claims.merge(more)
+	}
+
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Nickname
+	}
+	return &externalUser{
+		Subject:       idToken.Subject,
+		Username:      username,
+		Fullname:      claims.Name,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Phone:         claims.PhoneNumber,
+	}, nil
+}
+
+type oidcClaims struct {
+	PreferredUsername string     `json:"preferred_username"`
+	Nickname          string     `json:"nickname"`
+	Name              string     `json:"name"`
+	Email             string     `json:"email"`
+	EmailVerified     stringBool `json:"email_verified"`
+	PhoneNumber       string     `json:"phone_number"`
+}
+
+func (c *oidcClaims) merge(o oidcClaims) {
+	if c.PreferredUsername == "" {
+		c.PreferredUsername = o.PreferredUsername
+	}
+	if c.Nickname == "" {
+		c.Nickname = o.Nickname
+	}
+	if c.Name == "" {
+		c.Name = o.Name
+	}
+	if c.Email == "" {
+		c.Email, c.EmailVerified = o.Email, o.EmailVerified
+	}
+	if c.PhoneNumber == "" {
+		c.PhoneNumber = o.PhoneNumber
+	}
+}
+
+// stringBool 兼容部分身份源以字符串返回的email_verified
+type stringBool bool
+
+func (b *stringBool) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case bool:
+		*b = stringBool(t)
+	case string:
+		parsed, _ := strconv.ParseBool(t)
+		*b = stringBool(parsed)
+	}
+	return nil
+}
+
+// githubUpstream GitHub OAuth应用，用户ID作为唯一标识
+type githubUpstream struct {
+	cfg oauth2.Config
+	api string
+}
+
+func (u *githubUpstream) authCodeURL(state, _, verifier string) string {
+	return u.cfg.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
+}
+
+func (u *githubUpstream) exchange(ctx context.Context, code string, pending *pendingState) (*externalUser, error) {
+	token, err := u.cfg.Exchange(ctx, code, oauth2.VerifierOption(pending.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+	client := u.cfg.Client(ctx, token)
+
+	var profile struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := u.get(ctx, client, "/user", &profile); err != nil {
+		return nil, err
+	}
+	if profile.ID == 0 {
+		return nil, errors.New("github user has no id")
+	}
+
+	// 公开邮箱未必验证过，只使用已验证的主邮箱
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := u.get(ctx, client, "/user/emails", &emails); err != nil {
+		return nil, err
+	}
+	ext := &externalUser{
+		Subject:  strconv.FormatInt(profile.ID, 10),
+		Username: profile.Login,
+		Fullname: profile.Name,
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			ext.Email, ext.EmailVerified = e.Email, true
+			break
+		}
+	}
+	return ext, nil
+}
+
+func (u *githubUpstream) get(ctx context.Context, client *http.Client, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.api+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("github api %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("github api %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
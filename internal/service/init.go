@@ -11,10 +11,23 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
 	"github.com/spf13/viper"
+	"github.com/z876730060/auth/internal/service/authn"
+	"github.com/z876730060/auth/internal/service/authz"
 	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/federation"
 	"github.com/z876730060/auth/internal/service/login"
+	"github.com/z876730060/auth/internal/service/mailer"
 	"github.com/z876730060/auth/internal/service/menu"
+	"github.com/z876730060/auth/internal/service/mfa"
+	"github.com/z876730060/auth/internal/service/oauth"
+	"github.com/z876730060/auth/internal/service/passkey"
+	"github.com/z876730060/auth/internal/service/password"
+	"github.com/z876730060/auth/internal/service/pat"
+	"github.com/z876730060/auth/internal/service/reset"
 	"github.com/z876730060/auth/internal/service/role"
+	"github.com/z876730060/auth/internal/service/saml"
+	"github.com/z876730060/auth/internal/service/serviceaccount"
+	"github.com/z876730060/auth/internal/service/session"
 	"github.com/z876730060/auth/internal/service/user"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
@@ -50,7 +63,15 @@ func InitDB() {
 
 	menu.InitMenuTable(db)
 	role.InitRoleTable(db)
+	authz.InitPolicyTable(db)
 	user.InitUserTable(db)
+	mfa.InitMFATable(db)
+	passkey.InitPasskeyTable(db)
+	oauth.InitOAuthTable(db)
+	saml.InitSAMLTable(db)
+	federation.InitFederationTable(db)
+	pat.InitPATTable(db)
+	serviceaccount.InitServiceAccountTable(db)
 	slog.Info("db connect success")
 }
 
@@ -66,14 +87,76 @@ func InitRoute(e *gin.Engine) {
 
 	e.Use(BaseMiddleware(l.With(HANDLER, "baseMiddleware")), requestid.New())
 	NewHealthService().Register(e)
+	NewJWKSHandler().Register(e)
 	NewPprofHandler(l.With(HANDLER, "pprofHandler")).Register(e)
-	login.NewHandler(l.With(HANDLER, "loginHandler"), db, info, redisClient).Register(e)
-	e.Use(AuthMiddleware(l.With(HANDLER, "authMiddleware")))
+	sessions := session.NewStore(redisClient, Cfg.Security.Token)
+	authorizer, err := authz.NewEngine(l.With(HANDLER, "authorizer"), db, redisClient, Cfg.Security.Authz, subjectAttributes, authz.SourceFunc(role.Policies))
+	if err != nil {
+		panic("authorizer init failed: " + err.Error())
+	}
+	go authorizer.Watch(context.Background())
+	mfaService := mfa.NewService(db, Cfg.Application.Name)
+	patService := pat.NewService(db)
+	sessions.OnRevokeUser(patService.RevokeUser)
+	passkeyService, err := passkey.NewService(db, redisClient, Cfg.Security.WebAuthn)
+	if err != nil {
+		panic("webauthn init failed: " + err.Error())
+	}
+
+	mail, err := mailer.New(l.With(HANDLER, "mailer"), Cfg.Mail)
+	if err != nil {
+		panic("mailer init failed: " + err.Error())
+	}
 
-	role.NewHandler(l.With(HANDLER, "roleHandler"), db, info).Register(e)
-	user.NewHandler(l.With(HANDLER, "userHandler"), db, redisClient, info).Register(e)
-	menu.NewHandler(l.With(HANDLER, "menuHandler"), db, info).Register(e)
-	menu.NewMicroAppHandler(l.With(HANDLER, "microAppHandler"), info, db).Register(e)
+	authenticators, err := authn.New(l.With(HANDLER, "authn"), db, Cfg.Security.Authn)
+	if err != nil {
+		panic("authenticator init failed: " + err.Error())
+	}
+
+	loginHandler := login.NewHandler(l.With(HANDLER, "loginHandler"), db, info, redisClient, sessions, mfaService, passkeyService, authenticators, authorizer, Cfg.Security.Login)
+	loginHandler.Register(e)
+	oauthHandler, err := oauth.NewHandler(l.With(HANDLER, "oauthHandler"), db, redisClient, sessions, info, Cfg.Security.OAuth)
+	if err != nil {
+		panic("oauth init failed: " + err.Error())
+	}
+	oauthHandler.Register(e)
+	samlHandler, err := saml.NewHandler(l.With(HANDLER, "samlHandler"), db, redisClient, loginHandler, info, Cfg.Security.SAML)
+	if err != nil {
+		panic("saml sp init failed: " + err.Error())
+	}
+	samlHandler.Register(e)
+	federationHandler, err := federation.NewHandler(l.With(HANDLER, "federationHandler"), db, redisClient, loginHandler, info, Cfg.Security.Federation)
+	if err != nil {
+		panic("federation init failed: " + err.Error())
+	}
+	federationHandler.Register(e)
+	resetHandler, err := reset.NewHandler(l.With(HANDLER, "resetHandler"), db, redisClient, sessions, mail, info, Cfg.Security.Reset)
+	if err != nil {
+		panic("password reset init failed: " + err.Error())
+	}
+	resetHandler.Register(e)
+	serviceAccountHandler, err := serviceaccount.NewHandler(l.With(HANDLER, "serviceAccountHandler"), db, redisClient, sessions, info, Cfg.Security.ServiceAccount)
+	if err != nil {
+		panic("service account init failed: " + err.Error())
+	}
+	serviceAccountHandler.Register(e)
+	NewVerifyHandler(l.With(HANDLER, "verifyHandler"), db, sessions, patService, authorizer, Cfg.Security.Gateway).Register(e)
+	e.Use(AuthMiddleware(l.With(HANDLER, "authMiddleware"), sessions, patService))
+
+	loginHandler.RegisterProtected(e)
+	role.NewHandler(l.With(HANDLER, "roleHandler"), db, authorizer, info).Register(e)
+	user.NewHandler(l.With(HANDLER, "userHandler"), db, redisClient, sessions, authorizer, info).Register(e)
+	mfa.NewHandler(l.With(HANDLER, "mfaHandler"), mfaService, info).Register(e)
+	passkey.NewHandler(l.With(HANDLER, "passkeyHandler"), passkeyService, info).Register(e)
+	pat.NewHandler(l.With(HANDLER, "patHandler"), db, patService, authorizer, info).Register(e)
+	serviceaccount.NewAccountHandler(l.With(HANDLER, "serviceAccountAdminHandler"), db, authorizer, info).Register(e)
+	oauth.NewClientHandler(l.With(HANDLER, "oauthClientHandler"), db, authorizer, info).Register(e)
+	saml.NewIdPHandler(l.With(HANDLER, "samlIdPHandler"), db, authorizer, info).Register(e)
+	federationHandler.RegisterProtected(e)
+	federation.NewProviderHandler(l.With(HANDLER, "federationProviderHandler"), db, authorizer, info).Register(e)
+	menu.NewHandler(l.With(HANDLER, "menuHandler"), db, authorizer, info).Register(e)
+	authz.NewPolicyHandler(l.With(HANDLER, "policyHandler"), db, authorizer, info).Register(e)
+	menu.NewMicroAppHandler(l.With(HANDLER, "microAppHandler"), info, db, authorizer).Register(e)
 	slog.Info("route register success")
 }
 
@@ -91,6 +174,20 @@ func InitConfig() {
 	slog.Info("config load success", "path", viper.ConfigFileUsed())
 }
 
+// InitSecurity 初始化安全组件
+func InitSecurity() {
+	if err := password.Configure(Cfg.Security.Password); err != nil {
+		panic("password hasher init failed: " + err.Error())
+	}
+	if err := common.InitKeySet(Cfg.Security.JWT); err != nil {
+		panic("jwt key set init failed: " + err.Error())
+	}
+	if common.GetKeySet().IDTokenAlg() == "" {
+		slog.Warn("no asymmetric jwt key configured, openid connect id tokens are unavailable")
+	}
+	slog.Info("security init success")
+}
+
 // InitRedis 初始化缓存
 func InitRedis() {
 	if !Cfg.Redis.Enable {
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/common"
+)
+
+// JWKSHandler 公开验签公钥，供其他服务离线验证令牌
+type JWKSHandler struct{}
+
+func NewJWKSHandler() *JWKSHandler {
+	return &JWKSHandler{}
+}
+
+func (h *JWKSHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, common.GetKeySet().JWKS())
+}
+
+func (h *JWKSHandler) Register(e *gin.Engine) {
+	e.GET("/.well-known/jwks.json", h.JWKS)
+}
@@ -0,0 +1,201 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+const (
+	failureUserKey   = "login:fail:user:%s"
+	failureIPKey     = "login:fail:ip:%s"
+	failureMFAKey    = "login:fail:mfa:%s"
+	lockUserKey      = "login:lock:user:%s"
+	lockIPKey        = "login:lock:ip:%s"
+	lockLevelUserKey = "login:lock:level:user:%s"
+	lockLevelIPKey   = "login:lock:level:ip:%s"
+)
+
+var errLocked = errors.New("too many failed attempts, please try again later")
+
+// accountKey 按规范化的用户名生成账号维度的key
+// 数据库排序规则不区分大小写时 Admin 和 admin 是同一账号，必须共用失败次数和锁定状态
+func accountKey(format, username string) string {
+	return fmt.Sprintf(format, strings.ToLower(strings.TrimSpace(username)))
+}
+
+// failures 获取用户在统计窗口内的连续登录失败次数
+func (h *Handler) failures(ctx context.Context, username string) (int64, error) {
+	n, err := h.redisClient.Get(ctx, accountKey(failureUserKey, username)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// lockLevel 获取用户被锁定过的次数
+func (h *Handler) lockLevel(ctx context.Context, username string) (int64, error) {
+	n, err := h.redisClient.Get(ctx, accountKey(lockLevelUserKey, username)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// checkLocked 校验账号和客户端IP是否处于锁定期
+// 不存在的用户名同样会被计数和锁定，避免通过锁定状态判断账号是否存在
+func (h *Handler) checkLocked(ctx context.Context, username, ip string) error {
+	n, err := h.redisClient.Exists(ctx, accountKey(lockUserKey, username), fmt.Sprintf(lockIPKey, ip)).Result()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return errLocked
+	}
+	return nil
+}
+
+// recordFailure 记录一次登录失败，达到阈值后按指数增长的时长锁定
+func (h *Handler) recordFailure(c *gin.Context, username string) {
+	ip := c.ClientIP()
+	userFailures, err := h.incrFailure(c, accountKey(failureUserKey, username))
+	if err != nil {
+		h.l.Error("record login failure failed", "username", username, "err", err)
+		return
+	}
+	ipFailures, err := h.incrFailure(c, fmt.Sprintf(failureIPKey, ip))
+	if err != nil {
+		h.l.Error("record login failure failed", "ip", ip, "err", err)
+		return
+	}
+
+	if userFailures >= h.cfg.Throttle.UserThreshold {
+		h.lock(c, "account", username, ip, accountKey(failureUserKey, username), accountKey(lockUserKey, username), accountKey(lockLevelUserKey, username))
+	}
+	if ipFailures >= h.cfg.Throttle.IPThreshold {
+		h.lock(c, "ip", username, ip, fmt.Sprintf(failureIPKey, ip), fmt.Sprintf(lockIPKey, ip), fmt.Sprintf(lockLevelIPKey, ip))
+	}
+}
+
+// recordMFAFailure 记录一次第二步验证失败，按账号累计且不会因密码校验通过而清零
+// 重新登录获取新的待验证令牌不能绕过次数限制，达到阈值后锁定账号
+func (h *Handler) recordMFAFailure(c *gin.Context, username string) {
+	key := accountKey(failureMFAKey, username)
+	n, err := h.incrFailure(c, key)
+	if err != nil {
+		h.l.Error("record mfa failure failed", "username", username, "err", err)
+		return
+	}
+	if n >= h.cfg.Throttle.UserThreshold {
+		h.lock(c, "account", username, c.ClientIP(), key, accountKey(lockUserKey, username), accountKey(lockLevelUserKey, username))
+	}
+}
+
+// resetMFAFailures 第二步验证通过后清除账号的验证失败次数
+func (h *Handler) resetMFAFailures(ctx context.Context, username string) {
+	if err := h.redisClient.Del(ctx, accountKey(failureMFAKey, username)).Err(); err != nil {
+		h.l.Error("reset mfa failures failed", "username", username, "err", err)
+	}
+}
+
+func (h *Handler) incrFailure(ctx context.Context, key string) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := h.redisClient.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		incr = p.Incr(ctx, key)
+		p.Expire(ctx, key, h.cfg.FailureWindow)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// lock 锁定账号或IP，第n次锁定时长为 LockDuration * 2^(n-1)，不超过 MaxLockDuration
+func (h *Handler) lock(c *gin.Context, target, username, ip, failureKey, lockKey, levelKey string) {
+	level, err := h.redisClient.Incr(c, levelKey).Result()
+	if err != nil {
+		h.l.Error("lock failed", "target", target, "username", username, "ip", ip, "err", err)
+		return
+	}
+
+	duration := h.cfg.Throttle.LockDuration
+	for i := int64(1); i < level && duration < h.cfg.Throttle.MaxLockDuration; i++ {
+		duration *= 2
+	}
+	duration = min(duration, h.cfg.Throttle.MaxLockDuration)
+
+	_, err = h.redisClient.TxPipelined(c, func(p redis.Pipeliner) error {
+		p.Set(c, lockKey, level, duration)
+		p.Expire(c, levelKey, h.cfg.Throttle.LevelTTL)
+		p.Del(c, failureKey)
+		return nil
+	})
+	if err != nil {
+		h.l.Error("lock failed", "target", target, "username", username, "ip", ip, "err", err)
+		return
+	}
+
+	h.l.Warn("login locked", "target", target, "username", username, "ip", ip,
+		"level", level, "duration", duration, "requestId", requestid.Get(c))
+}
+
+// resetFailures 登录成功后清除账号的失败次数和锁定等级
+func (h *Handler) resetFailures(ctx context.Context, username string) {
+	err := h.redisClient.Del(ctx, accountKey(failureUserKey, username), accountKey(lockLevelUserKey, username)).Err()
+	if err != nil {
+		h.l.Error("reset login failures failed", "username", username, "err", err)
+	}
+}
+
+// Unlock 管理员解除账号锁定
+func (h *Handler) Unlock(c *gin.Context) {
+	id := c.Param("id")
+
+	uid, err := common.ParseID(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid id", h.info))
+		return
+	}
+
+	var u user.User
+	if err := h.db.Where(user.User{Model: gorm.Model{ID: uid}}).First(&u).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("user not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	if err := user.CheckSuperAdminTarget(h.db, u.ID, c.GetUintSlice("role")); err != nil {
+		if errors.Is(err, user.ErrSuperAdminRequired) {
+			c.JSON(http.StatusForbidden, common.RespErr(err.Error(), h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	err = h.redisClient.Del(c,
+		accountKey(lockUserKey, u.Username),
+		accountKey(failureUserKey, u.Username),
+		accountKey(failureMFAKey, u.Username),
+		accountKey(lockLevelUserKey, u.Username),
+	).Err()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("account unlocked", "username", u.Username, common.Operator(c), "requestId", requestid.Get(c))
+	c.JSON(http.StatusOK, common.RespOk("unlock user success", nil, h.info))
+}
@@ -0,0 +1,77 @@
+package login
+
+import "time"
+
+const (
+	CaptchaPolicyAlways   = "always"
+	CaptchaPolicyNever    = "never"
+	CaptchaPolicyFailures = "failures"
+)
+
+// Config 登录配置
+type Config struct {
+	Captcha  CaptchaConfig  `json:"captcha"`
+	Throttle ThrottleConfig `json:"throttle"`
+	// FailureWindow 登录失败次数的统计窗口
+	FailureWindow time.Duration `json:"failurewindow"`
+	// MFATTL 密码校验通过后完成第二步验证的时限
+	MFATTL time.Duration `json:"mfattl"`
+	// PasswordChangeTTL 登录时要求修改密码，完成修改的时限
+	PasswordChangeTTL time.Duration `json:"passwordchangettl"`
+}
+
+// ThrottleConfig 登录失败锁定配置
+type ThrottleConfig struct {
+	UserThreshold int64 `json:"userthreshold"`
+	IPThreshold   int64 `json:"ipthreshold"`
+	// LockDuration 首次锁定时长，之后每次锁定时长翻倍
+	LockDuration    time.Duration `json:"lockduration"`
+	MaxLockDuration time.Duration `json:"maxlockduration"`
+	// LevelTTL 锁定等级的保留时间，超过后重新从首次锁定计算
+	LevelTTL time.Duration `json:"levelttl"`
+}
+
+// CaptchaConfig 验证码配置
+type CaptchaConfig struct {
+	// Policy 校验策略: always 总是校验, never 不校验, failures 连续失败Threshold次后校验
+	Policy    string        `json:"policy"`
+	Threshold int64         `json:"threshold"`
+	TTL       time.Duration `json:"ttl"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.Captcha.Policy == "" {
+		c.Captcha.Policy = CaptchaPolicyFailures
+	}
+	if c.Captcha.Threshold == 0 {
+		c.Captcha.Threshold = 3
+	}
+	if c.Captcha.TTL == 0 {
+		c.Captcha.TTL = 2 * time.Minute
+	}
+	if c.Throttle.UserThreshold == 0 {
+		c.Throttle.UserThreshold = 5
+	}
+	if c.Throttle.IPThreshold == 0 {
+		c.Throttle.IPThreshold = 20
+	}
+	if c.Throttle.LockDuration == 0 {
+		c.Throttle.LockDuration = time.Minute
+	}
+	if c.Throttle.MaxLockDuration == 0 {
+		c.Throttle.MaxLockDuration = time.Hour
+	}
+	if c.Throttle.LevelTTL == 0 {
+		c.Throttle.LevelTTL = 24 * time.Hour
+	}
+	if c.MFATTL == 0 {
+		c.MFATTL = 5 * time.Minute
+	}
+	if c.PasswordChangeTTL == 0 {
+		c.PasswordChangeTTL = 10 * time.Minute
+	}
+	if c.FailureWindow == 0 {
+		c.FailureWindow = 15 * time.Minute
+	}
+	return c
+}
@@ -1,16 +1,19 @@
 package login
 
 import (
-	"fmt"
+	"errors"
 	"log/slog"
 	"net/http"
-	"time"
 
+	"github.com/gin-contrib/requestid"
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
-	"github.com/steambap/captcha"
+	"github.com/z876730060/auth/internal/service/authn"
+	"github.com/z876730060/auth/internal/service/authz"
 	"github.com/z876730060/auth/internal/service/common"
-	"github.com/z876730060/auth/internal/service/user"
+	"github.com/z876730060/auth/internal/service/mfa"
+	"github.com/z876730060/auth/internal/service/passkey"
+	"github.com/z876730060/auth/internal/service/session"
 	"gorm.io/gorm"
 )
 
@@ -19,16 +22,41 @@ type Handler struct {
 	db          *gorm.DB
 	l           *slog.Logger
 	redisClient *redis.Client
+	sessions    *session.Store
+	mfa         *mfa.Service
+	passkeys    *passkey.Service
+	authn       *authn.Chain
+	authz       authz.Authorizer
 	info        common.Info
+	cfg         Config
 }
 
-func NewHandler(l *slog.Logger, db *gorm.DB, info common.Info, redisClient *redis.Client) *Handler {
-	return &Handler{db: db, l: l, redisClient: redisClient, info: info}
+func NewHandler(l *slog.Logger, db *gorm.DB, info common.Info, redisClient *redis.Client, sessions *session.Store, mfaService *mfa.Service, passkeys *passkey.Service, authenticators *authn.Chain, authorizer authz.Authorizer, cfg Config) *Handler {
+	return &Handler{db: db, l: l, redisClient: redisClient, sessions: sessions, mfa: mfaService, passkeys: passkeys, authn: authenticators, authz: authorizer, info: info, cfg: cfg.withDefaults()}
 }
 
 func (h *Handler) Register(e *gin.Engine) {
 	e.POST("/login", h.Login)
 	e.GET("/captcha", h.Captcha)
+	e.POST("/token/refresh", h.Refresh)
+	e.POST("/login/mfa", h.LoginMFA)
+	e.POST("/login/mfa/enroll", h.LoginMFAEnroll)
+	e.POST("/login/mfa/webauthn/begin", h.LoginMFAWebAuthnBegin)
+	e.POST("/login/mfa/webauthn/finish", h.LoginMFAWebAuthnFinish)
+	e.POST("/login/passkey/begin", h.LoginPasskeyBegin)
+	e.POST("/login/passkey/finish", h.LoginPasskeyFinish)
+	e.POST("/login/sso", h.LoginSSO)
+	e.POST("/login/password", h.LoginPasswordChange)
+}
+
+// RegisterProtected 注册需要登录后访问的路由
+func (h *Handler) RegisterProtected(e *gin.Engine) {
+	e.POST("/logout", h.Logout)
+	e.POST("/logout/all", common.RequireUser(h.info), h.LogoutAll)
+	e.POST("/password/change", common.RequireUser(h.info), h.ChangePassword)
+	e.GET("/session", common.RequireUser(h.info), h.Sessions)
+	e.DELETE("/session/:id", common.RequireUser(h.info), h.RevokeSession)
+	e.POST("/user/:id/unlock", authz.RequirePermission(h.authz, h.info, common.PermUserRevoke), h.Unlock)
 }
 
 // Login 登录
@@ -44,41 +72,125 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
-	// 校验用户名是否存在
-	var u user.User
-	if err := h.db.Where(user.User{Username: req.Username}).First(&u).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, common.RespErr("username or password is incorrect", h.info))
+	// 校验账号和IP是否被锁定
+	if err := h.checkLocked(c, req.Username, c.ClientIP()); err != nil {
+		if errors.Is(err, errLocked) {
+			c.JSON(http.StatusTooManyRequests, common.RespErr(err.Error(), h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
 		return
 	}
 
-	// 校验密码是否正确
-	if u.Password != req.Password {
-		c.JSON(http.StatusUnauthorized, common.RespErr("username or password is incorrect", h.info))
+	// 校验验证码
+	if err := h.checkCaptcha(c, req); err != nil {
+		if errors.Is(err, errCaptchaRequired) || errors.Is(err, errCaptchaIncorrect) {
+			c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
 		return
 	}
 
-	// 生成JWT token
-	token, err := common.GenerateCompatibleToken(u.ID, u.Username, []string{})
+	// 依次使用本地账号和外部目录校验用户名密码
+	u, err := h.authn.Authenticate(c, req.Username, req.Password)
 	if err != nil {
+		if errors.Is(err, authn.ErrUserNotFound) || errors.Is(err, authn.ErrInvalidCredentials) {
+			h.recordFailure(c, req.Username)
+			c.JSON(http.StatusUnauthorized, common.RespErr("username or password is incorrect", h.info))
+			return
+		}
+		c.JSON(http.StatusServiceUnavailable, common.RespErr("authentication service unavailable", h.info))
+		return
+	}
+	h.resetFailures(c, req.Username)
+
+	h.completeLogin(c, u)
+}
+
+// Refresh 使用refresh token换取新的令牌
+func (h *Handler) Refresh(c *gin.Context) {
+	var req RefreshReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	pair, err := h.sessions.Refresh(c, req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, session.ErrRefreshTokenReused) {
+			h.l.Warn("refresh token reused, session revoked", "requestId", requestid.Get(c))
+		}
+		if errors.Is(err, session.ErrInvalidRefreshToken) || errors.Is(err, session.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, common.RespErr("invalid refresh token", h.info))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
 		return
 	}
 
-	// 缓存token
-	if err := h.redisClient.Set(c, fmt.Sprintf("jwt:user:%s", u.Username), token, time.Hour).Err(); err != nil {
+	h.sessions.SetCookie(c, pair)
+	c.JSON(http.StatusOK, common.RespOk("refresh token success", pair, h.info))
+}
+
+// Logout 退出登录，撤销当前令牌及其会话
+func (h *Handler) Logout(c *gin.Context) {
+	value, _ := c.Get("claims")
+	claims, ok := value.(*common.CompatibleClaims)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, common.RespErr("unauthorized", h.info))
+		return
+	}
+
+	if err := h.sessions.RevokeToken(c, claims); err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.sessions.ClearCookie(c)
+	c.JSON(http.StatusOK, common.RespOk("logout success", nil, h.info))
+}
+
+// LogoutAll 退出所有会话
+func (h *Handler) LogoutAll(c *gin.Context) {
+	if err := h.sessions.RevokeUser(c, c.GetUint("userId")); err != nil {
 		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
 		return
 	}
 
-	c.JSON(http.StatusOK, common.RespOk("login success", token, h.info))
+	h.sessions.ClearCookie(c)
+	c.JSON(http.StatusOK, common.RespOk("logout all sessions success", nil, h.info))
 }
 
-// Captcha 获取验证码
-func (h *Handler) Captcha(c *gin.Context) {
-	data, err := captcha.New(150, 50)
+// Sessions 当前用户的在线会话
+func (h *Handler) Sessions(c *gin.Context) {
+	sessions, err := h.sessions.List(c, c.GetUint("userId"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
 		return
 	}
-	data.WriteImage(c.Writer)
+
+	value, _ := c.Get("claims")
+	if claims, ok := value.(*common.CompatibleClaims); ok {
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == claims.SessionID
+		}
+	}
+	c.JSON(http.StatusOK, common.RespOk("get session list success", sessions, h.info))
+}
+
+// RevokeSession 结束当前用户的指定会话
+func (h *Handler) RevokeSession(c *gin.Context) {
+	uid := c.GetUint("userId")
+	if err := h.sessions.RevokeSession(c, uid, c.Param("id")); err != nil {
+		if errors.Is(err, session.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr(err.Error(), h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("session revoked", "userId", uid, "sessionId", c.Param("id"), common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("revoke session success", nil, h.info))
 }
@@ -0,0 +1,286 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-contrib/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/mfa"
+	"github.com/z876730060/auth/internal/service/session"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+const (
+	mfaPendingKey = "mfa:pending:%s"
+	// mfaAttemptsKey 待验证令牌已使用的验证次数
+	mfaAttemptsKey = "mfa:attempts:%s"
+	// mfaMaxAttempts 同一个待验证令牌允许的最大验证次数
+	mfaMaxAttempts = 5
+
+	MFAMethodTOTP     = "totp"
+	MFAMethodRecovery = "recovery_code"
+	MFAMethodWebAuthn = "webauthn"
+)
+
+var errInvalidMFAToken = errors.New("invalid mfa token")
+
+// mfaPending 密码校验通过但尚未完成第二步验证的登录
+type mfaPending struct {
+	UserID   uint   `json:"userId"`
+	Username string `json:"username"`
+	// Enroll 角色要求MFA但用户尚未绑定，需要先完成绑定
+	Enroll bool `json:"enroll"`
+}
+
+// completeLogin 第一步校验通过后，根据MFA状态返回待验证令牌或直接签发令牌
+// 绑定了TOTP或WebAuthn凭证任一种都视为已启用MFA
+func (h *Handler) completeLogin(c *gin.Context, u *user.User) {
+	totpEnabled, err := h.mfa.Enabled(u.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	passkeyEnabled, err := h.passkeys.Enabled(u.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	enabled := totpEnabled || passkeyEnabled
+	required := false
+	if !enabled {
+		if required, err = h.mfa.Required(u.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+			return
+		}
+	}
+
+	if !enabled && !required {
+		h.issueTokens(c, u, nil)
+		return
+	}
+
+	token, err := h.newMFAPending(c, &mfaPending{UserID: u.ID, Username: u.Username, Enroll: !enabled})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	var methods []string
+	if totpEnabled || !enabled {
+		methods = append(methods, MFAMethodTOTP)
+	}
+	if totpEnabled {
+		methods = append(methods, MFAMethodRecovery)
+	}
+	if passkeyEnabled {
+		methods = append(methods, MFAMethodWebAuthn)
+	}
+	c.JSON(http.StatusOK, common.RespOk("mfa required", MFAPendingResp{
+		MFARequired:    true,
+		MFAToken:       token,
+		EnrollRequired: !enabled,
+		Methods:        methods,
+	}, h.info))
+}
+
+// issueTokens 签发access token和refresh token，登录时完成MFA绑定的同时返回恢复码
+func (h *Handler) issueTokens(c *gin.Context, u *user.User, recoveryCodes []string) {
+	if reason := passwordChangeReason(u); reason != "" {
+		h.requirePasswordChange(c, u, reason, recoveryCodes)
+		return
+	}
+
+	pair, err := h.sessions.Issue(c, u.ID, u.Username, session.DeviceFromRequest(c))
+	if err != nil {
+		if errors.Is(err, session.ErrSessionLimit) {
+			h.l.Warn("login rejected by session limit", "userId", u.ID, "ip", c.ClientIP())
+			c.JSON(http.StatusConflict, common.RespErr(err.Error(), h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.sessions.SetCookie(c, pair)
+	c.JSON(http.StatusOK, common.RespOk("login success", LoginResp{
+		TokenPair:     pair,
+		RecoveryCodes: recoveryCodes,
+	}, h.info))
+}
+
+// LoginMFA 登录第二步，使用待验证令牌和验证码换取正式令牌
+// 需要先绑定的用户提交的第一个验证码同时用于确认绑定
+func (h *Handler) LoginMFA(c *gin.Context) {
+	var req MFALoginReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	pending := h.beginMFAAttempt(c, req.MFAToken)
+	if pending == nil {
+		return
+	}
+
+	var recoveryCodes []string
+	var err error
+	switch {
+	case pending.Enroll:
+		recoveryCodes, err = h.mfa.Confirm(pending.UserID, req.Code)
+	case req.Method == MFAMethodRecovery:
+		err = h.mfa.VerifyRecoveryCode(pending.UserID, req.Code)
+	default:
+		err = h.mfa.Verify(pending.UserID, req.Code)
+	}
+	if err != nil {
+		if errors.Is(err, mfa.ErrInvalidCode) {
+			h.recordMFAFailure(c, pending.Username)
+		}
+		h.respMFAErr(c, err)
+		return
+	}
+
+	if recoveryCodes != nil {
+		h.l.Info("totp enrolled during login", "userId", pending.UserID, "requestId", requestid.Get(c))
+	}
+	h.finishMFA(c, req.MFAToken, pending, recoveryCodes)
+}
+
+// finishMFA 第二步验证通过后作废待验证令牌并签发令牌
+func (h *Handler) finishMFA(c *gin.Context, token string, pending *mfaPending, recoveryCodes []string) {
+	if err := h.redisClient.Del(c, mfaPendingRedisKey(token), mfaAttemptsRedisKey(token)).Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	h.resetMFAFailures(c, pending.Username)
+
+	var u user.User
+	if err := h.db.Where(user.User{Model: gorm.Model{ID: pending.UserID}}).First(&u).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, common.RespErr(errInvalidMFAToken.Error(), h.info))
+		return
+	}
+
+	h.issueTokens(c, &u, recoveryCodes)
+}
+
+// LoginMFAEnroll 角色要求MFA的用户在登录过程中绑定TOTP
+func (h *Handler) LoginMFAEnroll(c *gin.Context) {
+	var req MFALoginReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.MFAToken == "" {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	pending, err := h.getMFAPending(c, req.MFAToken)
+	if err != nil {
+		h.respMFAErr(c, err)
+		return
+	}
+	if !pending.Enroll {
+		c.JSON(http.StatusConflict, common.RespErr(mfa.ErrAlreadyEnrolled.Error(), h.info))
+		return
+	}
+
+	enrollment, err := h.mfa.Begin(pending.UserID, pending.Username)
+	if err != nil {
+		h.respMFAErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("begin totp enrollment success", enrollment, h.info))
+}
+
+func (h *Handler) newMFAPending(ctx context.Context, pending *mfaPending) (string, error) {
+	token, err := common.RandomHex(32)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return "", err
+	}
+	if err := h.redisClient.Set(ctx, mfaPendingRedisKey(token), data, h.cfg.MFATTL).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (h *Handler) getMFAPending(ctx context.Context, token string) (*mfaPending, error) {
+	data, err := h.redisClient.Get(ctx, mfaPendingRedisKey(token)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, errInvalidMFAToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pending mfaPending
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, err
+	}
+	return &pending, nil
+}
+
+// beginMFAAttempt 取出待验证令牌并在校验前占用一次验证次数，INCR保证并发请求也不会超过上限
+// 账号被锁定、令牌无效或次数用完时写入响应并返回nil
+func (h *Handler) beginMFAAttempt(c *gin.Context, token string) *mfaPending {
+	pending, err := h.getMFAPending(c, token)
+	if err != nil {
+		h.respMFAErr(c, err)
+		return nil
+	}
+	if err := h.checkLocked(c, pending.Username, c.ClientIP()); err != nil {
+		if errors.Is(err, errLocked) {
+			c.JSON(http.StatusTooManyRequests, common.RespErr(err.Error(), h.info))
+			return nil
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return nil
+	}
+
+	key := mfaAttemptsRedisKey(token)
+	var incr *redis.IntCmd
+	_, err = h.redisClient.TxPipelined(c, func(p redis.Pipeliner) error {
+		incr = p.Incr(c, key)
+		p.Expire(c, key, h.cfg.MFATTL)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return nil
+	}
+	if incr.Val() > mfaMaxAttempts {
+		h.l.Warn("mfa attempts exceeded", "userId", pending.UserID, "ip", c.ClientIP(), "requestId", requestid.Get(c))
+		h.redisClient.Del(c, mfaPendingRedisKey(token), key)
+		h.respMFAErr(c, errInvalidMFAToken)
+		return nil
+	}
+	return pending
+}
+
+func (h *Handler) respMFAErr(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errInvalidMFAToken):
+		c.JSON(http.StatusUnauthorized, common.RespErr(err.Error(), h.info))
+	case errors.Is(err, mfa.ErrInvalidCode), errors.Is(err, mfa.ErrNotEnrolled):
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+	case errors.Is(err, mfa.ErrAlreadyEnrolled):
+		c.JSON(http.StatusConflict, common.RespErr(err.Error(), h.info))
+	default:
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+	}
+}
+
+func mfaPendingRedisKey(token string) string {
+	return fmt.Sprintf(mfaPendingKey, common.HashKey(token))
+}
+
+func mfaAttemptsRedisKey(token string) string {
+	return fmt.Sprintf(mfaAttemptsKey, common.HashKey(token))
+}
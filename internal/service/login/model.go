@@ -1,10 +1,17 @@
 package login
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/z876730060/auth/internal/service/session"
+)
 
 type LoginReq struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	CaptchaID string `json:"captchaId"`
+	Captcha   string `json:"captcha"`
 }
 
 func (l LoginReq) Validate() error {
@@ -16,3 +23,43 @@ func (l LoginReq) Validate() error {
 	}
 	return nil
 }
+
+type RefreshReq struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// LoginResp 登录成功响应
+type LoginResp struct {
+	*session.TokenPair
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+// PasswordChangeResp 必须修改密码时的响应，使用ChangeToken调用 /login/password 设置新密码后签发令牌
+type PasswordChangeResp struct {
+	PasswordChangeRequired bool   `json:"passwordChangeRequired"`
+	ChangeToken            string `json:"changeToken"`
+	// Reason must_change 管理员设置的密码, expired 密码已过期
+	Reason        string   `json:"reason"`
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+// MFAPendingResp 需要第二步验证时的响应
+type MFAPendingResp struct {
+	MFARequired    bool     `json:"mfaRequired"`
+	MFAToken       string   `json:"mfaToken"`
+	EnrollRequired bool     `json:"enrollRequired"`
+	Methods        []string `json:"methods"`
+}
+
+type MFALoginReq struct {
+	MFAToken string `json:"mfaToken"`
+	Code     string `json:"code"`
+	Method   string `json:"method"`
+}
+
+// PasskeyLoginReq 完成WebAuthn认证，第二步验证时需要同时提交mfaToken
+type PasskeyLoginReq struct {
+	MFAToken   string          `json:"mfaToken"`
+	SessionID  string          `json:"sessionId"`
+	Credential json.RawMessage `json:"credential"`
+}
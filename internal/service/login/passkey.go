@@ -0,0 +1,106 @@
+package login
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-contrib/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/passkey"
+)
+
+// LoginPasskeyBegin 开始免密登录，无需用户名
+func (h *Handler) LoginPasskeyBegin(c *gin.Context) {
+	assertion, sessionID, err := h.passkeys.BeginDiscoverableLogin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("begin passkey login success", passkey.CeremonyResp{
+		SessionID: sessionID,
+		Options:   assertion,
+	}, h.info))
+}
+
+// LoginPasskeyFinish 完成免密登录，凭证已包含用户验证，直接签发令牌
+func (h *Handler) LoginPasskeyFinish(c *gin.Context) {
+	var req PasskeyLoginReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.SessionID == "" || len(req.Credential) == 0 {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	u, err := h.passkeys.FinishDiscoverableLogin(c, req.SessionID, req.Credential)
+	if err != nil {
+		h.respPasskeyErr(c, err)
+		return
+	}
+
+	h.resetFailures(c, u.Username)
+	h.l.Info("passkey login", "userId", u.ID, "requestId", requestid.Get(c))
+	h.issueTokens(c, u, nil)
+}
+
+// LoginMFAWebAuthnBegin 使用WebAuthn凭证完成第二步验证
+func (h *Handler) LoginMFAWebAuthnBegin(c *gin.Context) {
+	var req PasskeyLoginReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.MFAToken == "" {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	pending, err := h.getMFAPending(c, req.MFAToken)
+	if err != nil {
+		h.respMFAErr(c, err)
+		return
+	}
+
+	assertion, sessionID, err := h.passkeys.BeginLogin(c, pending.UserID)
+	if err != nil {
+		h.respPasskeyErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("begin webauthn verification success", passkey.CeremonyResp{
+		SessionID: sessionID,
+		Options:   assertion,
+	}, h.info))
+}
+
+// LoginMFAWebAuthnFinish 校验WebAuthn第二步验证结果并签发令牌
+func (h *Handler) LoginMFAWebAuthnFinish(c *gin.Context) {
+	var req PasskeyLoginReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.MFAToken == "" || req.SessionID == "" || len(req.Credential) == 0 {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	pending := h.beginMFAAttempt(c, req.MFAToken)
+	if pending == nil {
+		return
+	}
+
+	if err := h.passkeys.FinishLogin(c, pending.UserID, req.SessionID, req.Credential); err != nil {
+		if errors.Is(err, passkey.ErrVerifyFailed) {
+			h.recordMFAFailure(c, pending.Username)
+		}
+		h.respPasskeyErr(c, err)
+		return
+	}
+
+	h.finishMFA(c, req.MFAToken, pending, nil)
+}
+
+func (h *Handler) respPasskeyErr(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, passkey.ErrInvalidSession), errors.Is(err, passkey.ErrVerifyFailed):
+		h.l.Warn("passkey verification failed", "ip", c.ClientIP(), "err", err, "requestId", requestid.Get(c))
+		c.JSON(http.StatusUnauthorized, common.RespErr(err.Error(), h.info))
+	case errors.Is(err, passkey.ErrNotEnrolled):
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+	default:
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+	}
+}
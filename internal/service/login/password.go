@@ -0,0 +1,212 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-contrib/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/password"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+const passwordChangeKey = "password:change:pending:%s"
+
+// 登录时必须修改密码的原因
+const (
+	ReasonMustChange = "must_change"
+	ReasonExpired    = "expired"
+)
+
+var errInvalidChangeToken = errors.New("invalid password change token")
+
+// passwordChangePending 身份校验已完成但必须先修改密码的登录
+type passwordChangePending struct {
+	UserID uint   `json:"userId"`
+	Reason string `json:"reason"`
+}
+
+// passwordChangeReason 登录前必须修改密码的原因，不需要时返回空
+func passwordChangeReason(u *user.User) string {
+	if u.Source != "" || u.Password == "" {
+		return ""
+	}
+	if u.MustChangePassword {
+		return ReasonMustChange
+	}
+	if u.PasswordExpired() {
+		return ReasonExpired
+	}
+	return ""
+}
+
+// requirePasswordChange 不签发令牌，返回修改密码使用的临时令牌
+func (h *Handler) requirePasswordChange(c *gin.Context, u *user.User, reason string, recoveryCodes []string) {
+	token, err := common.RandomHex(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	data, err := json.Marshal(passwordChangePending{UserID: u.ID, Reason: reason})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	if err := h.redisClient.Set(c, passwordChangeRedisKey(token), data, h.cfg.PasswordChangeTTL).Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("password change required", "userId", u.ID, "reason", reason, "requestId", requestid.Get(c))
+	c.JSON(http.StatusOK, common.RespOk("password change required", PasswordChangeResp{
+		PasswordChangeRequired: true,
+		ChangeToken:            token,
+		Reason:                 reason,
+		RecoveryCodes:          recoveryCodes,
+	}, h.info))
+}
+
+// LoginPasswordChange 登录时设置新密码，成功后签发令牌，新密码不满足策略时可以重试
+func (h *Handler) LoginPasswordChange(c *gin.Context) {
+	type rBody struct {
+		ChangeToken string `json:"changeToken"`
+		Password    string `json:"password"`
+	}
+	var req rBody
+	if err := c.ShouldBindJSON(&req); err != nil || req.ChangeToken == "" || req.Password == "" {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	key := passwordChangeRedisKey(req.ChangeToken)
+	pending, err := h.getPasswordChangePending(c, key, false)
+	if err != nil {
+		h.respChangeTokenErr(c, err)
+		return
+	}
+
+	var u user.User
+	if err := h.db.Where(user.User{Model: gorm.Model{ID: pending.UserID}}).First(&u).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, common.RespErr(errInvalidChangeToken.Error(), h.info))
+		return
+	}
+	// 不满足策略时保留令牌以便重试
+	if err := user.ValidatePassword(h.db, &u, req.Password); err != nil {
+		h.respPasswordErr(c, err)
+		return
+	}
+	// 修改密码前作废令牌，并发提交同一令牌时只有一个请求修改密码并签发令牌
+	if _, err := h.getPasswordChangePending(c, key, true); err != nil {
+		h.respChangeTokenErr(c, err)
+		return
+	}
+	if err := user.ChangePassword(h.db, &u, req.Password, false); err != nil {
+		h.respPasswordErr(c, err)
+		return
+	}
+
+	// 撤销修改密码前签发的令牌和个人访问令牌，随后签发的令牌不受影响
+	if err := h.sessions.RevokeUser(c, u.ID); err != nil {
+		h.l.Error("revoke user tokens failed", "userId", u.ID, "err", err)
+	}
+
+	h.l.Info("password changed at login", "userId", u.ID, "reason", pending.Reason, "ip", c.ClientIP(), "requestId", requestid.Get(c))
+	h.issueTokens(c, &u, nil)
+}
+
+// ChangePassword 已登录用户修改自己的密码，需要校验原密码
+func (h *Handler) ChangePassword(c *gin.Context) {
+	type rBody struct {
+		OldPassword string `json:"oldPassword"`
+		NewPassword string `json:"newPassword"`
+	}
+	var req rBody
+	if err := c.ShouldBindJSON(&req); err != nil || req.NewPassword == "" {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	var u user.User
+	if err := h.db.Where(user.User{Model: gorm.Model{ID: c.GetUint("userId")}}).First(&u).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("user not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	// 外部目录和身份源的账号在原系统中修改密码
+	if u.Source != "" || u.Password == "" {
+		c.JSON(http.StatusBadRequest, common.RespErr("password is managed by external source", h.info))
+		return
+	}
+	ok, _, err := password.Verify(u.Password, req.OldPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, common.RespErr("old password is incorrect", h.info))
+		return
+	}
+
+	if err := user.ChangePassword(h.db, &u, req.NewPassword, false); err != nil {
+		h.respPasswordErr(c, err)
+		return
+	}
+
+	// 修改密码后撤销全部令牌和个人访问令牌，需要重新登录
+	if err := h.sessions.RevokeUser(c, u.ID); err != nil {
+		h.l.Error("revoke user tokens failed", "userId", u.ID, "err", err)
+	}
+	h.sessions.ClearCookie(c)
+
+	h.l.Info("password changed", "userId", u.ID, "ip", c.ClientIP(), "requestId", requestid.Get(c))
+	c.JSON(http.StatusOK, common.RespOk("change password success", nil, h.info))
+}
+
+// getPasswordChangePending 读取待修改密码的登录，take为true时同时作废令牌
+func (h *Handler) getPasswordChangePending(ctx context.Context, key string, take bool) (*passwordChangePending, error) {
+	cmd := h.redisClient.Get
+	if take {
+		cmd = h.redisClient.GetDel
+	}
+	data, err := cmd(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, errInvalidChangeToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pending passwordChangePending
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, err
+	}
+	return &pending, nil
+}
+
+func passwordChangeRedisKey(token string) string {
+	return fmt.Sprintf(passwordChangeKey, common.HashKey(token))
+}
+
+func (h *Handler) respChangeTokenErr(c *gin.Context, err error) {
+	if errors.Is(err, errInvalidChangeToken) {
+		c.JSON(http.StatusUnauthorized, common.RespErr(err.Error(), h.info))
+		return
+	}
+	c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+}
+
+func (h *Handler) respPasswordErr(c *gin.Context, err error) {
+	if user.IsPasswordPolicyErr(err) {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+	c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+}
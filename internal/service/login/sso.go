@@ -0,0 +1,78 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+const (
+	ssoCodeKey = "login:sso:%s"
+	// ssoCodeTTL 一次性登录码的有效期，只用于浏览器跳转回前端后立即兑换
+	ssoCodeTTL = time.Minute
+)
+
+// SSOLoginReq 使用单点登录回调中的一次性登录码登录
+type SSOLoginReq struct {
+	Code string `json:"code"`
+}
+
+// NewSSOCode 外部身份源认证通过后生成一次性登录码
+// 前端使用登录码调用 /login/sso，得到与 /login 相同的响应
+func (h *Handler) NewSSOCode(ctx context.Context, u *user.User) (string, error) {
+	code, err := common.RandomHex(32)
+	if err != nil {
+		return "", err
+	}
+	if err := h.redisClient.Set(ctx, fmt.Sprintf(ssoCodeKey, code), u.ID, ssoCodeTTL).Err(); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// LoginSSO 兑换一次性登录码，之后与密码登录一样处理MFA并签发令牌
+func (h *Handler) LoginSSO(c *gin.Context) {
+	var req SSOLoginReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	value, err := h.redisClient.GetDel(c, fmt.Sprintf(ssoCodeKey, req.Code)).Result()
+	if errors.Is(err, redis.Nil) {
+		c.JSON(http.StatusUnauthorized, common.RespErr("invalid sso code", h.info))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	uid, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, common.RespErr("invalid sso code", h.info))
+		return
+	}
+
+	var u user.User
+	if err := h.db.Where(user.User{Model: gorm.Model{ID: uint(uid)}}).First(&u).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusUnauthorized, common.RespErr("invalid sso code", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("sso login", "userId", u.ID, "source", u.Source, "requestId", requestid.Get(c))
+	h.completeLogin(c, &u)
+}
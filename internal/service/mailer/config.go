@@ -0,0 +1,54 @@
+package mailer
+
+import "time"
+
+// 邮件发送方式
+const (
+	DriverSMTP = "smtp"
+	// DriverFile 写入目录中的.eml文件，用于本地调试
+	DriverFile = "file"
+	// DriverLog 只输出到日志，用于本地调试
+	DriverLog = "log"
+)
+
+// Config 邮件发送配置
+type Config struct {
+	// Driver 发送方式: smtp、file、log，为空时为log
+	Driver string `json:"driver"`
+	// From 发件人，如 "Auth <no-reply@example.com>"
+	From string     `json:"from"`
+	SMTP SMTPConfig `json:"smtp"`
+	// Dir file方式的输出目录
+	Dir string `json:"dir"`
+}
+
+// SMTPConfig SMTP服务器配置
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// TLS 使用隐式TLS连接（通常为465端口），否则在服务器支持时使用STARTTLS
+	TLS                bool          `json:"tls"`
+	InsecureSkipVerify bool          `json:"insecureskipverify"`
+	Timeout            time.Duration `json:"timeout"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.Driver == "" {
+		c.Driver = DriverLog
+	}
+	if c.From == "" {
+		c.From = "no-reply@localhost"
+	}
+	if c.Dir == "" {
+		c.Dir = "./mail"
+	}
+	if c.SMTP.Port == 0 {
+		c.SMTP.Port = 587
+	}
+	if c.SMTP.Timeout == 0 {
+		c.SMTP.Timeout = 10 * time.Second
+	}
+	return c
+}
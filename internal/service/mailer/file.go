@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/z876730060/auth/internal/service/common"
+)
+
+// fileMailer 把邮件写入目录，便于本地查看
+type fileMailer struct {
+	l    *slog.Logger
+	from *mail.Address
+	dir  string
+}
+
+func (m *fileMailer) Send(_ context.Context, msg *Message) error {
+	data, err := compose(m.from, msg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return err
+	}
+	suffix, err := common.RandomHex(4)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(m.dir, fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102150405.000000000"), suffix))
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		return err
+	}
+	m.l.Info("mail written", "to", msg.To, "subject", msg.Subject, "file", name)
+	return nil
+}
+
+// logMailer 只输出到日志，正文中可能包含令牌，不能在生产环境使用
+type logMailer struct {
+	l    *slog.Logger
+	from *mail.Address
+}
+
+func (m *logMailer) Send(_ context.Context, msg *Message) error {
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+	m.l.Info("mail sent to log", "from", m.from.String(), "to", msg.To, "subject", msg.Subject, "text", msg.Text)
+	return nil
+}
@@ -1,6 +1,7 @@
 package menu
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/url"
@@ -8,74 +9,62 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/authz"
 	"github.com/z876730060/auth/internal/service/common"
-	"github.com/z876730060/auth/internal/service/role"
 	"github.com/z876730060/auth/pkg/menu"
 
 	"gorm.io/gorm"
 )
 
 type Handler struct {
-	l    *slog.Logger
-	db   *gorm.DB
-	info common.Info
+	l     *slog.Logger
+	db    *gorm.DB
+	authz authz.Authorizer
+	info  common.Info
 }
 
-func NewHandler(l *slog.Logger, db *gorm.DB, info common.Info) *Handler {
-	return &Handler{l: l, db: db, info: info}
+func NewHandler(l *slog.Logger, db *gorm.DB, authorizer authz.Authorizer, info common.Info) *Handler {
+	return &Handler{l: l, db: db, authz: authorizer, info: info}
 }
 
 func (h *Handler) Register(e *gin.Engine) {
 	e.GET("/menu", h.GetMenu)
 	e.GET("/route", h.GetRoute)
-	e.POST("/menu/list", h.List)
-	e.POST("/menu", h.Add)
-	e.DELETE("/menu/:id", h.Del)
+	e.POST("/menu/list", authz.RequirePermission(h.authz, h.info, common.PermMenuList), h.List)
+	e.POST("/menu", authz.RequirePermission(h.authz, h.info, common.PermMenuCreate), h.Add)
+	e.DELETE("/menu/:id", authz.RequirePermission(h.authz, h.info, common.PermMenuDelete), h.Del)
 	e.GET("/breadcrumb", h.GetBreadcrumb)
-	e.GET("/menu/:id", h.GetDetail)
-	e.PUT("/menu", h.Update)
-	e.GET("/menu/tree", h.GetTree)
+	e.GET("/menu/:id", authz.RequirePermission(h.authz, h.info, common.PermMenuList), h.GetDetail)
+	e.PUT("/menu", authz.RequirePermission(h.authz, h.info, common.PermMenuUpdate), h.Update)
+	e.GET("/menu/tree", authz.RequirePermission(h.authz, h.info, common.PermMenuList), h.GetTree)
+	e.GET("/permission/mine", h.GetMine)
 }
 
+// GetMenu 当前用户可见的一级菜单，传MicroAppId时为该微应用的菜单
 func (h *Handler) GetMenu(c *gin.Context) {
-	roleIDs := c.GetUintSlice("role")
-	if len(roleIDs) == 0 {
-		c.JSON(http.StatusBadRequest, common.RespErr("role is empty", h.info))
-		return
-	}
-	h.l.Info("", "roleIDs", roleIDs)
-
 	appId := c.GetHeader("MicroAppId")
 
-	datas := make([]menu.Menu, 0)
-	h.l.Info("db is running\n")
 	var data []MenuTable
-	if slices.Contains(roleIDs, 1) {
-		h.l.Info("admin role")
-		query := h.db
-		if appId != "" {
-			query = query.Where("parent_key = (?) and other = true",
-				h.db.Model(&MenuTable{}).Where("micro_app = ? and parent_key = ?", appId, "").Pluck("key", nil),
-			)
-		} else {
-			query = query.Where("parent_key = ?", "")
-		}
-		query.Order("order_id, ID").Find(&data)
+	query := pages(h.db)
+	if appId != "" {
+		query = query.Where("parent_key = (?) and other = true",
+			h.db.Model(&MenuTable{}).Where("micro_app = ? and parent_key = ?", appId, "").Pluck("key", nil),
+		)
 	} else {
-		query := h.db
-		if appId != "" {
-			query = query.Where("parent_key = (?) and key IN (?) and other = true",
-				h.db.Model(&MenuTable{}).Where("micro_app = ? and parent_key = ?", appId, "").Pluck("key", nil),
-				h.db.Model(&role.RoleMenu{}).Where("rid IN ?", roleIDs).Pluck("menu_key", nil),
-			)
-		} else {
-			query = query.Where("parent_key = (?) and key IN (?)", "",
-				h.db.Model(&role.RoleMenu{}).Where("rid IN ?", roleIDs).Pluck("menu_key", nil),
-			)
-		}
-		query.Order("order_id, ID").Find(&data)
+		query = query.Where("parent_key = ?", "")
+	}
+	if err := query.Order("order_id, ID").Find(&data).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
 	}
 
+	data, err := h.visible(c, data)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	datas := make([]menu.Menu, 0, len(data))
 	for _, item := range data {
 		datas = append(datas, item.Menu)
 	}
@@ -83,33 +72,24 @@ func (h *Handler) GetMenu(c *gin.Context) {
 	c.JSON(http.StatusOK, common.RespOk("get menu success", datas, h.info))
 }
 
+// GetRoute 当前用户可访问的路由
 func (h *Handler) GetRoute(c *gin.Context) {
-	roleIDs := c.GetUintSlice("role")
-	if len(roleIDs) == 0 {
-		c.JSON(http.StatusBadRequest, common.RespErr("role is empty", h.info))
-		return
-	}
-	h.l.Info("", "roleIDs: ", roleIDs)
-
 	microAppId := c.GetHeader("MicroAppId")
 
 	var data []MenuTable
+	query := pages(h.db)
+	if microAppId != "" {
+		query = query.Where("other = true")
+	}
+	if err := query.Find(&data).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
 
-	if slices.Contains(roleIDs, 1) {
-		h.l.Info("admin role")
-		h.db.Find(&data)
-	} else {
-		query := h.db
-		if microAppId != "" {
-			query = query.Where("key IN (?) and other = true",
-				h.db.Model(&role.RoleMenu{}).Where("rid IN ?", roleIDs).Pluck("menu_key", nil),
-			)
-		} else {
-			query = query.Where("key IN (?)",
-				h.db.Model(&role.RoleMenu{}).Where("rid IN ?", roleIDs).Pluck("menu_key", nil),
-			)
-		}
-		query.Find(&data)
+	data, err := h.visible(c, data)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
 	}
 
 	datas := make([]menu.Route, len(data))
@@ -120,6 +100,21 @@ func (h *Handler) GetRoute(c *gin.Context) {
 	c.JSON(http.StatusOK, common.RespOk("get route success", datas, h.info))
 }
 
+// visible 按授权策略过滤当前用户可以查看的菜单
+func (h *Handler) visible(c *gin.Context, data []MenuTable) ([]MenuTable, error) {
+	visible := make([]MenuTable, 0, len(data))
+	for _, item := range data {
+		ok, err := h.authz.Authorize(c, authz.NewDomainRequest(c, item.MicroApp, item.Key, authz.ActionView))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			visible = append(visible, item)
+		}
+	}
+	return visible, nil
+}
+
 func (h *Handler) List(c *gin.Context) {
 	type rbody struct {
 		common.Page
@@ -172,6 +167,7 @@ func (h *Handler) Add(c *gin.Context) {
 	type rbody struct {
 		menu.Menu
 		menu.Route
+		Type string `json:"type"`
 	}
 
 	var body rbody
@@ -183,6 +179,15 @@ func (h *Handler) Add(c *gin.Context) {
 	menuTable := MenuTable{
 		Menu:  body.Menu,
 		Route: body.Route,
+		Type:  body.Type,
+	}
+	if menuTable.IsAction() {
+		if err := validateAction(h.db, &menuTable); err != nil {
+			c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+			return
+		}
+	} else {
+		menuTable.Type = TypeMenu
 	}
 
 	// 检查是否存在相同的key
@@ -193,11 +198,13 @@ func (h *Handler) Add(c *gin.Context) {
 		return
 	}
 
-	// 检查是否存在相同的path
-	h.db.Model(&MenuTable{}).Where("path = ?", body.Path).Count(&count)
-	if count > 0 {
-		c.JSON(http.StatusBadRequest, common.RespErr("path already exists", h.info))
-		return
+	// 检查是否存在相同的path，按钮没有路由
+	if !menuTable.IsAction() {
+		h.db.Model(&MenuTable{}).Where("path = ?", body.Path).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusBadRequest, common.RespErr("path already exists", h.info))
+			return
+		}
 	}
 
 	if err := h.db.Create(&menuTable).Error; err != nil {
@@ -219,7 +226,22 @@ func (h *Handler) Del(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.Delete(&MenuTable{}, uid).Error; err != nil {
+	// 页面下的按钮随页面一起删除
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var data MenuTable
+		if err := tx.Where("id = ?", uid).First(&data).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("parent_key = ? AND type = ?", data.Key, TypeAction).Delete(&MenuTable{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&data).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("menu not found", h.info))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
 		return
 	}
@@ -307,6 +329,14 @@ func (h *Handler) Update(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
 		return
 	}
+	if menu.IsAction() {
+		if err := validateAction(h.db, &menu); err != nil {
+			c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+			return
+		}
+	} else {
+		menu.Type = TypeMenu
+	}
 
 	if err := h.db.Save(&menu).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
@@ -325,6 +355,7 @@ func (h *Handler) GetTree(c *gin.Context) {
 		treeData = append(treeData, &TreeMenu{
 			Title:    data.Label,
 			Key:      data.Key,
+			Type:     data.Type,
 			Children: make([]*TreeMenu, 0),
 		})
 	}
@@ -343,6 +374,7 @@ func getTreeMenu(db *gorm.DB, data []*TreeMenu) {
 			item.Children = append(item.Children, &TreeMenu{
 				Title:    data.Label,
 				Key:      data.Key,
+				Type:     data.Type,
 				Children: make([]*TreeMenu, 0),
 			})
 		}
@@ -350,27 +382,56 @@ func getTreeMenu(db *gorm.DB, data []*TreeMenu) {
 	}
 }
 
+// GetMine 当前用户生效的权限码，包括按钮权限码和接口权限码，用于前端控制按钮显示
+func (h *Handler) GetMine(c *gin.Context) {
+	var actions []string
+	if err := h.db.Model(&MenuTable{}).Where("type = ?", TypeAction).Pluck("key", &actions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	slices.Sort(actions)
+	codes, err := authz.Allowed(c, h.authz, authz.ActionView, actions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	apis := make([]string, len(common.Permissions))
+	for i, p := range common.Permissions {
+		apis[i] = p.Code
+	}
+	apis, err = authz.Allowed(c, h.authz, authz.ActionCall, apis)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("get my permission success", append(codes, apis...), h.info))
+}
+
 type MicroAppHandler struct {
-	db   *gorm.DB
-	l    *slog.Logger
-	info common.Info
+	db    *gorm.DB
+	l     *slog.Logger
+	authz authz.Authorizer
+	info  common.Info
 }
 
 func (h *MicroAppHandler) Register(e *gin.Engine) {
-	e.POST("/micro-app/list", h.List)
-	e.POST("/micro-app", h.Add)
-	e.DELETE("/micro-app/:id", h.Del)
-	e.GET("/micro-app/:id", h.GetDetail)
-	e.PUT("/micro-app", h.Update)
+	e.POST("/micro-app/list", authz.RequirePermission(h.authz, h.info, common.PermMicroAppList), h.List)
+	e.POST("/micro-app", authz.RequirePermission(h.authz, h.info, common.PermMicroAppCreate), h.Add)
+	e.DELETE("/micro-app/:id", authz.RequirePermission(h.authz, h.info, common.PermMicroAppDelete), h.Del)
+	e.GET("/micro-app/:id", authz.RequirePermission(h.authz, h.info, common.PermMicroAppList), h.GetDetail)
+	e.PUT("/micro-app", authz.RequirePermission(h.authz, h.info, common.PermMicroAppUpdate), h.Update)
 	e.GET("/micro-app/select", h.GetSelect)
 	e.GET("/micro-app/key/:key", h.GetDetailByKey)
 }
 
-func NewMicroAppHandler(l *slog.Logger, info common.Info, db *gorm.DB) *MicroAppHandler {
+func NewMicroAppHandler(l *slog.Logger, info common.Info, db *gorm.DB, authorizer authz.Authorizer) *MicroAppHandler {
 	return &MicroAppHandler{
-		db:   db,
-		l:    l,
-		info: info,
+		db:    db,
+		l:     l,
+		authz: authorizer,
+		info:  info,
 	}
 }
 
@@ -1,14 +1,29 @@
 package menu
 
 import (
+	"errors"
+	"regexp"
+	"strings"
+
 	"github.com/z876730060/auth/pkg/menu"
 	"gorm.io/gorm"
 )
 
+// 菜单类型，按钮是页面下的操作，key即权限码
+const (
+	TypeMenu   = "menu"
+	TypeAction = "action"
+)
+
+// actionPattern 按钮权限码的操作部分，完整权限码为 父菜单key:操作，如 /user:export
+var actionPattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
 type MenuTable struct {
 	gorm.Model
 	menu.Menu
 	menu.Route
+	// Type 为空时视为页面菜单
+	Type         string   `json:"type" gorm:"size:20;default:menu"`
 	OrderId      int      `json:"orderId" gorm:"default:0"`
 	MicroAppBean MicroApp `json:"microAppBean" gorm:"foreignKey:MicroApp;references:Key"`
 }
@@ -16,6 +31,7 @@ type MenuTable struct {
 type TreeMenu struct {
 	Title    string      `json:"title"`
 	Key      string      `json:"key"`
+	Type     string      `json:"type"`
 	Children []*TreeMenu `json:"children"`
 }
 
@@ -23,6 +39,39 @@ func (m *MenuTable) TableName() string {
 	return "menu"
 }
 
+// IsAction 是否为按钮
+func (m *MenuTable) IsAction() bool {
+	return m.Type == TypeAction
+}
+
+// pages 只查询页面菜单，排除按钮
+func pages(db *gorm.DB) *gorm.DB {
+	return db.Where("type IS NULL OR type <> ?", TypeAction)
+}
+
+// validateAction 按钮必须挂在页面菜单下，key为 父菜单key:操作，且没有路由
+func validateAction(db *gorm.DB, m *MenuTable) error {
+	if m.ParentKey == "" {
+		return errors.New("action must have a parent menu")
+	}
+	action, ok := strings.CutPrefix(m.Key, m.ParentKey+":")
+	if !ok || !actionPattern.MatchString(action) {
+		return errors.New("action key must be <parentKey>:<action>")
+	}
+	var parent MenuTable
+	if err := db.Where(MenuTable{Menu: menu.Menu{Key: m.ParentKey}}).First(&parent).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("parent menu not found")
+		}
+		return err
+	}
+	if parent.IsAction() {
+		return errors.New("action cannot have children")
+	}
+	m.Route = menu.Route{}
+	return nil
+}
+
 type MicroApp struct {
 	gorm.Model
 	Name    string `json:"name"`
@@ -150,6 +199,22 @@ func InitMenuTable(db *gorm.DB) {
 			Component: "page/user/EditUser",
 		},
 	})
+	db.Create(&MenuTable{
+		Menu: menu.Menu{
+			Key:       "/user:export",
+			Label:     "导出用户",
+			ParentKey: "/user",
+		},
+		Type: TypeAction,
+	})
+	db.Create(&MenuTable{
+		Menu: menu.Menu{
+			Key:       "/user:delete",
+			Label:     "删除用户",
+			ParentKey: "/user",
+		},
+		Type: TypeAction,
+	})
 	db.Create(&MenuTable{
 		Menu: menu.Menu{
 			Key:       "/user/role",
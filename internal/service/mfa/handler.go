@@ -0,0 +1,153 @@
+package mfa
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/common"
+)
+
+// Handler 当前登录用户的MFA管理
+type Handler struct {
+	l       *slog.Logger
+	service *Service
+	info    common.Info
+}
+
+func NewHandler(l *slog.Logger, service *Service, info common.Info) *Handler {
+	return &Handler{l: l, service: service, info: info}
+}
+
+func (h *Handler) Register(e *gin.Engine) {
+	e.GET("/mfa", common.RequireUser(h.info), h.Status)
+	e.POST("/mfa/totp/enroll", common.RequireUser(h.info), h.Enroll)
+	e.POST("/mfa/totp/confirm", common.RequireUser(h.info), h.Confirm)
+	e.DELETE("/mfa/totp", common.RequireUser(h.info), h.Disable)
+	e.POST("/mfa/recovery-codes", common.RequireUser(h.info), h.RegenerateRecoveryCodes)
+}
+
+type codeReq struct {
+	Code string `json:"code"`
+}
+
+// Status 获取MFA绑定状态
+func (h *Handler) Status(c *gin.Context) {
+	uid := c.GetUint("userId")
+	enabled, err := h.service.Enabled(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	required, err := h.service.Required(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	remaining, err := h.service.RemainingRecoveryCodes(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("get mfa status success", gin.H{
+		"totp":                   enabled,
+		"required":               required,
+		"recoveryCodesRemaining": remaining,
+	}, h.info))
+}
+
+// Enroll 开始绑定TOTP，返回密钥、otpauth地址和二维码
+func (h *Handler) Enroll(c *gin.Context) {
+	enrollment, err := h.service.Begin(c.GetUint("userId"), c.GetString("username"))
+	if err != nil {
+		if errors.Is(err, ErrAlreadyEnrolled) {
+			c.JSON(http.StatusConflict, common.RespErr(err.Error(), h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("begin totp enrollment success", enrollment, h.info))
+}
+
+// Confirm 使用第一个验证码完成绑定，返回恢复码
+func (h *Handler) Confirm(c *gin.Context) {
+	var req codeReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	codes, err := h.service.Confirm(c.GetUint("userId"), req.Code)
+	if err != nil {
+		h.respServiceErr(c, err)
+		return
+	}
+
+	h.l.Info("totp enrolled", "userId", c.GetUint("userId"))
+	c.JSON(http.StatusOK, common.RespOk("confirm totp enrollment success", gin.H{
+		"recoveryCodes": codes,
+	}, h.info))
+}
+
+// Disable 校验当前验证码后解除绑定
+func (h *Handler) Disable(c *gin.Context) {
+	var req codeReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	uid := c.GetUint("userId")
+	if err := h.service.Verify(uid, req.Code); err != nil {
+		h.respServiceErr(c, err)
+		return
+	}
+	if err := h.service.Disable(uid); err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("totp disabled", "userId", uid)
+	c.JSON(http.StatusOK, common.RespOk("disable totp success", nil, h.info))
+}
+
+// RegenerateRecoveryCodes 校验当前验证码后重新生成恢复码
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req codeReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	uid := c.GetUint("userId")
+	if err := h.service.Verify(uid, req.Code); err != nil {
+		h.respServiceErr(c, err)
+		return
+	}
+	codes, err := h.service.RegenerateRecoveryCodes(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("regenerate recovery codes success", gin.H{
+		"recoveryCodes": codes,
+	}, h.info))
+}
+
+func (h *Handler) respServiceErr(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidCode):
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+	case errors.Is(err, ErrNotEnrolled):
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+	case errors.Is(err, ErrAlreadyEnrolled):
+		c.JSON(http.StatusConflict, common.RespErr(err.Error(), h.info))
+	default:
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+	}
+}
@@ -0,0 +1,45 @@
+package mfa
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TOTP 用户的TOTP密钥，Enabled为false表示尚未完成绑定
+type TOTP struct {
+	gorm.Model
+	UserID  uint   `json:"userId" gorm:"uniqueIndex"`
+	Secret  string `json:"-"`
+	Enabled bool   `json:"enabled"`
+	// LastStep 最近一次使用的时间步长，同一验证码不能重复使用
+	LastStep int64 `json:"-"`
+}
+
+func (TOTP) TableName() string {
+	return "user_totp"
+}
+
+// RecoveryCode 一次性恢复码，仅保存哈希
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint       `json:"userId" gorm:"index"`
+	CodeHash string     `json:"-" gorm:"index"`
+	UsedAt   *time.Time `json:"usedAt"`
+}
+
+func (RecoveryCode) TableName() string {
+	return "user_recovery_code"
+}
+
+// Enrollment 开始绑定时返回给前端的信息
+type Enrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauthUri"`
+	QRCode     string `json:"qrCode"`
+}
+
+func InitMFATable(db *gorm.DB) {
+	db.AutoMigrate(&TOTP{})
+	db.AutoMigrate(&RecoveryCode{})
+}
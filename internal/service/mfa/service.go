@@ -0,0 +1,220 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/z876730060/auth/internal/service/role"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrNotEnrolled     = errors.New("mfa not enrolled")
+	ErrAlreadyEnrolled = errors.New("mfa already enrolled")
+	ErrInvalidCode     = errors.New("invalid mfa code")
+)
+
+// Service TOTP绑定、校验及恢复码管理
+type Service struct {
+	db     *gorm.DB
+	issuer string
+}
+
+func NewService(db *gorm.DB, issuer string) *Service {
+	return &Service{db: db, issuer: issuer}
+}
+
+// Enabled 用户是否已完成TOTP绑定
+func (s *Service) Enabled(userID uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&TOTP{}).Where("user_id = ? AND enabled = ?", userID, true).Count(&count).Error
+	return count > 0, err
+}
+
+// Required 用户的任一角色要求MFA时返回true
+func (s *Service) Required(userID uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&role.Role{}).
+		Where("require_mfa = ? AND id IN (?)", true,
+			s.db.Model(&user.UserRole{}).Where("user_id = ?", userID).Select("role_id"),
+		).Count(&count).Error
+	return count > 0, err
+}
+
+// Begin 生成新的TOTP密钥，等待用户使用第一个验证码确认
+func (s *Service) Begin(userID uint, account string) (*Enrollment, error) {
+	var t TOTP
+	err := s.db.Where(TOTP{UserID: userID}).First(&t).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if t.Enabled {
+		return nil, ErrAlreadyEnrolled
+	}
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	t.UserID, t.Secret, t.LastStep = userID, secret, 0
+	if err := s.db.Save(&t).Error; err != nil {
+		return nil, err
+	}
+
+	uri := URI(s.issuer, account, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return nil, err
+	}
+	return &Enrollment{
+		Secret:     secret,
+		OtpauthURI: uri,
+		QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+// Confirm 使用第一个验证码完成绑定，并返回新生成的恢复码
+func (s *Service) Confirm(userID uint, code string) ([]string, error) {
+	var t TOTP
+	if err := s.db.Where(TOTP{UserID: userID}).First(&t).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotEnrolled
+		}
+		return nil, err
+	}
+	if t.Enabled {
+		return nil, ErrAlreadyEnrolled
+	}
+
+	step, ok := ValidateTOTP(t.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&t).Updates(map[string]any{"enabled": true, "last_step": step}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify 校验已绑定用户的TOTP验证码，同一时间步长的验证码只能使用一次
+func (s *Service) Verify(userID uint, code string) error {
+	var t TOTP
+	if err := s.db.Where("user_id = ? AND enabled = ?", userID, true).First(&t).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotEnrolled
+		}
+		return err
+	}
+
+	step, ok := ValidateTOTP(t.Secret, code, time.Now())
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	result := s.db.Model(&TOTP{}).Where("id = ? AND last_step < ?", t.ID, step).Update("last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// VerifyRecoveryCode 使用一次性恢复码
+func (s *Service) VerifyRecoveryCode(userID uint, code string) error {
+	result := s.db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// RemainingRecoveryCodes 剩余可用的恢复码数量
+func (s *Service) RemainingRecoveryCodes(userID uint) (int64, error) {
+	var count int64
+	err := s.db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码，旧的恢复码全部失效
+func (s *Service) RegenerateRecoveryCodes(userID uint) ([]string, error) {
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// Disable 解除TOTP绑定并删除恢复码
+func (s *Service) Disable(userID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&TOTP{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Create(&RecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(code)}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// newRecoveryCode 生成 xxxxx-xxxxx 格式的恢复码
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode 恢复码忽略大小写和分隔符
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
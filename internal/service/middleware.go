@@ -1,51 +1,144 @@
 package service
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/pat"
+	"github.com/z876730060/auth/internal/service/serviceaccount"
+	"github.com/z876730060/auth/internal/service/session"
 	"github.com/z876730060/auth/internal/service/user"
 )
 
-func AuthMiddleware(l *slog.Logger) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		header := c.GetHeader("Authorization")
-		if header == "" {
-			c.AbortWithStatus(http.StatusUnauthorized)
-		}
-		l.Info("Authorization", "header", header)
+// patDeniedRoutes 个人访问令牌不能访问的账号安全接口及其子路由
+// 令牌泄露后不能用来修改密码、MFA、通行密钥和会话，也不能签发新的个人访问令牌
+var patDeniedRoutes = []string{"/password", "/mfa", "/passkey", "/session", "/logout/all", "/pat", "/user/:id/pat"}
 
-		token := strings.TrimPrefix(header, "Bearer ")
-		claims, err := common.ValidateJavaJWT(token)
-		if err != nil {
-			c.AbortWithStatus(http.StatusUnauthorized)
+func AuthMiddleware(l *slog.Logger, sessions *session.Store, pats *pat.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, roles, status := authenticate(c, l, sessions, pats, c.Request.Method)
+		if status != http.StatusOK {
+			c.AbortWithStatus(status)
 			return
 		}
-		l.Info("Authorization", "claims", claims)
-
-		var userRole []user.UserRole
-		db.Model(&user.UserRole{}).Where("user_id = ?", claims.UserID).Find(&userRole)
-		roles := make([]uint, 0)
-		for _, role := range userRole {
-			roles = append(roles, role.RoleID)
-		}
 
 		c.Set("userId", claims.UserID)
+		if claims.ServiceAccountID != 0 {
+			c.Set("serviceAccountId", claims.ServiceAccountID)
+		}
 		c.Set("role", roles)
 		c.Set("username", claims.Username)
-		// TODO: 验证 Authorization header 是否有效
-		// 例如，检查是否包含有效的 token 或其他验证逻辑
-		// 如果无效，返回 401 Unauthorized 错误
-		// 如果有效，继续处理请求
+		c.Set("claims", claims)
 		c.Next()
 	}
 }
 
+// authenticate 校验请求携带的令牌并查询用户角色，失败时返回对应的HTTP状态码
+// method为实际访问的请求方法，用于校验个人访问令牌的权限范围
+func authenticate(c *gin.Context, l *slog.Logger, sessions *session.Store, pats *pat.Service, method string) (*common.CompatibleClaims, []uint, int) {
+	token := sessions.TokenFromRequest(c)
+	if token == "" {
+		return nil, nil, http.StatusUnauthorized
+	}
+
+	var claims *common.CompatibleClaims
+	if pat.IsToken(token) {
+		t, u, err := pats.Authenticate(c, token, c.ClientIP())
+		if err != nil {
+			if errors.Is(err, pat.ErrInvalidToken) {
+				return nil, nil, http.StatusUnauthorized
+			}
+			l.Error("check personal access token failed", "err", err)
+			return nil, nil, http.StatusInternalServerError
+		}
+		if !t.Allows(method) || patDenied(c.FullPath()) {
+			return nil, nil, http.StatusForbidden
+		}
+		claims = patClaims(t, u)
+		c.Set("patId", t.ID)
+	} else {
+		// 校验令牌签名、有效期以及是否已被撤销
+		var err error
+		claims, err = sessions.Authenticate(c, token)
+		if err != nil {
+			if errors.Is(err, session.ErrInvalidToken) || errors.Is(err, session.ErrTokenRevoked) {
+				return nil, nil, http.StatusUnauthorized
+			}
+			l.Error("check token revocation failed", "err", err)
+			return nil, nil, http.StatusInternalServerError
+		}
+		// 签发给OAuth2客户端的令牌只用于访问资源服务，不能调用本服务的管理接口
+		if claims.ClientID != "" {
+			return nil, nil, http.StatusUnauthorized
+		}
+		if claims.SessionID != "" {
+			if err := sessions.Touch(c, claims.SessionID, c.ClientIP()); err != nil {
+				l.Warn("record session activity failed", "err", err)
+			}
+		}
+	}
+	// 服务账号使用自己绑定的角色，停用或删除后已签发的令牌立即失效
+	if claims.ServiceAccountID != 0 {
+		account, roles, err := serviceaccount.Resolve(c, db, claims.ServiceAccountID)
+		if err != nil {
+			if errors.Is(err, serviceaccount.ErrNotFound) {
+				return nil, nil, http.StatusUnauthorized
+			}
+			l.Error("query service account failed", "err", err)
+			return nil, nil, http.StatusInternalServerError
+		}
+		claims.Username = account.ClientID
+		l.Info("Authorization", "principal", common.PrincipalServiceAccount, "serviceAccountId", account.ID, "clientId", account.ClientID, "jti", claims.ID)
+		return claims, roles, http.StatusOK
+	}
+	l.Info("Authorization", "principal", common.PrincipalUser, "userId", claims.UserID, "username", claims.Username, "jti", claims.ID)
+
+	var userRole []user.UserRole
+	if err := db.Model(&user.UserRole{}).Where("user_id = ?", claims.UserID).Find(&userRole).Error; err != nil {
+		l.Error("query user role failed", "err", err)
+		return nil, nil, http.StatusInternalServerError
+	}
+	roles := make([]uint, 0)
+	for _, role := range userRole {
+		roles = append(roles, role.RoleID)
+	}
+	return claims, roles, http.StatusOK
+}
+
+// patDenied 路由是否禁止个人访问令牌访问
+func patDenied(route string) bool {
+	return slices.ContainsFunc(patDeniedRoutes, func(p string) bool {
+		return route == p || strings.HasPrefix(route, p+"/")
+	})
+}
+
+// patClaims 个人访问令牌对应的claims，令牌ID作为jti
+func patClaims(t *pat.Token, u *user.User) *common.CompatibleClaims {
+	claims := &common.CompatibleClaims{
+		UserID:   u.ID,
+		Username: u.Username,
+		Roles:    []string{},
+		Scope:    strings.Join(t.Scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: strconv.FormatUint(uint64(u.ID), 10),
+			ID:      pat.Prefix + strconv.FormatUint(uint64(t.ID), 10),
+		},
+	}
+	if t.ExpiresAt != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*t.ExpiresAt)
+	}
+	return claims
+}
+
 func BaseMiddleware(l *slog.Logger) gin.HandlerFunc {
 	total := atomic.Int64{}
 	count := atomic.Int64{}
@@ -0,0 +1,268 @@
+package oauth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/authz"
+	"github.com/z876730060/auth/internal/service/common"
+	"gorm.io/gorm"
+)
+
+// ClientHandler OAuth2客户端管理
+type ClientHandler struct {
+	l     *slog.Logger
+	db    *gorm.DB
+	authz authz.Authorizer
+	info  common.Info
+}
+
+func NewClientHandler(l *slog.Logger, db *gorm.DB, authorizer authz.Authorizer, info common.Info) *ClientHandler {
+	return &ClientHandler{l: l, db: db, authz: authorizer, info: info}
+}
+
+func (h *ClientHandler) Register(e *gin.Engine) {
+	e.POST("/oauth2/client/list", authz.RequirePermission(h.authz, h.info, common.PermOAuthClientList), h.List)
+	e.POST("/oauth2/client", authz.RequirePermission(h.authz, h.info, common.PermOAuthClientCreate), h.Add)
+	e.GET("/oauth2/client/:id", authz.RequirePermission(h.authz, h.info, common.PermOAuthClientList), h.GetDetail)
+	e.PUT("/oauth2/client", authz.RequirePermission(h.authz, h.info, common.PermOAuthClientUpdate), h.Update)
+	e.DELETE("/oauth2/client/:id", authz.RequirePermission(h.authz, h.info, common.PermOAuthClientDelete), h.Del)
+	e.POST("/oauth2/client/:id/secret", authz.RequirePermission(h.authz, h.info, common.PermOAuthClientUpdate), h.RotateSecret)
+}
+
+type clientReq struct {
+	ID           uint     `json:"id"`
+	ClientID     string   `json:"clientId"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirectUris"`
+	GrantTypes   []string `json:"grantTypes"`
+	Scopes       []string `json:"scopes"`
+	Public       bool     `json:"public"`
+	SkipConsent  bool     `json:"skipConsent"`
+}
+
+func (r *clientReq) Validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(r.GrantTypes) == 0 {
+		r.GrantTypes = []string{GrantAuthorizationCode, GrantRefreshToken}
+	}
+	for _, g := range r.GrantTypes {
+		if g != GrantAuthorizationCode && g != GrantRefreshToken && g != GrantClientCredentials {
+			return fmt.Errorf("unsupported grant type: %s", g)
+		}
+	}
+	if r.Public && slices.Contains(r.GrantTypes, GrantClientCredentials) {
+		return errors.New("public client cannot use client_credentials")
+	}
+	if slices.Contains(r.GrantTypes, GrantAuthorizationCode) && len(r.RedirectURIs) == 0 {
+		return errors.New("redirectUris is required")
+	}
+	for _, uri := range r.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Fragment != "" {
+			return fmt.Errorf("invalid redirect uri: %s", uri)
+		}
+	}
+	return nil
+}
+
+// List 客户端列表
+func (h *ClientHandler) List(c *gin.Context) {
+	type rBody struct {
+		common.Page
+	}
+	var req rBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	var data []Client
+	var count int64
+	h.db.Model(&Client{}).Count(&count).Order("id").Offset((req.Page.Page - 1) * req.Size).Limit(req.Size).Find(&data)
+
+	c.JSON(http.StatusOK, common.RespOk("get client list success", gin.H{
+		"records": data,
+		"total":   count,
+	}, h.info))
+}
+
+// Add 注册客户端，密钥只在创建时返回一次
+func (h *ClientHandler) Add(c *gin.Context) {
+	var req clientReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	client := Client{
+		ClientID:     req.ClientID,
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		GrantTypes:   req.GrantTypes,
+		Scopes:       req.Scopes,
+		Public:       req.Public,
+		SkipConsent:  req.SkipConsent,
+	}
+	var err error
+	if client.ClientID == "" {
+		if client.ClientID, err = common.RandomString(16); err != nil {
+			c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+			return
+		}
+	}
+	var secret string
+	if !client.Public {
+		if secret, err = common.RandomString(32); err != nil {
+			c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+			return
+		}
+		client.SecretHash = common.HashKey(secret)
+	}
+
+	if err := h.db.Create(&client).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, common.RespErr("client id already exists", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("oauth client registered", "clientId", client.ClientID, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("add client success", gin.H{
+		"client":       client,
+		"clientSecret": secret,
+	}, h.info))
+}
+
+// GetDetail 客户端详情
+func (h *ClientHandler) GetDetail(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	var client Client
+	if err := h.db.Where("id = ?", id).First(&client).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("client not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("get client detail success", client, h.info))
+}
+
+// Update 修改客户端，client id和密钥不可修改
+func (h *ClientHandler) Update(c *gin.Context) {
+	var req clientReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.ID == 0 {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	var client Client
+	if err := h.db.Where("id = ?", req.ID).First(&client).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("client not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	if req.Public != client.Public {
+		c.JSON(http.StatusBadRequest, common.RespErr("client type cannot be changed", h.info))
+		return
+	}
+
+	client.Name = req.Name
+	client.RedirectURIs = req.RedirectURIs
+	client.GrantTypes = req.GrantTypes
+	client.Scopes = req.Scopes
+	client.SkipConsent = req.SkipConsent
+	if err := h.db.Save(&client).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("update client success", client, h.info))
+}
+
+// Del 删除客户端及用户的授权记录
+func (h *ClientHandler) Del(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var client Client
+		if err := tx.Where("id = ?", id).First(&client).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("client_id = ?", client.ClientID).Delete(&Consent{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&client).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("client not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("oauth client deleted", "id", id, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("delete client success", nil, h.info))
+}
+
+// RotateSecret 重新生成客户端密钥，旧密钥立即失效
+func (h *ClientHandler) RotateSecret(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	secret, err := common.RandomString(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	result := h.db.Model(&Client{}).Where("id = ? AND public = ?", id, false).Update("secret_hash", common.HashKey(secret))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(result.Error.Error(), h.info))
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, common.RespErr("client not found", h.info))
+		return
+	}
+
+	h.l.Info("oauth client secret rotated", "id", id, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("rotate client secret success", gin.H{
+		"clientSecret": secret,
+	}, h.info))
+}
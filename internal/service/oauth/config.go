@@ -0,0 +1,30 @@
+package oauth
+
+import "time"
+
+// Config OAuth2授权服务配置
+type Config struct {
+	// Issuer OIDC签发者地址，如 https://auth.example.com，必须配置，不根据请求的Host推断
+	Issuer string `json:"issuer"`
+	// LoginURL 未登录时跳转的前端登录页，登录后需跳回redirect参数中的授权地址
+	LoginURL string `json:"loginurl"`
+	// CodeTTL 授权码有效期
+	CodeTTL time.Duration `json:"codettl"`
+	// ConsentTTL 授权确认页的有效期
+	ConsentTTL time.Duration `json:"consentttl"`
+	// IDTokenTTL ID token有效期
+	IDTokenTTL time.Duration `json:"idtokenttl"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.CodeTTL == 0 {
+		c.CodeTTL = time.Minute
+	}
+	if c.ConsentTTL == 0 {
+		c.ConsentTTL = 10 * time.Minute
+	}
+	if c.IDTokenTTL == 0 {
+		c.IDTokenTTL = time.Hour
+	}
+	return c
+}
@@ -0,0 +1,63 @@
+package oauth
+
+import (
+	"html/template"
+
+	"github.com/gin-gonic/gin"
+)
+
+var consentTmpl = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>授权确认</title>
+<style>
+body{font-family:sans-serif;background:#f5f5f5;display:flex;justify-content:center;padding-top:10vh}
+.card{background:#fff;border-radius:8px;padding:24px 32px;min-width:320px;box-shadow:0 2px 8px rgba(0,0,0,.1)}
+ul{padding-left:20px}button{padding:6px 20px;margin-right:8px;cursor:pointer}
+</style>
+</head>
+<body>
+<div class="card">
+{{if .Error}}
+<h3>授权失败</h3>
+<p>{{.Error}}</p>
+{{else}}
+<h3>{{.ClientName}} 申请访问您的账号</h3>
+<p>当前用户：{{.Username}}</p>
+{{if .Scopes}}<p>申请的权限：</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>{{end}}
+<form method="post" action="authorize">
+<input type="hidden" name="consent_id" value="{{.ConsentID}}">
+<button type="submit" name="action" value="approve">同意</button>
+<button type="submit" name="action" value="deny">拒绝</button>
+</form>
+{{end}}
+</div>
+</body>
+</html>
+`))
+
+type consentPage struct {
+	ClientName string
+	Username   string
+	Scopes     []string
+	ConsentID  string
+	Error      string
+}
+
+func renderConsent(c *gin.Context, status int, page consentPage) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := consentTmpl.Execute(c.Writer, page); err != nil {
+		c.Error(err)
+	}
+}
+
+// renderError 无法回调客户端时直接展示错误页
+func renderError(c *gin.Context, status int, msg string) {
+	renderConsent(c, status, consentPage{Error: msg})
+}
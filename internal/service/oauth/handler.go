@@ -0,0 +1,530 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-contrib/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/session"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+const (
+	codeKey    = "oauth:code:%s"
+	consentKey = "oauth:consent:%s"
+)
+
+// 令牌端点及授权回调的错误码，见RFC 6749 4.1.2.1、5.2
+const (
+	errInvalidRequest          = "invalid_request"
+	errInvalidClient           = "invalid_client"
+	errInvalidGrant            = "invalid_grant"
+	errUnauthorizedClient      = "unauthorized_client"
+	errUnsupportedGrantType    = "unsupported_grant_type"
+	errUnsupportedResponseType = "unsupported_response_type"
+	errInvalidScope            = "invalid_scope"
+	errAccessDenied            = "access_denied"
+	errServerError             = "server_error"
+)
+
+// Handler OAuth2授权服务，提供授权端点和令牌端点
+type Handler struct {
+	l        *slog.Logger
+	db       *gorm.DB
+	rdb      *redis.Client
+	sessions *session.Store
+	info     common.Info
+	cfg      Config
+}
+
+func NewHandler(l *slog.Logger, db *gorm.DB, rdb *redis.Client, sessions *session.Store, info common.Info, cfg Config) (*Handler, error) {
+	cfg = cfg.withDefaults()
+	var err error
+	if cfg.Issuer, err = common.ParseRootURL("oauth issuer", cfg.Issuer); err != nil {
+		return nil, err
+	}
+	return &Handler{l: l, db: db, rdb: rdb, sessions: sessions, info: info, cfg: cfg}, nil
+}
+
+func (h *Handler) Register(e *gin.Engine) {
+	e.GET("/oauth2/authorize", h.Authorize)
+	e.POST("/oauth2/authorize", h.Consent)
+	e.POST("/oauth2/token", h.Token)
+	e.POST("/oauth2/introspect", h.Introspect)
+	e.POST("/oauth2/revoke", h.Revoke)
+	e.GET("/.well-known/openid-configuration", h.Discovery)
+	e.GET("/userinfo", h.UserInfo)
+	e.POST("/userinfo", h.UserInfo)
+}
+
+// Authorize 授权端点，校验请求后根据授权记录直接回调或展示授权确认页
+func (h *Handler) Authorize(c *gin.Context) {
+	var req authorizeReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		renderError(c, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	// 客户端和回调地址校验失败时不能回调，直接展示错误页
+	client, err := h.findClient(req.ClientID)
+	if err != nil {
+		renderError(c, http.StatusBadRequest, "invalid client")
+		return
+	}
+	redirect := req.RedirectURI
+	if redirect == "" && len(client.RedirectURIs) == 1 {
+		redirect = client.RedirectURIs[0]
+	}
+	if !slices.Contains(client.RedirectURIs, redirect) {
+		renderError(c, http.StatusBadRequest, "invalid redirect uri")
+		return
+	}
+
+	if req.ResponseType != "code" {
+		redirectErr(c, redirect, req.State, errUnsupportedResponseType, "only code response type is supported")
+		return
+	}
+	if !client.AllowsGrant(GrantAuthorizationCode) {
+		redirectErr(c, redirect, req.State, errUnauthorizedClient, "client is not allowed to use authorization code")
+		return
+	}
+	if !subsetOf(parseScope(req.Scope), client.Scopes) {
+		redirectErr(c, redirect, req.State, errInvalidScope, "requested scope is not allowed")
+		return
+	}
+	if slices.Contains(parseScope(req.Scope), ScopeOpenID) && common.GetKeySet().IDTokenAlg() == "" {
+		redirectErr(c, redirect, req.State, errInvalidScope, "openid is not supported without an id token signing key")
+		return
+	}
+	if req.CodeChallenge == "" && client.Public {
+		redirectErr(c, redirect, req.State, errInvalidRequest, "code_challenge is required")
+		return
+	}
+	if req.CodeChallenge != "" && req.CodeChallengeMethod != "S256" {
+		redirectErr(c, redirect, req.State, errInvalidRequest, "only S256 code_challenge_method is supported")
+		return
+	}
+
+	// 未登录时跳转登录页，登录成功后由前端跳回当前地址
+	claims, ok := h.currentUser(c)
+	if !ok {
+		if h.cfg.LoginURL == "" {
+			renderError(c, http.StatusUnauthorized, "login required")
+			return
+		}
+		c.Redirect(http.StatusFound, h.cfg.LoginURL+"?redirect="+url.QueryEscape(c.Request.URL.RequestURI()))
+		return
+	}
+
+	pending := &pendingConsent{
+		authorizeReq: req,
+		Redirect:     redirect,
+		UserID:       claims.UserID,
+		Username:     claims.Username,
+		AuthTime:     claims.IssuedAt.Unix(),
+	}
+	scopes := parseScope(req.Scope)
+	consented, err := h.consented(claims.UserID, client.ClientID, scopes)
+	if err != nil {
+		redirectErr(c, redirect, req.State, errServerError, "")
+		return
+	}
+	if client.SkipConsent || consented {
+		h.redirectWithCode(c, pending)
+		return
+	}
+
+	consentID, err := common.RandomString(32)
+	var data []byte
+	if err == nil {
+		data, err = json.Marshal(pending)
+	}
+	if err == nil {
+		err = h.rdb.Set(c, fmt.Sprintf(consentKey, common.HashKey(consentID)), data, h.cfg.ConsentTTL).Err()
+	}
+	if err != nil {
+		h.l.Error("save pending consent failed", "err", err)
+		redirectErr(c, redirect, req.State, errServerError, "")
+		return
+	}
+
+	renderConsent(c, http.StatusOK, consentPage{
+		ClientName: client.Name,
+		Username:   claims.Username,
+		Scopes:     scopes,
+		ConsentID:  consentID,
+	})
+}
+
+// Consent 处理授权确认页提交，consent_id只能使用一次，同时起到CSRF令牌的作用
+func (h *Handler) Consent(c *gin.Context) {
+	claims, ok := h.currentUser(c)
+	if !ok {
+		renderError(c, http.StatusUnauthorized, "login required")
+		return
+	}
+
+	data, err := h.rdb.GetDel(c, fmt.Sprintf(consentKey, common.HashKey(c.PostForm("consent_id")))).Bytes()
+	if err != nil {
+		renderError(c, http.StatusBadRequest, "authorization request expired")
+		return
+	}
+	var pending pendingConsent
+	if err := json.Unmarshal(data, &pending); err != nil || pending.UserID != claims.UserID {
+		renderError(c, http.StatusBadRequest, "authorization request expired")
+		return
+	}
+
+	if c.PostForm("action") != "approve" {
+		redirectErr(c, pending.Redirect, pending.State, errAccessDenied, "user denied the request")
+		return
+	}
+
+	if err := h.saveConsent(claims.UserID, pending.ClientID, parseScope(pending.Scope)); err != nil {
+		h.l.Error("save consent failed", "userId", claims.UserID, "clientId", pending.ClientID, "err", err)
+		redirectErr(c, pending.Redirect, pending.State, errServerError, "")
+		return
+	}
+	h.l.Info("oauth consent granted", "userId", claims.UserID, "clientId", pending.ClientID, "scope", pending.Scope)
+	h.redirectWithCode(c, &pending)
+}
+
+// Token 令牌端点
+func (h *Handler) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	client, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	grantType := c.PostForm("grant_type")
+	switch grantType {
+	case GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials:
+	default:
+		common.TokenErr(c, http.StatusBadRequest, errUnsupportedGrantType, "")
+		return
+	}
+	if !client.AllowsGrant(grantType) {
+		common.TokenErr(c, http.StatusBadRequest, errUnauthorizedClient, "client is not allowed to use "+grantType)
+		return
+	}
+
+	switch grantType {
+	case GrantAuthorizationCode:
+		h.exchangeCode(c, client)
+	case GrantRefreshToken:
+		h.refresh(c, client)
+	case GrantClientCredentials:
+		h.clientCredentials(c, client)
+	}
+}
+
+// exchangeCode 使用授权码换取令牌
+func (h *Handler) exchangeCode(c *gin.Context, client *Client) {
+	code := c.PostForm("code")
+	if code == "" {
+		common.TokenErr(c, http.StatusBadRequest, errInvalidRequest, "code is required")
+		return
+	}
+	data, err := h.rdb.GetDel(c, fmt.Sprintf(codeKey, common.HashKey(code))).Bytes()
+	if errors.Is(err, redis.Nil) {
+		common.TokenErr(c, http.StatusBadRequest, errInvalidGrant, "invalid authorization code")
+		return
+	}
+	if err != nil {
+		h.serverErr(c, err)
+		return
+	}
+	var ac authorizationCode
+	if err := json.Unmarshal(data, &ac); err != nil {
+		h.serverErr(c, err)
+		return
+	}
+
+	if ac.ClientID != client.ClientID || ac.RedirectURI != c.PostForm("redirect_uri") {
+		common.TokenErr(c, http.StatusBadRequest, errInvalidGrant, "invalid authorization code")
+		return
+	}
+	if ac.CodeChallenge != "" && !verifyPKCE(ac.CodeChallenge, c.PostForm("code_verifier")) {
+		common.TokenErr(c, http.StatusBadRequest, errInvalidGrant, "invalid code_verifier")
+		return
+	}
+
+	// 授权码签发后用户可能已被删除
+	var u user.User
+	if err := h.db.Where(user.User{Model: gorm.Model{ID: ac.UserID}}).First(&u).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.TokenErr(c, http.StatusBadRequest, errInvalidGrant, "invalid authorization code")
+			return
+		}
+		h.serverErr(c, err)
+		return
+	}
+	roles, err := userRoles(h.db, ac.UserID)
+	if err != nil {
+		h.serverErr(c, err)
+		return
+	}
+	pair, err := h.sessions.IssueGrant(c, session.Grant{
+		UserID:   u.ID,
+		Username: u.Username,
+		Roles:    roles,
+		ClientID: client.ClientID,
+		Scope:    ac.Scope,
+	}, session.DeviceFromRequest(c))
+	if err != nil {
+		h.serverErr(c, err)
+		return
+	}
+
+	resp := h.tokenResp(client, pair)
+	if slices.Contains(parseScope(ac.Scope), ScopeOpenID) {
+		if resp.IDToken, err = h.idToken(&u, client.ClientID, &ac); err != nil {
+			h.serverErr(c, err)
+			return
+		}
+	}
+
+	h.l.Info("oauth token issued", "grantType", GrantAuthorizationCode, "clientId", client.ClientID, "userId", ac.UserID, "requestId", requestid.Get(c))
+	c.JSON(http.StatusOK, resp)
+}
+
+// refresh 使用refresh token换取新的令牌，refresh token只能由签发时的客户端使用
+func (h *Handler) refresh(c *gin.Context, client *Client) {
+	refreshToken := c.PostForm("refresh_token")
+	if refreshToken == "" {
+		common.TokenErr(c, http.StatusBadRequest, errInvalidRequest, "refresh_token is required")
+		return
+	}
+
+	pair, err := h.sessions.RefreshClient(c, refreshToken, client.ClientID)
+	if err != nil {
+		if errors.Is(err, session.ErrRefreshTokenReused) {
+			h.l.Warn("refresh token reused, session revoked", "clientId", client.ClientID, "requestId", requestid.Get(c))
+		}
+		if errors.Is(err, session.ErrInvalidRefreshToken) || errors.Is(err, session.ErrRefreshTokenReused) {
+			common.TokenErr(c, http.StatusBadRequest, errInvalidGrant, "invalid refresh token")
+			return
+		}
+		h.serverErr(c, err)
+		return
+	}
+
+	h.respToken(c, client, pair)
+}
+
+// clientCredentials 客户端以自身身份获取令牌，未指定scope时授予允许的全部scope
+func (h *Handler) clientCredentials(c *gin.Context, client *Client) {
+	scopes := parseScope(c.PostForm("scope"))
+	if !subsetOf(scopes, client.Scopes) {
+		common.TokenErr(c, http.StatusBadRequest, errInvalidScope, "requested scope is not allowed")
+		return
+	}
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	pair, err := h.sessions.IssueClient(client.ClientID, strings.Join(scopes, " "))
+	if err != nil {
+		h.serverErr(c, err)
+		return
+	}
+
+	h.l.Info("oauth token issued", "grantType", GrantClientCredentials, "clientId", client.ClientID, "requestId", requestid.Get(c))
+	h.respToken(c, client, pair)
+}
+
+// authenticateClient 支持client_secret_basic、client_secret_post，公开客户端只需要client_id
+func (h *Handler) authenticateClient(c *gin.Context) (*Client, bool) {
+	clientID, secret, basic := common.ClientCredentials(c)
+
+	client, err := h.findClient(clientID)
+	if err == nil && (client.Public || client.VerifySecret(secret)) {
+		return client, true
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		h.serverErr(c, err)
+		return nil, false
+	}
+
+	h.l.Warn("oauth client authentication failed", "clientId", clientID, "ip", c.ClientIP(), "requestId", requestid.Get(c))
+	if basic {
+		c.Header("WWW-Authenticate", `Basic realm="oauth2"`)
+	}
+	common.TokenErr(c, http.StatusUnauthorized, errInvalidClient, "client authentication failed")
+	return nil, false
+}
+
+func (h *Handler) respToken(c *gin.Context, client *Client, pair *session.TokenPair) {
+	c.JSON(http.StatusOK, h.tokenResp(client, pair))
+}
+
+func (h *Handler) tokenResp(client *Client, pair *session.TokenPair) TokenResp {
+	resp := TokenResp{
+		AccessToken: pair.AccessToken,
+		TokenType:   pair.TokenType,
+		ExpiresIn:   pair.ExpiresIn,
+		Scope:       pair.Scope,
+	}
+	if client.AllowsGrant(GrantRefreshToken) {
+		resp.RefreshToken = pair.RefreshToken
+	}
+	return resp
+}
+
+// currentUser 从请求头或cookie中获取已登录用户，OAuth2客户端的令牌不能用于授权
+func (h *Handler) currentUser(c *gin.Context) (*common.CompatibleClaims, bool) {
+	token := h.sessions.TokenFromRequest(c)
+	if token == "" {
+		return nil, false
+	}
+	claims, err := h.sessions.Authenticate(c, token)
+	if err != nil {
+		if !errors.Is(err, session.ErrInvalidToken) && !errors.Is(err, session.ErrTokenRevoked) {
+			h.l.Error("authenticate user failed", "err", err)
+		}
+		return nil, false
+	}
+	if claims.ClientID != "" || claims.UserID == 0 || claims.IssuedAt == nil {
+		return nil, false
+	}
+	return claims, true
+}
+
+// redirectWithCode 生成授权码并回调客户端
+func (h *Handler) redirectWithCode(c *gin.Context, pending *pendingConsent) {
+	code, err := common.RandomString(32)
+	if err != nil {
+		h.l.Error("generate authorization code failed", "err", err)
+		redirectErr(c, pending.Redirect, pending.State, errServerError, "")
+		return
+	}
+	data, err := json.Marshal(authorizationCode{
+		ClientID:            pending.ClientID,
+		RedirectURI:         pending.RedirectURI,
+		Scope:               strings.Join(parseScope(pending.Scope), " "),
+		CodeChallenge:       pending.CodeChallenge,
+		CodeChallengeMethod: pending.CodeChallengeMethod,
+		Nonce:               pending.Nonce,
+		UserID:              pending.UserID,
+		Username:            pending.Username,
+		AuthTime:            pending.AuthTime,
+	})
+	if err == nil {
+		err = h.rdb.Set(c, fmt.Sprintf(codeKey, common.HashKey(code)), data, h.cfg.CodeTTL).Err()
+	}
+	if err != nil {
+		h.l.Error("save authorization code failed", "err", err)
+		redirectErr(c, pending.Redirect, pending.State, errServerError, "")
+		return
+	}
+
+	redirectTo(c, pending.Redirect, map[string]string{"code": code, "state": pending.State})
+}
+
+func (h *Handler) findClient(clientID string) (*Client, error) {
+	if clientID == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var client Client
+	if err := h.db.Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// consented 用户是否已同意过客户端申请的全部scope
+func (h *Handler) consented(userID uint, clientID string, scopes []string) (bool, error) {
+	var consent Consent
+	err := h.db.Where("user_id = ? AND client_id = ?", userID, clientID).First(&consent).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return subsetOf(scopes, consent.Scopes), nil
+}
+
+// saveConsent 合并保存用户同意的scope
+func (h *Handler) saveConsent(userID uint, clientID string, scopes []string) error {
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		var consent Consent
+		err := tx.Where("user_id = ? AND client_id = ?", userID, clientID).First(&consent).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		consent.UserID, consent.ClientID = userID, clientID
+		for _, s := range scopes {
+			if !slices.Contains(consent.Scopes, s) {
+				consent.Scopes = append(consent.Scopes, s)
+			}
+		}
+		return tx.Save(&consent).Error
+	})
+}
+
+func (h *Handler) serverErr(c *gin.Context, err error) {
+	h.l.Error("oauth token request failed", "err", err, "requestId", requestid.Get(c))
+	common.TokenErr(c, http.StatusInternalServerError, errServerError, "")
+}
+
+// roleIDs 用户的角色ID，与AuthMiddleware解析的角色一致
+func roleIDs(db *gorm.DB, userID uint) ([]uint, error) {
+	var userRole []user.UserRole
+	if err := db.Where("user_id = ?", userID).Find(&userRole).Error; err != nil {
+		return nil, err
+	}
+	roles := make([]uint, 0, len(userRole))
+	for _, r := range userRole {
+		roles = append(roles, r.RoleID)
+	}
+	return roles, nil
+}
+
+// userRoles 写入令牌的角色，与Java服务约定为字符串
+func userRoles(db *gorm.DB, userID uint) ([]string, error) {
+	ids, err := roleIDs(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	roles := make([]string, 0, len(ids))
+	for _, id := range ids {
+		roles = append(roles, strconv.FormatUint(uint64(id), 10))
+	}
+	return roles, nil
+}
+
+func redirectErr(c *gin.Context, redirect, state, code, desc string) {
+	redirectTo(c, redirect, map[string]string{"error": code, "error_description": desc, "state": state})
+}
+
+// redirectTo 在回调地址上追加参数后跳转，空值参数不追加
+func redirectTo(c *gin.Context, redirect string, params map[string]string) {
+	u, err := url.Parse(redirect)
+	if err != nil {
+		renderError(c, http.StatusBadRequest, "invalid redirect uri")
+		return
+	}
+	q := u.Query()
+	for k, v := range params {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	c.Redirect(http.StatusFound, u.String())
+}
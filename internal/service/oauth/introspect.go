@@ -0,0 +1,171 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-contrib/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/session"
+)
+
+const (
+	TokenTypeAccess  = "access_token"
+	TokenTypeRefresh = "refresh_token"
+)
+
+// IntrospectResp 令牌内省响应，见RFC 7662 2.2
+type IntrospectResp struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	UserID    uint   `json:"user_id,omitempty"`
+	// Roles 用户当前的角色ID，与AuthMiddleware解析的角色一致
+	Roles []uint `json:"roles,omitempty"`
+}
+
+// Introspect 令牌内省端点，资源服务使用客户端凭证查询令牌是否仍然有效
+// 同时支持登录接口和OAuth2签发的令牌
+func (h *Handler) Introspect(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	client, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+	if client.Public {
+		common.TokenErr(c, http.StatusUnauthorized, errInvalidClient, "public client cannot introspect tokens")
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		common.TokenErr(c, http.StatusBadRequest, errInvalidRequest, "token is required")
+		return
+	}
+
+	var resp *IntrospectResp
+	var err error
+	if c.PostForm("token_type_hint") == TokenTypeRefresh {
+		if resp, err = h.introspectRefresh(c, token); err == nil && !resp.Active {
+			resp, err = h.introspectAccess(c, token)
+		}
+	} else {
+		if resp, err = h.introspectAccess(c, token); err == nil && !resp.Active {
+			resp, err = h.introspectRefresh(c, token)
+		}
+	}
+	if err != nil {
+		h.serverErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Revoke 令牌撤销端点，客户端只能撤销签发给自己的令牌
+// 无效或已过期的令牌同样返回200，见RFC 7009 2.2
+func (h *Handler) Revoke(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	client, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		common.TokenErr(c, http.StatusBadRequest, errInvalidRequest, "token is required")
+		return
+	}
+
+	err := h.sessions.RevokeRefresh(c, token, client.ClientID)
+	if errors.Is(err, session.ErrInvalidRefreshToken) {
+		err = h.revokeAccess(c, token, client.ClientID)
+	}
+	if err != nil {
+		h.serverErr(c, err)
+		return
+	}
+
+	h.l.Info("oauth token revoked", "clientId", client.ClientID, "requestId", requestid.Get(c))
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) introspectAccess(c *gin.Context, token string) (*IntrospectResp, error) {
+	claims, err := h.sessions.Authenticate(c, token)
+	if errors.Is(err, session.ErrInvalidToken) || errors.Is(err, session.ErrTokenRevoked) {
+		return &IntrospectResp{Active: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &IntrospectResp{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Username:  claims.Username,
+		TokenType: "Bearer",
+		Sub:       claims.Subject,
+		Iss:       claims.Issuer,
+		Jti:       claims.ID,
+		UserID:    claims.UserID,
+	}
+	if claims.ExpiresAt != nil {
+		resp.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		resp.Iat = claims.IssuedAt.Unix()
+	}
+	if claims.UserID != 0 {
+		if resp.Roles, err = roleIDs(h.db, claims.UserID); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (h *Handler) introspectRefresh(c *gin.Context, token string) (*IntrospectResp, error) {
+	grant, expiresAt, err := h.sessions.Lookup(c, token)
+	if errors.Is(err, session.ErrInvalidRefreshToken) {
+		return &IntrospectResp{Active: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := roleIDs(h.db, grant.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return &IntrospectResp{
+		Active:    true,
+		Scope:     grant.Scope,
+		ClientID:  grant.ClientID,
+		Username:  grant.Username,
+		TokenType: TokenTypeRefresh,
+		Exp:       expiresAt.Unix(),
+		UserID:    grant.UserID,
+		Roles:     roles,
+	}, nil
+}
+
+// revokeAccess 撤销access token，签名无效的令牌直接忽略
+func (h *Handler) revokeAccess(c *gin.Context, token, clientID string) error {
+	claims, err := common.ValidateJavaJWT(token)
+	if err != nil {
+		return nil
+	}
+	if claims.ClientID != clientID {
+		h.l.Warn("client tried to revoke a token issued to another client", "clientId", clientID, "jti", claims.ID)
+		return nil
+	}
+	return h.sessions.RevokeToken(c, claims)
+}
@@ -0,0 +1,145 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"slices"
+	"strings"
+
+	"github.com/z876730060/auth/internal/service/common"
+	"gorm.io/gorm"
+)
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// Client 注册的OAuth2客户端
+type Client struct {
+	gorm.Model
+	ClientID   string `json:"clientId" gorm:"size:64;uniqueIndex"`
+	SecretHash string `json:"-"`
+	Name       string `json:"name"`
+	// RedirectURIs 允许的回调地址，授权请求中的地址需完全匹配
+	RedirectURIs []string `json:"redirectUris" gorm:"serializer:json"`
+	GrantTypes   []string `json:"grantTypes" gorm:"serializer:json"`
+	// Scopes 客户端允许申请的scope
+	Scopes []string `json:"scopes" gorm:"serializer:json"`
+	// Public 公开客户端（SPA、移动端）没有密钥，必须使用PKCE
+	Public bool `json:"public"`
+	// SkipConsent 内部客户端跳过授权确认页
+	SkipConsent bool `json:"skipConsent"`
+}
+
+func (Client) TableName() string {
+	return "oauth_client"
+}
+
+// AllowsGrant 客户端是否允许使用该授权类型
+func (c *Client) AllowsGrant(grantType string) bool {
+	return slices.Contains(c.GrantTypes, grantType)
+}
+
+// VerifySecret 校验客户端密钥，密钥为高熵随机值，只保存sha256
+func (c *Client) VerifySecret(secret string) bool {
+	if c.SecretHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.SecretHash), []byte(common.HashKey(secret))) == 1
+}
+
+// Consent 用户对客户端已同意的scope
+type Consent struct {
+	gorm.Model
+	UserID   uint     `json:"userId" gorm:"uniqueIndex:idx_consent_user_client"`
+	ClientID string   `json:"clientId" gorm:"size:64;uniqueIndex:idx_consent_user_client"`
+	Scopes   []string `json:"scopes" gorm:"serializer:json"`
+}
+
+func (Consent) TableName() string {
+	return "oauth_consent"
+}
+
+// authorizationCode 授权码对应的授权信息，保存在redis中且只能使用一次
+type authorizationCode struct {
+	ClientID string `json:"clientId"`
+	// RedirectURI 授权请求中显式传入的回调地址，换取令牌时必须一致
+	RedirectURI         string `json:"redirectUri"`
+	Scope               string `json:"scope"`
+	CodeChallenge       string `json:"codeChallenge"`
+	CodeChallengeMethod string `json:"codeChallengeMethod"`
+	Nonce               string `json:"nonce"`
+	UserID              uint   `json:"userId"`
+	Username            string `json:"username"`
+	AuthTime            int64  `json:"authTime"`
+}
+
+// authorizeReq 授权请求参数
+type authorizeReq struct {
+	ResponseType        string `form:"response_type" json:"responseType"`
+	ClientID            string `form:"client_id" json:"clientId"`
+	RedirectURI         string `form:"redirect_uri" json:"redirectUri"`
+	Scope               string `form:"scope" json:"scope"`
+	State               string `form:"state" json:"state"`
+	CodeChallenge       string `form:"code_challenge" json:"codeChallenge"`
+	CodeChallengeMethod string `form:"code_challenge_method" json:"codeChallengeMethod"`
+	Nonce               string `form:"nonce" json:"nonce"`
+}
+
+// pendingConsent 等待用户确认的授权请求
+type pendingConsent struct {
+	authorizeReq
+	// Redirect 实际使用的回调地址
+	Redirect string `json:"redirect"`
+	UserID   uint   `json:"userId"`
+	Username string `json:"username"`
+	AuthTime int64  `json:"authTime"`
+}
+
+// TokenResp 令牌端点响应，字段名遵循RFC 6749
+type TokenResp struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+func InitOAuthTable(db *gorm.DB) {
+	db.AutoMigrate(&Client{})
+	db.AutoMigrate(&Consent{})
+}
+
+// parseScope 按空格拆分scope并去重
+func parseScope(scope string) []string {
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// subsetOf requested中的scope是否全部包含在allowed中
+func subsetOf(requested, allowed []string) bool {
+	for _, s := range requested {
+		if !slices.Contains(allowed, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyPKCE 校验code_verifier，只支持S256
+func verifyPKCE(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(base64.RawURLEncoding.EncodeToString(sum[:]))) == 1
+}
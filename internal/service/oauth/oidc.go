@@ -0,0 +1,128 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/session"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+	ScopePhone   = "phone"
+)
+
+// Discovery OpenID Connect发现文档，未配置ID token签名密钥时不提供openid相关的能力
+func (h *Handler) Discovery(c *gin.Context) {
+	issuer := h.cfg.Issuer
+	doc := gin.H{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth2/authorize",
+		"token_endpoint":                        issuer + "/oauth2/token",
+		"introspection_endpoint":                issuer + "/oauth2/introspect",
+		"revocation_endpoint":                   issuer + "/oauth2/revoke",
+		"jwks_uri":                              issuer + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials},
+		"scopes_supported":                      []string{ScopeProfile, ScopeEmail, ScopePhone},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	}
+	if alg := common.GetKeySet().IDTokenAlg(); alg != "" {
+		doc["userinfo_endpoint"] = issuer + "/userinfo"
+		doc["subject_types_supported"] = []string{"public"}
+		doc["id_token_signing_alg_values_supported"] = []string{alg}
+		doc["scopes_supported"] = []string{ScopeOpenID, ScopeProfile, ScopeEmail, ScopePhone}
+		doc["claims_supported"] = []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce",
+			"name", "preferred_username", "updated_at", "email", "phone_number",
+		}
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, doc)
+}
+
+// UserInfo 返回access token对应用户的信息，按令牌的scope过滤
+func (h *Handler) UserInfo(c *gin.Context) {
+	claims, err := h.sessions.Authenticate(c, h.sessions.TokenFromRequest(c))
+	if err != nil {
+		if !errors.Is(err, session.ErrInvalidToken) && !errors.Is(err, session.ErrTokenRevoked) {
+			h.l.Error("authenticate userinfo token failed", "err", err)
+		}
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		common.TokenErr(c, http.StatusUnauthorized, "invalid_token", "")
+		return
+	}
+	scopes := parseScope(claims.Scope)
+	if claims.UserID == 0 || !slices.Contains(scopes, ScopeOpenID) {
+		c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		common.TokenErr(c, http.StatusForbidden, "insufficient_scope", "")
+		return
+	}
+
+	var u user.User
+	if err := h.db.Where(user.User{Model: gorm.Model{ID: claims.UserID}}).First(&u).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			common.TokenErr(c, http.StatusUnauthorized, "invalid_token", "")
+			return
+		}
+		h.serverErr(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, userClaims(&u, scopes))
+}
+
+// idToken 签发ID token，aud为客户端
+func (h *Handler) idToken(u *user.User, clientID string, ac *authorizationCode) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims(userClaims(u, parseScope(ac.Scope)))
+	claims["iss"] = h.cfg.Issuer
+	claims["aud"] = clientID
+	claims["azp"] = clientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(h.cfg.IDTokenTTL).Unix()
+	if ac.AuthTime > 0 {
+		claims["auth_time"] = ac.AuthTime
+	}
+	if ac.Nonce != "" {
+		claims["nonce"] = ac.Nonce
+	}
+	return common.GetKeySet().SignIDToken(claims)
+}
+
+// userClaims 按scope返回用户的标准claims
+func userClaims(u *user.User, scopes []string) map[string]any {
+	claims := map[string]any{
+		"sub": strconv.FormatUint(uint64(u.ID), 10),
+	}
+	if slices.Contains(scopes, ScopeProfile) {
+		name := u.Fullname
+		if name == "" {
+			name = u.Username
+		}
+		claims["name"] = name
+		claims["preferred_username"] = u.Username
+		claims["updated_at"] = u.UpdatedAt.Unix()
+	}
+	if slices.Contains(scopes, ScopeEmail) && u.Email != "" {
+		claims["email"] = u.Email
+	}
+	if slices.Contains(scopes, ScopePhone) && u.Phone != "" {
+		claims["phone_number"] = u.Phone
+	}
+	return claims
+}
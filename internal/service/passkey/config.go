@@ -0,0 +1,30 @@
+package passkey
+
+import "time"
+
+// Config WebAuthn依赖方配置
+type Config struct {
+	// RPID 依赖方ID，一般为站点域名，注册后不能再修改
+	RPID          string `json:"rpid"`
+	RPDisplayName string `json:"rpdisplayname"`
+	// RPOrigins 允许发起认证的页面来源，如 https://auth.example.com
+	RPOrigins []string `json:"rporigins"`
+	// Timeout 注册和认证过程的时限
+	Timeout time.Duration `json:"timeout"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.RPID == "" {
+		c.RPID = "localhost"
+	}
+	if c.RPDisplayName == "" {
+		c.RPDisplayName = c.RPID
+	}
+	if len(c.RPOrigins) == 0 {
+		c.RPOrigins = []string{"http://localhost"}
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Minute
+	}
+	return c
+}
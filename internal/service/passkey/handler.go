@@ -0,0 +1,156 @@
+package passkey
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/common"
+)
+
+// Handler 当前登录用户的WebAuthn凭证管理
+type Handler struct {
+	l       *slog.Logger
+	service *Service
+	info    common.Info
+}
+
+func NewHandler(l *slog.Logger, service *Service, info common.Info) *Handler {
+	return &Handler{l: l, service: service, info: info}
+}
+
+func (h *Handler) Register(e *gin.Engine) {
+	e.GET("/passkey", common.RequireUser(h.info), h.List)
+	e.POST("/passkey/register/begin", common.RequireUser(h.info), h.BeginRegistration)
+	e.POST("/passkey/register/finish", common.RequireUser(h.info), h.FinishRegistration)
+	e.PUT("/passkey/:id", common.RequireUser(h.info), h.Rename)
+	e.DELETE("/passkey/:id", common.RequireUser(h.info), h.Delete)
+	e.POST("/passkey/passwordless", common.RequireUser(h.info), h.RemovePassword)
+}
+
+// CeremonyResp 开始注册或认证时返回给前端的参数，sessionId需要在完成时原样提交
+type CeremonyResp struct {
+	SessionID string `json:"sessionId"`
+	Options   any    `json:"options"`
+}
+
+// FinishReq 完成注册或认证，credential为浏览器返回的PublicKeyCredential
+type FinishReq struct {
+	SessionID  string          `json:"sessionId"`
+	Name       string          `json:"name"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+type renameReq struct {
+	Name string `json:"name"`
+}
+
+// List 获取已绑定的凭证
+func (h *Handler) List(c *gin.Context) {
+	creds, err := h.service.List(c.GetUint("userId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("get passkey list success", creds, h.info))
+}
+
+// BeginRegistration 开始注册凭证
+func (h *Handler) BeginRegistration(c *gin.Context) {
+	creation, sessionID, err := h.service.BeginRegistration(c, c.GetUint("userId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("begin passkey registration success", CeremonyResp{
+		SessionID: sessionID,
+		Options:   creation,
+	}, h.info))
+}
+
+// FinishRegistration 完成注册凭证
+func (h *Handler) FinishRegistration(c *gin.Context) {
+	var req FinishReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.SessionID == "" || len(req.Credential) == 0 {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	uid := c.GetUint("userId")
+	cred, err := h.service.FinishRegistration(c, uid, req.SessionID, req.Name, req.Credential)
+	if err != nil {
+		h.respServiceErr(c, err)
+		return
+	}
+
+	h.l.Info("passkey registered", "userId", uid, "credentialId", cred.CredentialID)
+	c.JSON(http.StatusOK, common.RespOk("register passkey success", cred, h.info))
+}
+
+// Rename 修改凭证名称
+func (h *Handler) Rename(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid id", h.info))
+		return
+	}
+	var req renameReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	if err := h.service.Rename(c.GetUint("userId"), id, req.Name); err != nil {
+		h.respServiceErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("rename passkey success", nil, h.info))
+}
+
+// Delete 删除凭证
+func (h *Handler) Delete(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid id", h.info))
+		return
+	}
+
+	uid := c.GetUint("userId")
+	if err := h.service.Delete(uid, id); err != nil {
+		h.respServiceErr(c, err)
+		return
+	}
+
+	h.l.Info("passkey deleted", "userId", uid, "id", id)
+	c.JSON(http.StatusOK, common.RespOk("delete passkey success", nil, h.info))
+}
+
+// RemovePassword 移除密码，改为仅使用凭证登录
+func (h *Handler) RemovePassword(c *gin.Context) {
+	uid := c.GetUint("userId")
+	if err := h.service.RemovePassword(uid); err != nil {
+		h.respServiceErr(c, err)
+		return
+	}
+
+	h.l.Info("password removed, account is passwordless", "userId", uid)
+	c.JSON(http.StatusOK, common.RespOk("remove password success", nil, h.info))
+}
+
+func (h *Handler) respServiceErr(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidSession), errors.Is(err, ErrVerifyFailed), errors.Is(err, ErrNotEnrolled):
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, common.RespErr(err.Error(), h.info))
+	case errors.Is(err, ErrLastCredential), errors.Is(err, ErrPasswordRemoved):
+		c.JSON(http.StatusConflict, common.RespErr(err.Error(), h.info))
+	default:
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+	}
+}
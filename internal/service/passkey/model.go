@@ -0,0 +1,137 @@
+package passkey
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+// Credential 用户绑定的WebAuthn凭证，一个用户可以绑定多个
+type Credential struct {
+	gorm.Model
+	UserID uint `json:"userId" gorm:"index"`
+	// CredentialID 凭证ID的base64url编码
+	CredentialID    string `json:"credentialId" gorm:"size:255;uniqueIndex"`
+	Name            string `json:"name"`
+	PublicKey       []byte `json:"-"`
+	AttestationType string `json:"attestationType"`
+	AAGUID          []byte `json:"-"`
+	SignCount       uint32 `json:"signCount"`
+	// Transports 逗号分隔的传输方式，如 usb,nfc,internal
+	Transports     string     `json:"transports"`
+	UserVerified   bool       `json:"userVerified"`
+	BackupEligible bool       `json:"backupEligible"`
+	BackupState    bool       `json:"backupState"`
+	LastUsedAt     *time.Time `json:"lastUsedAt"`
+}
+
+func (Credential) TableName() string {
+	return "user_webauthn_credential"
+}
+
+// webAuthnCredential 转换为webauthn库使用的凭证
+func (c *Credential) webAuthnCredential() webauthn.Credential {
+	id, _ := decodeID(c.CredentialID)
+	var transports []protocol.AuthenticatorTransport
+	if c.Transports != "" {
+		for _, t := range strings.Split(c.Transports, ",") {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+	}
+	return webauthn.Credential{
+		ID:              id,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			UserPresent:    true,
+			UserVerified:   c.UserVerified,
+			BackupEligible: c.BackupEligible,
+			BackupState:    c.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    c.AAGUID,
+			SignCount: c.SignCount,
+		},
+	}
+}
+
+// newCredential 保存注册完成的凭证
+func newCredential(userID uint, name string, cred *webauthn.Credential) *Credential {
+	transports := make([]string, 0, len(cred.Transport))
+	for _, t := range cred.Transport {
+		transports = append(transports, string(t))
+	}
+	return &Credential{
+		UserID:          userID,
+		CredentialID:    encodeID(cred.ID),
+		Name:            name,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		Transports:      strings.Join(transports, ","),
+		UserVerified:    cred.Flags.UserVerified,
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+	}
+}
+
+func encodeID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+func decodeID(id string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(id)
+}
+
+// webAuthnUser 适配webauthn库的用户接口
+type webAuthnUser struct {
+	user        *user.User
+	credentials []Credential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return userHandle(u.user.ID)
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	if u.user.Fullname != "" {
+		return u.user.Fullname
+	}
+	return u.user.Username
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, 0, len(u.credentials))
+	for i := range u.credentials {
+		creds = append(creds, u.credentials[i].webAuthnCredential())
+	}
+	return creds
+}
+
+// userHandle 用户ID作为WebAuthn的user handle
+func userHandle(userID uint) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
+
+func parseUserHandle(handle []byte) (uint, bool) {
+	if len(handle) != 8 {
+		return 0, false
+	}
+	return uint(binary.BigEndian.Uint64(handle)), true
+}
+
+func InitPasskeyTable(db *gorm.DB) {
+	db.AutoMigrate(&Credential{})
+}
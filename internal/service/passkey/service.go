@@ -0,0 +1,364 @@
+package passkey
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+const ceremonyKey = "webauthn:session:%s"
+
+const (
+	ceremonyRegister     = "register"
+	ceremonyLogin        = "login"
+	ceremonyDiscoverable = "discoverable"
+)
+
+var (
+	ErrNotEnrolled     = errors.New("passkey not enrolled")
+	ErrNotFound        = errors.New("passkey not found")
+	ErrInvalidSession  = errors.New("passkey session expired or invalid")
+	ErrVerifyFailed    = errors.New("passkey verification failed")
+	ErrLastCredential  = errors.New("cannot remove the last passkey of a passwordless account")
+	ErrPasswordRemoved = errors.New("password already removed")
+)
+
+// ceremony 注册或认证过程中保存在redis的会话数据，只能使用一次
+type ceremony struct {
+	Kind   string               `json:"kind"`
+	UserID uint                 `json:"userId"`
+	Data   webauthn.SessionData `json:"data"`
+}
+
+// Service WebAuthn凭证注册、认证及管理
+type Service struct {
+	db       *gorm.DB
+	rdb      *redis.Client
+	webauthn *webauthn.WebAuthn
+	cfg      Config
+}
+
+func NewService(db *gorm.DB, rdb *redis.Client, cfg Config) (*Service, error) {
+	cfg = cfg.withDefaults()
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: cfg.Timeout, TimeoutUVD: cfg.Timeout}
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPDisplayName,
+		RPOrigins:     cfg.RPOrigins,
+		Timeouts:      webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Service{db: db, rdb: rdb, webauthn: w, cfg: cfg}, nil
+}
+
+// Enabled 用户是否绑定了至少一个凭证
+func (s *Service) Enabled(userID uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&Credential{}).Where("user_id = ?", userID).Count(&count).Error
+	return count > 0, err
+}
+
+// List 用户绑定的凭证列表
+func (s *Service) List(userID uint) ([]Credential, error) {
+	var creds []Credential
+	err := s.db.Where("user_id = ?", userID).Order("id").Find(&creds).Error
+	return creds, err
+}
+
+// BeginRegistration 开始注册凭证，已绑定的凭证不能重复注册
+func (s *Service) BeginRegistration(ctx context.Context, userID uint) (*protocol.CredentialCreation, string, error) {
+	u, err := s.loadUser(userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	creation, data, err := s.webauthn.BeginRegistration(u,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(webauthn.Credentials(u.WebAuthnCredentials()).CredentialDescriptors()),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sessionID, err := s.saveCeremony(ctx, &ceremony{Kind: ceremonyRegister, UserID: userID, Data: *data})
+	if err != nil {
+		return nil, "", err
+	}
+	return creation, sessionID, nil
+}
+
+// FinishRegistration 校验认证器返回的注册数据并保存凭证
+func (s *Service) FinishRegistration(ctx context.Context, userID uint, sessionID, name string, body []byte) (*Credential, error) {
+	cer, err := s.takeCeremony(ctx, sessionID, ceremonyRegister)
+	if err != nil {
+		return nil, err
+	}
+	if cer.UserID != userID {
+		return nil, ErrInvalidSession
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(body))
+	if err != nil {
+		return nil, verifyErr(err)
+	}
+	u, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	cred, err := s.webauthn.CreateCredential(u, cer.Data, parsed)
+	if err != nil {
+		return nil, verifyErr(err)
+	}
+
+	if name == "" {
+		name = "passkey " + time.Now().Format(time.DateOnly)
+	}
+	record := newCredential(userID, name, cred)
+	if err := s.db.Create(record).Error; err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// BeginLogin 已知用户的认证，用于第二步验证
+func (s *Service) BeginLogin(ctx context.Context, userID uint) (*protocol.CredentialAssertion, string, error) {
+	u, err := s.loadUser(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(u.credentials) == 0 {
+		return nil, "", ErrNotEnrolled
+	}
+
+	assertion, data, err := s.webauthn.BeginLogin(u)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sessionID, err := s.saveCeremony(ctx, &ceremony{Kind: ceremonyLogin, UserID: userID, Data: *data})
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, sessionID, nil
+}
+
+// FinishLogin 校验已知用户的认证结果
+func (s *Service) FinishLogin(ctx context.Context, userID uint, sessionID string, body []byte) error {
+	cer, err := s.takeCeremony(ctx, sessionID, ceremonyLogin)
+	if err != nil {
+		return err
+	}
+	if cer.UserID != userID {
+		return ErrInvalidSession
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(body))
+	if err != nil {
+		return verifyErr(err)
+	}
+	u, err := s.loadUser(userID)
+	if err != nil {
+		return err
+	}
+	cred, err := s.webauthn.ValidateLogin(u, cer.Data, parsed)
+	if err != nil {
+		return verifyErr(err)
+	}
+	return s.updateUsage(cred)
+}
+
+// BeginDiscoverableLogin 无需用户名的免密登录，由认证器选择凭证并要求用户验证
+func (s *Service) BeginDiscoverableLogin(ctx context.Context) (*protocol.CredentialAssertion, string, error) {
+	assertion, data, err := s.webauthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sessionID, err := s.saveCeremony(ctx, &ceremony{Kind: ceremonyDiscoverable, Data: *data})
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, sessionID, nil
+}
+
+// FinishDiscoverableLogin 校验免密登录结果，返回凭证所属用户
+func (s *Service) FinishDiscoverableLogin(ctx context.Context, sessionID string, body []byte) (*user.User, error) {
+	cer, err := s.takeCeremony(ctx, sessionID, ceremonyDiscoverable)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(body))
+	if err != nil {
+		return nil, verifyErr(err)
+	}
+
+	handler := func(rawID, handle []byte) (webauthn.User, error) {
+		userID, ok := parseUserHandle(handle)
+		if !ok {
+			return nil, ErrNotFound
+		}
+		return s.loadUser(userID)
+	}
+	found, cred, err := s.webauthn.ValidatePasskeyLogin(handler, cer.Data, parsed)
+	if err != nil {
+		return nil, verifyErr(err)
+	}
+	if err := s.updateUsage(cred); err != nil {
+		return nil, err
+	}
+	return found.(*webAuthnUser).user, nil
+}
+
+// Rename 修改凭证名称
+func (s *Service) Rename(userID, id uint, name string) error {
+	result := s.db.Model(&Credential{}).Where("id = ? AND user_id = ?", id, userID).Update("name", name)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete 删除凭证，已移除密码的账号不能删除最后一个凭证
+func (s *Service) Delete(userID, id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var cred Credential
+		if err := tx.Where("id = ? AND user_id = ?", id, userID).First(&cred).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		var u user.User
+		if err := tx.Where(user.User{Model: gorm.Model{ID: userID}}).First(&u).Error; err != nil {
+			return err
+		}
+		if u.Password == "" {
+			var count int64
+			if err := tx.Model(&Credential{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count <= 1 {
+				return ErrLastCredential
+			}
+		}
+
+		return tx.Unscoped().Delete(&cred).Error
+	})
+}
+
+// RemovePassword 移除账号密码，之后只能使用凭证登录
+func (s *Service) RemovePassword(userID uint) error {
+	enabled, err := s.Enabled(userID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrNotEnrolled
+	}
+
+	result := s.db.Model(&user.User{}).Where("id = ? AND password <> ?", userID, "").Update("password", "")
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPasswordRemoved
+	}
+	return nil
+}
+
+// loadUser 加载用户及其凭证
+func (s *Service) loadUser(userID uint) (*webAuthnUser, error) {
+	var u user.User
+	if err := s.db.Where(user.User{Model: gorm.Model{ID: userID}}).First(&u).Error; err != nil {
+		return nil, err
+	}
+	creds, err := s.List(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &webAuthnUser{user: &u, credentials: creds}, nil
+}
+
+// updateUsage 认证成功后更新签名计数和备份状态，计数回退说明凭证可能被复制
+func (s *Service) updateUsage(cred *webauthn.Credential) error {
+	if cred.Authenticator.CloneWarning {
+		return fmt.Errorf("%w: signature counter regressed", ErrVerifyFailed)
+	}
+	return s.db.Model(&Credential{}).
+		Where("credential_id = ?", encodeID(cred.ID)).
+		Updates(map[string]any{
+			"sign_count":    cred.Authenticator.SignCount,
+			"user_verified": cred.Flags.UserVerified,
+			"backup_state":  cred.Flags.BackupState,
+			"last_used_at":  time.Now(),
+		}).Error
+}
+
+func (s *Service) saveCeremony(ctx context.Context, cer *ceremony) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	sessionID := hex.EncodeToString(b)
+
+	data, err := json.Marshal(cer)
+	if err != nil {
+		return "", err
+	}
+	if err := s.rdb.Set(ctx, fmt.Sprintf(ceremonyKey, sessionID), data, s.cfg.Timeout).Err(); err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+// takeCeremony 取出并删除会话数据，防止同一挑战被重复使用
+func (s *Service) takeCeremony(ctx context.Context, sessionID, kind string) (*ceremony, error) {
+	if sessionID == "" {
+		return nil, ErrInvalidSession
+	}
+	data, err := s.rdb.GetDel(ctx, fmt.Sprintf(ceremonyKey, sessionID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cer ceremony
+	if err := json.Unmarshal(data, &cer); err != nil {
+		return nil, err
+	}
+	if cer.Kind != kind {
+		return nil, ErrInvalidSession
+	}
+	return &cer, nil
+}
+
+// verifyErr 将webauthn库的校验错误统一为ErrVerifyFailed
+func verifyErr(err error) error {
+	var perr *protocol.Error
+	if errors.As(err, &perr) && perr.Details != "" {
+		return fmt.Errorf("%w: %s", ErrVerifyFailed, perr.Details)
+	}
+	return fmt.Errorf("%w: %v", ErrVerifyFailed, err)
+}
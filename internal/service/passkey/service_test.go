@@ -0,0 +1,317 @@
+package passkey
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost"
+)
+
+// 认证器数据标志位
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// softAuthenticator 软件实现的ES256认证器，生成注册和认证时浏览器返回的PublicKeyCredential
+type softAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	handle    []byte
+	signCount uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, id: id}
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, typ string, challenge []byte) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{
+		"type":      typ,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// authData rpIdHash | flags | signCount [| attestedCredentialData]
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+// register 响应注册挑战，使用none格式的证明
+func (a *softAuthenticator) register(t *testing.T, creation *protocol.CredentialCreation) []byte {
+	t.Helper()
+	a.handle = creation.Response.User.ID.(protocol.URLEncodedBase64)
+
+	coseKey, err := webauthncbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16) // aaguid
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.id)))
+	attested = append(attested, a.id...)
+	attested = append(attested, coseKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(flagUserPresent|flagUserVerified|flagAttested, attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a.credential(t, map[string]any{
+		"clientDataJSON":    a.clientData(t, "webauthn.create", creation.Response.Challenge),
+		"attestationObject": attestation,
+	})
+}
+
+// assert 响应认证挑战，签名计数每次加一
+func (a *softAuthenticator) assert(t *testing.T, assertion *protocol.CredentialAssertion) []byte {
+	t.Helper()
+	a.signCount++
+	clientData := a.clientData(t, "webauthn.get", assertion.Response.Challenge)
+	authData := a.authData(flagUserPresent|flagUserVerified, nil)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a.credential(t, map[string]any{
+		"clientDataJSON":    clientData,
+		"authenticatorData": authData,
+		"signature":         signature,
+		"userHandle":        a.handle,
+	})
+}
+
+// credential 浏览器提交的PublicKeyCredential，二进制字段使用base64url编码
+func (a *softAuthenticator) credential(t *testing.T, response map[string]any) []byte {
+	t.Helper()
+	encoded := make(map[string]string, len(response))
+	for k, v := range response {
+		encoded[k] = base64.RawURLEncoding.EncodeToString(v.([]byte))
+	}
+	body, err := json.Marshal(map[string]any{
+		"id":       base64.RawURLEncoding.EncodeToString(a.id),
+		"rawId":    base64.RawURLEncoding.EncodeToString(a.id),
+		"type":     "public-key",
+		"response": encoded,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func newTestService(t *testing.T) (*Service, *user.User) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &Credential{}); err != nil {
+		t.Fatal(err)
+	}
+	u := &user.User{Username: "alice", Fullname: "Alice", Password: "hashed"}
+	if err := db.Create(u).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	s, err := NewService(db, rdb, Config{RPID: testRPID, RPOrigins: []string{testOrigin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, u
+}
+
+// registerPasskey 完成一次注册流程
+func registerPasskey(t *testing.T, s *Service, userID uint, a *softAuthenticator) *Credential {
+	t.Helper()
+	ctx := context.Background()
+	creation, sessionID, err := s.BeginRegistration(ctx, userID)
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	cred, err := s.FinishRegistration(ctx, userID, sessionID, "laptop", a.register(t, creation))
+	if err != nil {
+		t.Fatalf("finish registration: %v", err)
+	}
+	return cred
+}
+
+func TestRegistration(t *testing.T) {
+	s, u := newTestService(t)
+	a := newSoftAuthenticator(t)
+
+	cred := registerPasskey(t, s, u.ID, a)
+	if cred.CredentialID != encodeID(a.id) || cred.Name != "laptop" || !cred.UserVerified {
+		t.Fatalf("unexpected credential: %+v", cred)
+	}
+	enabled, err := s.Enabled(u.ID)
+	if err != nil || !enabled {
+		t.Fatalf("Enabled() = %v, %v, want true", enabled, err)
+	}
+}
+
+func TestRegistrationRejectsWrongChallenge(t *testing.T) {
+	s, u := newTestService(t)
+	a := newSoftAuthenticator(t)
+	ctx := context.Background()
+
+	creation, sessionID, err := s.BeginRegistration(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creation.Response.Challenge = []byte("not the issued challenge")
+	_, err = s.FinishRegistration(ctx, u.ID, sessionID, "", a.register(t, creation))
+	if !errors.Is(err, ErrVerifyFailed) {
+		t.Fatalf("FinishRegistration() error = %v, want ErrVerifyFailed", err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s, u := newTestService(t)
+	a := newSoftAuthenticator(t)
+	registerPasskey(t, s, u.ID, a)
+	ctx := context.Background()
+
+	assertion, sessionID, err := s.BeginLogin(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("begin login: %v", err)
+	}
+	if err := s.FinishLogin(ctx, u.ID, sessionID, a.assert(t, assertion)); err != nil {
+		t.Fatalf("finish login: %v", err)
+	}
+
+	creds, err := s.List(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creds) != 1 || creds[0].SignCount != a.signCount || creds[0].LastUsedAt == nil {
+		t.Fatalf("usage not recorded: %+v", creds)
+	}
+
+	// 会话只能使用一次
+	if err := s.FinishLogin(ctx, u.ID, sessionID, a.assert(t, assertion)); !errors.Is(err, ErrInvalidSession) {
+		t.Fatalf("reused session error = %v, want ErrInvalidSession", err)
+	}
+}
+
+func TestLoginRejectsForeignKey(t *testing.T) {
+	s, u := newTestService(t)
+	a := newSoftAuthenticator(t)
+	registerPasskey(t, s, u.ID, a)
+	ctx := context.Background()
+
+	// 相同凭证ID但使用其他私钥签名
+	forged := newSoftAuthenticator(t)
+	forged.id, forged.handle = a.id, a.handle
+
+	assertion, sessionID, err := s.BeginLogin(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FinishLogin(ctx, u.ID, sessionID, forged.assert(t, assertion)); !errors.Is(err, ErrVerifyFailed) {
+		t.Fatalf("FinishLogin() error = %v, want ErrVerifyFailed", err)
+	}
+}
+
+func TestLoginRejectsClonedAuthenticator(t *testing.T) {
+	s, u := newTestService(t)
+	a := newSoftAuthenticator(t)
+	registerPasskey(t, s, u.ID, a)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		assertion, sessionID, err := s.BeginLogin(ctx, u.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			// 复制出的认证器签名计数落后于已记录的值
+			a.signCount -= 2
+		}
+		err = s.FinishLogin(ctx, u.ID, sessionID, a.assert(t, assertion))
+		if i == 0 && err != nil {
+			t.Fatalf("first login: %v", err)
+		}
+		if i == 1 && !errors.Is(err, ErrVerifyFailed) {
+			t.Fatalf("cloned login error = %v, want ErrVerifyFailed", err)
+		}
+	}
+}
+
+func TestDiscoverableLogin(t *testing.T) {
+	s, u := newTestService(t)
+	a := newSoftAuthenticator(t)
+	registerPasskey(t, s, u.ID, a)
+	ctx := context.Background()
+
+	assertion, sessionID, err := s.BeginDiscoverableLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := s.FinishDiscoverableLogin(ctx, sessionID, a.assert(t, assertion))
+	if err != nil {
+		t.Fatalf("finish discoverable login: %v", err)
+	}
+	if found.ID != u.ID {
+		t.Fatalf("found user %d, want %d", found.ID, u.ID)
+	}
+}
+
+func TestLoginNotEnrolled(t *testing.T) {
+	s, u := newTestService(t)
+	if _, _, err := s.BeginLogin(context.Background(), u.ID); !errors.Is(err, ErrNotEnrolled) {
+		t.Fatalf("BeginLogin() error = %v, want ErrNotEnrolled", err)
+	}
+}
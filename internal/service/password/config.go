@@ -0,0 +1,101 @@
+package password
+
+import "time"
+
+// Config 密码哈希配置
+type Config struct {
+	Algorithm string         `json:"algorithm"`
+	Bcrypt    BcryptConfig   `json:"bcrypt"`
+	Argon2id  Argon2idConfig `json:"argon2id"`
+	Scrypt    ScryptConfig   `json:"scrypt"`
+	Policy    PolicyConfig   `json:"policy"`
+}
+
+// BcryptConfig bcrypt参数
+type BcryptConfig struct {
+	Cost int `json:"cost"`
+}
+
+// Argon2idConfig argon2id参数
+type Argon2idConfig struct {
+	Memory      uint32 `json:"memory"`
+	Iterations  uint32 `json:"iterations"`
+	Parallelism uint8  `json:"parallelism"`
+	SaltLength  uint32 `json:"saltlength"`
+	KeyLength   uint32 `json:"keylength"`
+}
+
+// ScryptConfig scrypt参数，LogN为N的以2为底的对数
+type ScryptConfig struct {
+	LogN       uint8 `json:"logn"`
+	R          int   `json:"r"`
+	P          int   `json:"p"`
+	SaltLength int   `json:"saltlength"`
+	KeyLength  int   `json:"keylength"`
+}
+
+// PolicyConfig 密码策略，只约束新设置的密码，已有密码不受影响
+type PolicyConfig struct {
+	MinLength int `json:"minlength"`
+	// MaxLength 限制过长的输入，bcrypt只使用前72字节
+	MaxLength     int  `json:"maxlength"`
+	RequireUpper  bool `json:"requireupper"`
+	RequireLower  bool `json:"requirelower"`
+	RequireDigit  bool `json:"requiredigit"`
+	RequireSymbol bool `json:"requiresymbol"`
+	// ForbiddenWords 禁止使用的弱密码，忽略大小写及首尾的数字和符号，用户名始终禁止出现在密码中
+	ForbiddenWords []string `json:"forbiddenwords"`
+	// DictionaryFile 弱密码字典文件，每行一个，与ForbiddenWords合并
+	DictionaryFile string `json:"dictionaryfile"`
+	// HistorySize 不能与最近N次使用过的密码相同，0为不限制
+	HistorySize int `json:"historysize"`
+	// MaxAge 密码最长使用时间，过期后登录时必须修改，0为不限制
+	MaxAge time.Duration `json:"maxage"`
+}
+
+// withDefaults 补全未配置的参数
+func (c Config) withDefaults() Config {
+	if c.Algorithm == "" {
+		c.Algorithm = AlgorithmBcrypt
+	}
+	if c.Bcrypt.Cost == 0 {
+		c.Bcrypt.Cost = 12
+	}
+	if c.Argon2id.Memory == 0 {
+		c.Argon2id.Memory = 64 * 1024
+	}
+	if c.Argon2id.Iterations == 0 {
+		c.Argon2id.Iterations = 3
+	}
+	if c.Argon2id.Parallelism == 0 {
+		c.Argon2id.Parallelism = 2
+	}
+	if c.Argon2id.SaltLength == 0 {
+		c.Argon2id.SaltLength = 16
+	}
+	if c.Argon2id.KeyLength == 0 {
+		c.Argon2id.KeyLength = 32
+	}
+	if c.Scrypt.LogN == 0 {
+		c.Scrypt.LogN = 15
+	}
+	if c.Scrypt.R == 0 {
+		c.Scrypt.R = 8
+	}
+	if c.Scrypt.P == 0 {
+		c.Scrypt.P = 1
+	}
+	if c.Scrypt.SaltLength == 0 {
+		c.Scrypt.SaltLength = 16
+	}
+	if c.Scrypt.KeyLength == 0 {
+		c.Scrypt.KeyLength = 32
+	}
+	if c.Policy.MinLength == 0 {
+		c.Policy.MinLength = 8
+	}
+	if c.Policy.MaxLength == 0 {
+		c.Policy.MaxLength = 128
+	}
+	return c
+}
@@ -0,0 +1,140 @@
+package password
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmScrypt   = "scrypt"
+)
+
+// ErrMalformedHash 已编码的哈希格式错误
+var ErrMalformedHash = errors.New("malformed password hash")
+
+// Hasher 密码哈希算法，编码结果需包含算法标识和参数
+type Hasher interface {
+	// Hash 生成编码后的哈希
+	Hash(plain string) (string, error)
+	// Verify 校验明文是否与编码后的哈希匹配
+	Verify(encoded, plain string) (bool, error)
+	// NeedsRehash 编码中的参数与当前配置不一致时返回true
+	NeedsRehash(encoded string) bool
+	// Match 判断编码是否由该算法生成
+	Match(encoded string) bool
+}
+
+// Manager 使用配置的算法生成哈希，并根据编码识别算法进行校验
+type Manager struct {
+	current string
+	hashers map[string]Hasher
+}
+
+// NewManager 根据配置创建Manager
+func NewManager(cfg Config) (*Manager, error) {
+	cfg = cfg.withDefaults()
+	m := &Manager{
+		current: cfg.Algorithm,
+		hashers: map[string]Hasher{
+			AlgorithmBcrypt:   newBcryptHasher(cfg.Bcrypt),
+			AlgorithmArgon2id: newArgon2idHasher(cfg.Argon2id),
+			AlgorithmScrypt:   newScryptHasher(cfg.Scrypt),
+		},
+	}
+	if _, ok := m.hashers[m.current]; !ok {
+		return nil, fmt.Errorf("password algorithm not support: %s", m.current)
+	}
+	return m, nil
+}
+
+// Hash 使用当前算法生成哈希
+func (m *Manager) Hash(plain string) (string, error) {
+	return m.hashers[m.current].Hash(plain)
+}
+
+// Verify 校验密码，rehash为true表示应使用当前算法重新生成哈希
+// 未识别的编码按历史明文密码处理
+func (m *Manager) Verify(encoded, plain string) (ok bool, rehash bool, err error) {
+	h := m.lookup(encoded)
+	if h == nil {
+		ok = subtle.ConstantTimeCompare([]byte(encoded), []byte(plain)) == 1
+		return ok, ok, nil
+	}
+
+	ok, err = h.Verify(encoded, plain)
+	if err != nil || !ok {
+		return false, false, err
+	}
+	return true, h != m.hashers[m.current] || h.NeedsRehash(encoded), nil
+}
+
+// IsHashed 判断是否为已识别的哈希编码
+func (m *Manager) IsHashed(encoded string) bool {
+	return m.lookup(encoded) != nil
+}
+
+func (m *Manager) lookup(encoded string) Hasher {
+	if !strings.HasPrefix(encoded, "$") {
+		return nil
+	}
+	for _, h := range m.hashers {
+		if h.Match(encoded) {
+			return h
+		}
+	}
+	return nil
+}
+
+var (
+	defaultManager, _ = NewManager(Config{})
+	defaultPolicy, _  = NewPolicy(Config{}.withDefaults().Policy)
+)
+
+// Configure 设置默认Manager和密码策略
+func Configure(cfg Config) error {
+	m, err := NewManager(cfg)
+	if err != nil {
+		return err
+	}
+	p, err := NewPolicy(cfg.withDefaults().Policy)
+	if err != nil {
+		return err
+	}
+	defaultManager, defaultPolicy = m, p
+	return nil
+}
+
+// Hash 使用默认Manager生成哈希
+func Hash(plain string) (string, error) {
+	return defaultManager.Hash(plain)
+}
+
+// Verify 使用默认Manager校验密码
+func Verify(encoded, plain string) (ok bool, rehash bool, err error) {
+	return defaultManager.Verify(encoded, plain)
+}
+
+// IsHashed 使用默认Manager判断是否为哈希编码
+func IsHashed(encoded string) bool {
+	return defaultManager.IsHashed(encoded)
+}
+
+// Validate 使用默认密码策略校验新密码
+func Validate(plain, username string) error {
+	return defaultPolicy.Validate(plain, username)
+}
+
+// Expired 使用默认密码策略判断密码是否过期
+func Expired(changedAt time.Time) bool {
+	return defaultPolicy.Expired(changedAt)
+}
+
+// HistorySize 默认密码策略中不能重复使用的历史密码数量
+func HistorySize() int {
+	return defaultPolicy.HistorySize()
+}
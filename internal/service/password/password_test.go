@@ -0,0 +1,157 @@
+package password
+
+import (
+	"errors"
+	"testing"
+)
+
+// testConfig 使用最低成本的参数，缩短测试时间
+func testConfig(algorithm string) Config {
+	return Config{
+		Algorithm: algorithm,
+		Bcrypt:    BcryptConfig{Cost: 4},
+		Argon2id:  Argon2idConfig{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		Scrypt:    ScryptConfig{LogN: 4, R: 8, P: 1, SaltLength: 16, KeyLength: 32},
+	}
+}
+
+func newTestManager(t *testing.T, cfg Config) *Manager {
+	t.Helper()
+	m, err := NewManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestHashVerify(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmBcrypt, AlgorithmArgon2id, AlgorithmScrypt} {
+		t.Run(algorithm, func(t *testing.T) {
+			m := newTestManager(t, testConfig(algorithm))
+			encoded, err := m.Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !m.IsHashed(encoded) {
+				t.Fatalf("IsHashed(%q) = false", encoded)
+			}
+
+			tests := []struct {
+				name  string
+				plain string
+				ok    bool
+			}{
+				{"correct", "correct horse", true},
+				{"wrong", "battery staple", false},
+				{"empty", "", false},
+				{"prefix", "correct", false},
+			}
+			for _, tt := range tests {
+				ok, rehash, err := m.Verify(encoded, tt.plain)
+				if err != nil {
+					t.Fatalf("%s: Verify error: %v", tt.name, err)
+				}
+				if ok != tt.ok || rehash {
+					t.Fatalf("%s: Verify = (%v, %v), want (%v, false)", tt.name, ok, rehash, tt.ok)
+				}
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	changed := func(algorithm string, fn func(*Config)) Config {
+		cfg := testConfig(algorithm)
+		fn(&cfg)
+		return cfg
+	}
+	tests := []struct {
+		name string
+		from Config
+		to   Config
+	}{
+		{"bcrypt cost", testConfig(AlgorithmBcrypt), changed(AlgorithmBcrypt, func(c *Config) { c.Bcrypt.Cost = 5 })},
+		{"argon2id memory", testConfig(AlgorithmArgon2id), changed(AlgorithmArgon2id, func(c *Config) { c.Argon2id.Memory = 128 })},
+		{"argon2id iterations", testConfig(AlgorithmArgon2id), changed(AlgorithmArgon2id, func(c *Config) { c.Argon2id.Iterations = 2 })},
+		{"argon2id key length", testConfig(AlgorithmArgon2id), changed(AlgorithmArgon2id, func(c *Config) { c.Argon2id.KeyLength = 16 })},
+		{"scrypt logn", testConfig(AlgorithmScrypt), changed(AlgorithmScrypt, func(c *Config) { c.Scrypt.LogN = 5 })},
+		{"scrypt salt length", testConfig(AlgorithmScrypt), changed(AlgorithmScrypt, func(c *Config) { c.Scrypt.SaltLength = 8 })},
+		{"bcrypt to argon2id", testConfig(AlgorithmBcrypt), testConfig(AlgorithmArgon2id)},
+		{"scrypt to bcrypt", testConfig(AlgorithmScrypt), testConfig(AlgorithmBcrypt)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := newTestManager(t, tt.from).Hash("secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, rehash, err := newTestManager(t, tt.to).Verify(encoded, "secret")
+			if err != nil || !ok || !rehash {
+				t.Fatalf("Verify = (%v, %v, %v), want (true, true, nil)", ok, rehash, err)
+			}
+		})
+	}
+}
+
+func TestVerifyLegacyPlaintext(t *testing.T) {
+	m := newTestManager(t, testConfig(AlgorithmBcrypt))
+	if m.IsHashed("123456") {
+		t.Fatal("plaintext recognized as hash")
+	}
+
+	ok, rehash, err := m.Verify("123456", "123456")
+	if err != nil || !ok || !rehash {
+		t.Fatalf("Verify = (%v, %v, %v), want (true, true, nil)", ok, rehash, err)
+	}
+	ok, rehash, err = m.Verify("123456", "654321")
+	if err != nil || ok || rehash {
+		t.Fatalf("Verify = (%v, %v, %v), want (false, false, nil)", ok, rehash, err)
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	m := newTestManager(t, testConfig(AlgorithmBcrypt))
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"bcrypt truncated", "$2a$04$short"},
+		{"bcrypt bad cost", "$2b$xx$abcdefghijklmnopqrstuuabcdefghijklmnopqrstuvwxyz01234"},
+		{"argon2id missing parts", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA"},
+		{"argon2id bad version", "$argon2id$v=1$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+		{"argon2id bad params", "$argon2id$v=19$m=x,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+		{"argon2id zero iterations", "$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+		{"argon2id zero parallelism", "$argon2id$v=19$m=64,t=1,p=0$c2FsdHNhbHQ$a2V5a2V5"},
+		{"argon2id bad salt", "$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5a2V5"},
+		{"argon2id empty key", "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$"},
+		{"scrypt missing parts", "$scrypt$ln=4,r=8,p=1$c2FsdA"},
+		{"scrypt bad params", "$scrypt$ln=4,r=x,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+		{"scrypt zero logn", "$scrypt$ln=0,r=8,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+		{"scrypt zero r", "$scrypt$ln=4,r=0,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+		{"scrypt zero p", "$scrypt$ln=4,r=8,p=0$c2FsdHNhbHQ$a2V5a2V5"},
+		{"scrypt bad key", "$scrypt$ln=4,r=8,p=1$c2FsdHNhbHQ$!!!"},
+		{"scrypt empty key", "$scrypt$ln=4,r=8,p=1$c2FsdHNhbHQ$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, plain := range []string{"", "secret"} {
+				ok, rehash, err := m.Verify(tt.encoded, plain)
+				if err == nil || ok || rehash {
+					t.Fatalf("Verify(%q) = (%v, %v, %v), want error", plain, ok, rehash, err)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyMalformedIsErrMalformedHash(t *testing.T) {
+	m := newTestManager(t, testConfig(AlgorithmArgon2id))
+	for _, encoded := range []string{
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$scrypt$ln=4,r=8,p=1$c2FsdHNhbHQ$",
+	} {
+		if _, _, err := m.Verify(encoded, "secret"); !errors.Is(err, ErrMalformedHash) {
+			t.Fatalf("Verify(%q) error = %v, want ErrMalformedHash", encoded, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package password
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrWeakPassword 新密码不满足密码策略
+var ErrWeakPassword = errors.New("password does not meet policy")
+
+// Policy 密码策略校验
+type Policy struct {
+	cfg        PolicyConfig
+	dictionary map[string]struct{}
+}
+
+// NewPolicy 根据配置创建密码策略，配置了字典文件时读取字典
+func NewPolicy(cfg PolicyConfig) (*Policy, error) {
+	p := &Policy{cfg: cfg, dictionary: make(map[string]struct{})}
+	for _, w := range cfg.ForbiddenWords {
+		p.addWord(w)
+	}
+	if cfg.DictionaryFile != "" {
+		f, err := os.Open(cfg.DictionaryFile)
+		if err != nil {
+			return nil, fmt.Errorf("open password dictionary: %w", err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			p.addWord(scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("read password dictionary: %w", err)
+		}
+	}
+	return p, nil
+}
+
+func (p *Policy) addWord(w string) {
+	if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+		p.dictionary[w] = struct{}{}
+	}
+}
+
+// Validate 校验新密码，username用于禁止密码中包含用户名
+func (p *Policy) Validate(plain, username string) error {
+	n := utf8.RuneCountInString(plain)
+	if n < p.cfg.MinLength {
+		return fmt.Errorf("%w: must be at least %d characters", ErrWeakPassword, p.cfg.MinLength)
+	}
+	if n > p.cfg.MaxLength {
+		return fmt.Errorf("%w: must be at most %d characters", ErrWeakPassword, p.cfg.MaxLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range plain {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+	switch {
+	case p.cfg.RequireUpper && !upper:
+		return fmt.Errorf("%w: must contain an uppercase letter", ErrWeakPassword)
+	case p.cfg.RequireLower && !lower:
+		return fmt.Errorf("%w: must contain a lowercase letter", ErrWeakPassword)
+	case p.cfg.RequireDigit && !digit:
+		return fmt.Errorf("%w: must contain a digit", ErrWeakPassword)
+	case p.cfg.RequireSymbol && !symbol:
+		return fmt.Errorf("%w: must contain a symbol", ErrWeakPassword)
+	}
+
+	lowered := strings.ToLower(plain)
+	if len(username) >= 3 && strings.Contains(lowered, strings.ToLower(username)) {
+		return fmt.Errorf("%w: must not contain the username", ErrWeakPassword)
+	}
+	// 去掉首尾的数字和符号后再查字典，拦截 Password123! 这类变形
+	core := strings.TrimFunc(lowered, func(r rune) bool { return !unicode.IsLetter(r) })
+	if _, ok := p.dictionary[lowered]; ok {
+		return fmt.Errorf("%w: password is too common", ErrWeakPassword)
+	}
+	if _, ok := p.dictionary[core]; ok {
+		return fmt.Errorf("%w: password is too common", ErrWeakPassword)
+	}
+	return nil
+}
+
+// Expired 判断在changedAt设置的密码是否已超过最长使用时间
+func (p *Policy) Expired(changedAt time.Time) bool {
+	return p.cfg.MaxAge > 0 && time.Since(changedAt) > p.cfg.MaxAge
+}
+
+// HistorySize 不能重复使用的历史密码数量
+func (p *Policy) HistorySize() int {
+	return p.cfg.HistorySize
+}
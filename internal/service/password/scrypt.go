@@ -0,0 +1,97 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// scryptHasher 编码格式: $scrypt$ln=15,r=8,p=1$<salt>$<hash>
+type scryptHasher struct {
+	cfg ScryptConfig
+}
+
+type scryptParams struct {
+	logN uint8
+	r    int
+	p    int
+	salt []byte
+	key  []byte
+}
+
+func newScryptHasher(cfg ScryptConfig) *scryptHasher {
+	return &scryptHasher{cfg: cfg}
+}
+
+func (h *scryptHasher) Hash(plain string) (string, error) {
+	salt := make([]byte, h.cfg.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(plain), salt, 1<<h.cfg.LogN, h.cfg.R, h.cfg.P, h.cfg.KeyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s",
+		h.cfg.LogN, h.cfg.R, h.cfg.P,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *scryptHasher) Verify(encoded, plain string) (bool, error) {
+	p, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	key, err := scrypt.Key([]byte(plain), p.salt, 1<<p.logN, p.r, p.p, len(p.key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (h *scryptHasher) NeedsRehash(encoded string) bool {
+	p, err := h.decode(encoded)
+	if err != nil {
+		return true
+	}
+	return p.logN != h.cfg.LogN ||
+		p.r != h.cfg.R ||
+		p.p != h.cfg.P ||
+		len(p.salt) != h.cfg.SaltLength ||
+		len(p.key) != h.cfg.KeyLength
+}
+
+func (h *scryptHasher) Match(encoded string) bool {
+	return strings.HasPrefix(encoded, "$scrypt$")
+}
+
+func (h *scryptHasher) decode(encoded string) (*scryptParams, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return nil, ErrMalformedHash
+	}
+
+	var p scryptParams
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &p.logN, &p.r, &p.p); err != nil {
+		return nil, ErrMalformedHash
+	}
+	// r或p为0时scrypt会panic
+	if p.logN == 0 || p.logN > 30 || p.r <= 0 || p.p <= 0 {
+		return nil, ErrMalformedHash
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return nil, ErrMalformedHash
+	}
+	// 哈希为空时任意密码都能通过比较
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(p.key) == 0 {
+		return nil, ErrMalformedHash
+	}
+	return &p, nil
+}
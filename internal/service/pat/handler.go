@@ -0,0 +1,180 @@
+package pat
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/authz"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+// Handler 个人访问令牌管理
+type Handler struct {
+	l       *slog.Logger
+	db      *gorm.DB
+	service *Service
+	authz   authz.Authorizer
+	info    common.Info
+}
+
+func NewHandler(l *slog.Logger, db *gorm.DB, service *Service, authorizer authz.Authorizer, info common.Info) *Handler {
+	return &Handler{l: l, db: db, service: service, authz: authorizer, info: info}
+}
+
+func (h *Handler) Register(e *gin.Engine) {
+	e.GET("/pat", common.RequireUser(h.info), h.List)
+	e.POST("/pat", common.RequireUser(h.info), h.Create)
+	e.DELETE("/pat/:id", common.RequireUser(h.info), h.Revoke)
+	e.POST("/pat/list", authz.RequirePermission(h.authz, h.info, common.PermPATManage), h.ListAll)
+	e.POST("/user/:id/pat", authz.RequirePermission(h.authz, h.info, common.PermPATManage), h.CreateForUser)
+}
+
+type createReq struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresAt 为空时永不过期
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+func (r *createReq) Validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(r.Scopes) == 0 {
+		return errors.New("scopes is required")
+	}
+	for _, s := range r.Scopes {
+		if s != ScopeRead && s != ScopeWrite {
+			return errors.New("unsupported scope: " + s)
+		}
+	}
+	if r.ExpiresAt != nil && !r.ExpiresAt.After(time.Now()) {
+		return errors.New("expiresAt must be in the future")
+	}
+	return nil
+}
+
+// List 当前用户的令牌
+func (h *Handler) List(c *gin.Context) {
+	tokens, err := h.service.List(c, c.GetUint("userId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	c.JSON(http.StatusOK, common.RespOk("get personal access token list success", tokens, h.info))
+}
+
+// Create 为当前用户签发令牌，明文令牌只返回一次
+func (h *Handler) Create(c *gin.Context) {
+	h.create(c, c.GetUint("userId"))
+}
+
+// CreateForUser 管理员为指定用户签发令牌
+func (h *Handler) CreateForUser(c *gin.Context) {
+	uid, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+	if err := h.db.Where("id = ?", uid).First(&user.User{}).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("user not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	h.create(c, uid)
+}
+
+func (h *Handler) create(c *gin.Context, uid uint) {
+	// 令牌不能再签发令牌，避免泄露的令牌自行续期或扩大权限
+	if c.GetUint("patId") != 0 {
+		c.JSON(http.StatusForbidden, common.RespErr("personal access token cannot create tokens", h.info))
+		return
+	}
+	// 个人访问令牌属于用户，服务账号使用自己的客户端凭据
+	if c.GetUint("serviceAccountId") != 0 {
+		c.JSON(http.StatusForbidden, common.RespErr("service account cannot create personal access tokens", h.info))
+		return
+	}
+	var req createReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	t, plain, err := h.service.Create(c, uid, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("personal access token created", "id", t.ID, "userId", uid, "scopes", t.Scopes, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("create personal access token success", gin.H{
+		"record": t,
+		"token":  plain,
+	}, h.info))
+}
+
+// Revoke 撤销令牌，管理员可以撤销任意用户的令牌
+func (h *Handler) Revoke(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+	manage, err := h.authz.Authorize(c, authz.NewRequest(c, common.PermPATManage, authz.ActionCall))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	t, err := h.service.Revoke(c, id, c.GetUint("userId"), manage)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr(err.Error(), h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("personal access token revoked", "id", t.ID, "userId", t.UserID, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("revoke personal access token success", nil, h.info))
+}
+
+// ListAll 管理员查看所有用户的令牌，可按用户过滤
+func (h *Handler) ListAll(c *gin.Context) {
+	type rBody struct {
+		common.Page
+		UserID uint `json:"userId"`
+	}
+	var req rBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	query := h.db.Model(&Token{})
+	if req.UserID != 0 {
+		query = query.Where("user_id = ?", req.UserID)
+	}
+	var data []Token
+	var count int64
+	query.Count(&count).Order("id").Offset((req.Page.Page - 1) * req.Size).Limit(req.Size).Find(&data)
+
+	c.JSON(http.StatusOK, common.RespOk("get personal access token list success", gin.H{
+		"records": data,
+		"total":   count,
+	}, h.info))
+}
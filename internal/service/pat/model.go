@@ -0,0 +1,55 @@
+package pat
+
+import (
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 令牌权限范围
+const (
+	// ScopeRead 只能发起GET、HEAD、OPTIONS请求
+	ScopeRead = "read"
+	// ScopeWrite 可以发起任意请求
+	ScopeWrite = "write"
+)
+
+// Token 个人访问令牌，供脚本和CI代替密码登录，只保存哈希
+type Token struct {
+	gorm.Model
+	UserID uint   `json:"userId" gorm:"index"`
+	Name   string `json:"name"`
+	// Hint 令牌开头的几位，便于用户辨认
+	Hint       string     `json:"hint" gorm:"size:16"`
+	Hash       string     `json:"-" gorm:"size:64;uniqueIndex"`
+	Scopes     []string   `json:"scopes" gorm:"serializer:json"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	LastUsedIP string     `json:"lastUsedIp" gorm:"size:64"`
+}
+
+func (Token) TableName() string {
+	return "personal_access_token"
+}
+
+// Expired 令牌是否已过期
+func (t *Token) Expired() bool {
+	return t.ExpiresAt != nil && !time.Now().Before(*t.ExpiresAt)
+}
+
+// Allows 判断令牌的权限范围是否允许该请求方法
+func (t *Token) Allows(method string) bool {
+	if slices.Contains(t.Scopes, ScopeWrite) {
+		return true
+	}
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return slices.Contains(t.Scopes, ScopeRead)
+	}
+	return false
+}
+
+func InitPATTable(db *gorm.DB) {
+	db.AutoMigrate(&Token{})
+}
@@ -0,0 +1,133 @@
+package pat
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+// Prefix 个人访问令牌的前缀，用于与JWT区分
+const Prefix = "pat_"
+
+// touchInterval 最近使用时间的更新间隔，避免每个请求都写数据库
+const touchInterval = time.Minute
+
+var (
+	// ErrInvalidToken 令牌不存在、已撤销、已过期或所属用户已删除
+	ErrInvalidToken = errors.New("invalid personal access token")
+	ErrNotFound     = errors.New("personal access token not found")
+)
+
+// Service 个人访问令牌的签发、校验和撤销
+type Service struct {
+	db *gorm.DB
+}
+
+func NewService(db *gorm.DB) *Service {
+	return &Service{db: db}
+}
+
+// IsToken 判断请求携带的凭证是否为个人访问令牌
+func IsToken(token string) bool {
+	return strings.HasPrefix(token, Prefix)
+}
+
+// Create 为用户签发令牌，返回的明文令牌只在此时可见
+func (s *Service) Create(ctx context.Context, userID uint, name string, scopes []string, expiresAt *time.Time) (*Token, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	plain := Prefix + base64.RawURLEncoding.EncodeToString(b)
+
+	t := &Token{
+		UserID:    userID,
+		Name:      name,
+		Hint:      plain[:len(Prefix)+6],
+		Hash:      hashToken(plain),
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(scopes))),
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.WithContext(ctx).Create(t).Error; err != nil {
+		return nil, "", err
+	}
+	return t, plain, nil
+}
+
+// Authenticate 校验令牌并返回所属用户，同时记录最近使用时间和IP
+func (s *Service) Authenticate(ctx context.Context, plain, ip string) (*Token, *user.User, error) {
+	var t Token
+	err := s.db.WithContext(ctx).Where(Token{Hash: hashToken(plain)}).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.Expired() {
+		return nil, nil, ErrInvalidToken
+	}
+
+	var u user.User
+	err = s.db.WithContext(ctx).Where("id = ?", t.UserID).First(&u).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= touchInterval || t.LastUsedIP != ip {
+		t.LastUsedAt, t.LastUsedIP = &now, ip
+		if err := s.db.WithContext(ctx).Model(&t).UpdateColumns(map[string]any{"last_used_at": now, "last_used_ip": ip}).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	return &t, &u, nil
+}
+
+// List 用户的令牌，包含已过期未撤销的令牌
+func (s *Service) List(ctx context.Context, userID uint) ([]Token, error) {
+	var tokens []Token
+	err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&tokens).Error
+	return tokens, err
+}
+
+// Revoke 撤销令牌，manage为true时可以撤销任意用户的令牌，否则只能撤销userID自己的令牌
+func (s *Service) Revoke(ctx context.Context, id, userID uint, manage bool) (*Token, error) {
+	var t Token
+	query := s.db.WithContext(ctx).Where("id = ?", id)
+	if !manage {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.First(&t).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Delete(&t).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// RevokeUser 撤销用户的全部令牌，在退出所有会话、管理员撤销和修改密码时调用
+func (s *Service) RevokeUser(ctx context.Context, userID uint) error {
+	return s.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&Token{}).Error
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
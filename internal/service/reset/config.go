@@ -0,0 +1,70 @@
+package reset
+
+import "time"
+
+// Config 找回密码配置
+type Config struct {
+	// ResetURL 前端重置密码页面，邮件中的链接为 {reseturl}?token=xxx
+	ResetURL string `json:"reseturl"`
+	// TokenTTL 重置链接的有效期
+	TokenTTL time.Duration `json:"tokenttl"`
+	// Cooldown 同一邮箱两次发送邮件的最小间隔
+	Cooldown time.Duration `json:"cooldown"`
+	// IPLimit 同一IP在IPWindow内最多提交的找回密码请求数
+	IPLimit  int64         `json:"iplimit"`
+	IPWindow time.Duration `json:"ipwindow"`
+	// Workers 后台发送邮件的并发数
+	Workers int `json:"workers"`
+	// QueueSize 等待发送的请求上限，队列已满时拒绝新的请求
+	QueueSize int      `json:"queuesize"`
+	Template  Template `json:"template"`
+}
+
+// Template 邮件模板，Subject和Text使用text/template，HTML使用html/template，HTML为空时只发送纯文本
+// 可用变量: .Username .Fullname .Link .ExpiresIn（分钟）
+type Template struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.ResetURL == "" {
+		c.ResetURL = "http://localhost:8080/reset-password"
+	}
+	if c.TokenTTL == 0 {
+		c.TokenTTL = 30 * time.Minute
+	}
+	if c.Cooldown == 0 {
+		c.Cooldown = time.Minute
+	}
+	if c.IPLimit == 0 {
+		c.IPLimit = 10
+	}
+	if c.IPWindow == 0 {
+		c.IPWindow = time.Hour
+	}
+	if c.Workers == 0 {
+		c.Workers = 2
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = 100
+	}
+	if c.Template.Subject == "" {
+		c.Template.Subject = "Reset your password"
+	}
+	if c.Template.Text == "" {
+		c.Template.Text = defaultText
+	}
+	return c
+}
+
+const defaultText = `Hi {{.Username}},
+
+We received a request to reset the password of your account.
+Open the link below within {{.ExpiresIn}} minutes to choose a new password:
+
+{{.Link}}
+
+If you did not request this, you can ignore this email.
+`
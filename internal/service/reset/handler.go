@@ -0,0 +1,294 @@
+package reset
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/mailer"
+	"github.com/z876730060/auth/internal/service/session"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+const (
+	tokenKey    = "password:reset:%s"
+	cooldownKey = "password:reset:cooldown:%s"
+	ipKey       = "password:reset:ip:%s"
+)
+
+var (
+	// errInvalidToken 重置链接不存在、已使用、已过期或密码已被修改
+	errInvalidToken = errors.New("invalid or expired reset token")
+	errRateLimited  = errors.New("too many requests, please try again later")
+	errBusy         = errors.New("service busy, please try again later")
+)
+
+// Handler 通过邮件找回密码，只支持本地账号
+type Handler struct {
+	l         *slog.Logger
+	db        *gorm.DB
+	rdb       *redis.Client
+	sessions  *session.Store
+	mailer    mailer.Mailer
+	templates *templates
+	info      common.Info
+	cfg       Config
+	// jobs 等待后台发送的请求，由固定数量的worker处理
+	jobs chan sendJob
+}
+
+// sendJob 一次找回密码请求
+type sendJob struct {
+	email string
+	ip    string
+	reqID string
+}
+
+func NewHandler(l *slog.Logger, db *gorm.DB, rdb *redis.Client, sessions *session.Store, m mailer.Mailer, info common.Info, cfg Config) (*Handler, error) {
+	cfg = cfg.withDefaults()
+	ts, err := parseTemplates(cfg.Template)
+	if err != nil {
+		return nil, fmt.Errorf("parse mail template: %w", err)
+	}
+	h := &Handler{
+		l:         l,
+		db:        db,
+		rdb:       rdb,
+		sessions:  sessions,
+		mailer:    m,
+		templates: ts,
+		info:      info,
+		cfg:       cfg,
+		jobs:      make(chan sendJob, cfg.QueueSize),
+	}
+	for range cfg.Workers {
+		go h.worker()
+	}
+	return h, nil
+}
+
+func (h *Handler) Register(e *gin.Engine) {
+	e.POST("/password/forgot", h.Forgot)
+	e.POST("/password/reset", h.Reset)
+}
+
+// Forgot 发送重置密码邮件，无论邮箱是否存在都返回相同的结果
+func (h *Handler) Forgot(c *gin.Context) {
+	type rBody struct {
+		Email string `json:"email"`
+	}
+	var req rBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+	addr, err := mail.ParseAddress(req.Email)
+	if err != nil || addr.Address != strings.TrimSpace(req.Email) {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid email", h.info))
+		return
+	}
+
+	ip, reqID := c.ClientIP(), requestid.Get(c)
+	n, err := h.countIP(c, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	if n > h.cfg.IPLimit {
+		h.l.Warn("password reset rate limited", "ip", ip, "requestId", reqID)
+		c.JSON(http.StatusTooManyRequests, common.RespErr(errRateLimited.Error(), h.info))
+		return
+	}
+
+	// 查询和发送在后台完成，响应时间与邮箱是否存在无关
+	select {
+	case h.jobs <- sendJob{email: strings.ToLower(addr.Address), ip: ip, reqID: reqID}:
+	default:
+		h.l.Warn("password reset queue is full", "ip", ip, "requestId", reqID)
+		c.JSON(http.StatusServiceUnavailable, common.RespErr(errBusy.Error(), h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("if the email is registered, a reset link has been sent", nil, h.info))
+}
+
+// countIP 记录一次来自该IP的请求，返回统计窗口内的请求数
+func (h *Handler) countIP(ctx context.Context, ip string) (int64, error) {
+	key := fmt.Sprintf(ipKey, ip)
+	var incr *redis.IntCmd
+	_, err := h.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		incr = p.Incr(ctx, key)
+		p.Expire(ctx, key, h.cfg.IPWindow)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (h *Handler) worker() {
+	for job := range h.jobs {
+		h.send(context.Background(), job.email, job.ip, job.reqID)
+	}
+}
+
+// send 为邮箱对应的本地账号生成重置链接并发送邮件
+func (h *Handler) send(ctx context.Context, email, ip, reqID string) {
+	ok, err := h.rdb.SetNX(ctx, fmt.Sprintf(cooldownKey, common.HashKey(email)), 1, h.cfg.Cooldown).Result()
+	if err != nil {
+		h.l.Error("check password reset cooldown failed", "err", err, "requestId", reqID)
+		return
+	}
+	if !ok {
+		h.l.Warn("password reset requested too frequently", "ip", ip, "requestId", reqID)
+		return
+	}
+
+	var users []user.User
+	if err := h.db.WithContext(ctx).Where("LOWER(email) = ? AND source = ?", email, "").Find(&users).Error; err != nil {
+		h.l.Error("query user by email failed", "err", err, "requestId", reqID)
+		return
+	}
+	if len(users) == 0 {
+		h.l.Info("password reset requested for unknown email", "ip", ip, "requestId", reqID)
+		return
+	}
+
+	for _, u := range users {
+		if err := h.sendTo(ctx, &u); err != nil {
+			h.l.Error("send password reset mail failed", "userId", u.ID, "err", err, "requestId", reqID)
+			continue
+		}
+		h.l.Info("password reset mail sent", "userId", u.ID, "ip", ip, "requestId", reqID)
+	}
+}
+
+func (h *Handler) sendTo(ctx context.Context, u *user.User) error {
+	token, err := common.RandomString(32)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(pendingReset{UserID: u.ID, Stamp: common.HashKey(u.Password)})
+	if err != nil {
+		return err
+	}
+	if err := h.rdb.Set(ctx, fmt.Sprintf(tokenKey, common.HashKey(token)), data, h.cfg.TokenTTL).Err(); err != nil {
+		return err
+	}
+
+	link, err := url.Parse(h.cfg.ResetURL)
+	if err != nil {
+		return err
+	}
+	q := link.Query()
+	q.Set("token", token)
+	link.RawQuery = q.Encode()
+
+	msg, err := h.templates.render(u.Email, templateData{
+		Username:  u.Username,
+		Fullname:  u.Fullname,
+		Link:      link.String(),
+		ExpiresIn: int(h.cfg.TokenTTL / time.Minute),
+	})
+	if err != nil {
+		return err
+	}
+	return h.mailer.Send(ctx, msg)
+}
+
+// Reset 使用邮件中的令牌设置新密码，令牌只能使用一次，成功后撤销该用户的所有会话
+func (h *Handler) Reset(c *gin.Context) {
+	type rBody struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	var req rBody
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+	if req.Password == "" {
+		c.JSON(http.StatusBadRequest, common.RespErr("password is required", h.info))
+		return
+	}
+
+	key := fmt.Sprintf(tokenKey, common.HashKey(req.Token))
+	u, err := h.lookup(c, key)
+	if err != nil {
+		h.respErr(c, err)
+		return
+	}
+	// 新密码不满足策略时保留令牌，用户可以重新输入
+	if err := user.ValidatePassword(h.db, u, req.Password); err != nil {
+		h.respErr(c, err)
+		return
+	}
+	n, err := h.rdb.Del(c, key).Result()
+	if err != nil {
+		h.respErr(c, err)
+		return
+	}
+	if n == 0 {
+		h.respErr(c, errInvalidToken)
+		return
+	}
+	if err := user.ChangePassword(h.db, u, req.Password, false); err != nil {
+		h.respErr(c, err)
+		return
+	}
+	if err := h.sessions.RevokeUser(c, u.ID); err != nil {
+		h.l.Error("revoke sessions after password reset failed", "userId", u.ID, "err", err)
+	}
+
+	h.l.Info("password reset", "userId", u.ID, "ip", c.ClientIP(), "requestId", requestid.Get(c))
+	c.JSON(http.StatusOK, common.RespOk("reset password success", nil, h.info))
+}
+
+// lookup 查询令牌对应的用户，校验用户仍为本地账号且密码未被修改
+func (h *Handler) lookup(ctx context.Context, key string) (*user.User, error) {
+	data, err := h.rdb.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, errInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pending pendingReset
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, err
+	}
+
+	var u user.User
+	if err := h.db.WithContext(ctx).Where("id = ?", pending.UserID).First(&u).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidToken
+		}
+		return nil, err
+	}
+	if u.Source != "" || common.HashKey(u.Password) != pending.Stamp {
+		return nil, errInvalidToken
+	}
+	return &u, nil
+}
+
+func (h *Handler) respErr(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errInvalidToken), user.IsPasswordPolicyErr(err):
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+	default:
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+	}
+}
@@ -0,0 +1,69 @@
+package reset
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"text/template"
+
+	"github.com/z876730060/auth/internal/service/mailer"
+)
+
+// pendingReset 已发送的重置链接，Stamp为发送时密码哈希的摘要，密码修改后链接随之失效
+type pendingReset struct {
+	UserID uint   `json:"userId"`
+	Stamp  string `json:"stamp"`
+}
+
+// templateData 邮件模板变量
+type templateData struct {
+	Username  string
+	Fullname  string
+	Link      string
+	ExpiresIn int
+}
+
+// templates 解析后的邮件模板
+type templates struct {
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+func parseTemplates(t Template) (*templates, error) {
+	var ts templates
+	var err error
+	if ts.subject, err = template.New("subject").Parse(t.Subject); err != nil {
+		return nil, err
+	}
+	if ts.text, err = template.New("text").Parse(t.Text); err != nil {
+		return nil, err
+	}
+	if t.HTML != "" {
+		if ts.html, err = htmltemplate.New("html").Parse(t.HTML); err != nil {
+			return nil, err
+		}
+	}
+	return &ts, nil
+}
+
+// render 生成发送给用户的邮件
+func (ts *templates) render(to string, data templateData) (*mailer.Message, error) {
+	var subject, text, html bytes.Buffer
+	if err := ts.subject.Execute(&subject, data); err != nil {
+		return nil, err
+	}
+	if err := ts.text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if ts.html != nil {
+		if err := ts.html.Execute(&html, data); err != nil {
+			return nil, err
+		}
+	}
+	return &mailer.Message{
+		To:      to,
+		Subject: subject.String(),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
@@ -5,32 +5,37 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
 
 	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/authz"
 	"github.com/z876730060/auth/internal/service/common"
 	"gorm.io/gorm"
 )
 
 type Handler struct {
-	l    *slog.Logger
-	info common.Info
-	db   *gorm.DB
+	l     *slog.Logger
+	info  common.Info
+	db    *gorm.DB
+	authz authz.Authorizer
 }
 
 func (h *Handler) Register(e *gin.Engine) {
-	e.POST("/role/list", h.List)
-	e.POST("/role", h.Add)
-	e.GET("/role/:id", h.GetDetail)
-	e.PUT("/role", h.Update)
-	e.DELETE("/role/:id", h.Del)
-	e.GET("/role/tree", h.GetTree)
+	e.POST("/role/list", authz.RequirePermission(h.authz, h.info, common.PermRoleList), h.List)
+	e.POST("/role", authz.RequirePermission(h.authz, h.info, common.PermRoleCreate), h.Add)
+	e.GET("/role/:id", authz.RequirePermission(h.authz, h.info, common.PermRoleList), h.GetDetail)
+	e.PUT("/role", authz.RequirePermission(h.authz, h.info, common.PermRoleUpdate), h.Update)
+	e.DELETE("/role/:id", authz.RequirePermission(h.authz, h.info, common.PermRoleDelete), h.Del)
+	e.GET("/role/tree", authz.RequirePermission(h.authz, h.info, common.PermRoleList), h.GetTree)
+	e.GET("/permission", authz.RequirePermission(h.authz, h.info, common.PermRoleList), h.ListPermission)
 }
 
-func NewHandler(l *slog.Logger, db *gorm.DB, info common.Info) *Handler {
+func NewHandler(l *slog.Logger, db *gorm.DB, authorizer authz.Authorizer, info common.Info) *Handler {
 	return &Handler{
-		l:    l,
-		info: info,
-		db:   db,
+		l:     l,
+		info:  info,
+		db:    db,
+		authz: authorizer,
 	}
 }
 
@@ -58,17 +63,32 @@ func (h *Handler) List(c *gin.Context) {
 func (h *Handler) Add(c *gin.Context) {
 	type rBody struct {
 		Name           string   `json:"name"`
+		RequireMFA     bool     `json:"requireMfa"`
+		ParentID       uint     `json:"parentId"`
 		MenuPermission []string `json:"menuPermission"`
+		Permissions    []string `json:"permissions"`
 	}
 	var req rBody
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
 		return
 	}
+	if err := validatePermissions(req.Permissions); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+	if !h.checkParent(c, 0, req.ParentID) {
+		return
+	}
+	if !h.checkWildcard(c, req.Permissions, req.ParentID) {
+		return
+	}
 
 	// 首先创建角色
 	role := Role{
-		Name: req.Name,
+		Name:       req.Name,
+		RequireMFA: req.RequireMFA,
+		ParentID:   parentOf(req.ParentID),
 	}
 	if err := h.db.Create(&role).Error; err != nil {
 		if errors.Is(err, gorm.ErrDuplicatedKey) {
@@ -80,7 +100,7 @@ func (h *Handler) Add(c *gin.Context) {
 		}
 	}
 
-	h.l.Info("Add role: %+v", role)
+	h.l.Info("Add role", "id", role.ID, "name", role.Name)
 
 	// 然后创建角色菜单关系
 	for _, menuKey := range req.MenuPermission {
@@ -99,6 +119,12 @@ func (h *Handler) Add(c *gin.Context) {
 		}
 	}
 
+	if err := savePermissions(h.db, role.ID, req.Permissions); err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.reload(c)
 	c.JSON(http.StatusOK, common.RespOk("create role success", nil, h.info))
 }
 func (h *Handler) GetDetail(c *gin.Context) {
@@ -132,39 +158,114 @@ func (h *Handler) GetDetail(c *gin.Context) {
 		menuPermission = append(menuPermission, table.MenuKey)
 	}
 
+	permissions, err := Permissions(h.db, []uint{role.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	// 从祖先角色继承的权限，只读展示
+	ancestors, err := Ancestors(h.db, []uint{role.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	ancestors = slices.DeleteFunc(ancestors, func(id uint) bool { return id == role.ID })
+	inheritedPermissions, err := Permissions(h.db, ancestors)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	inheritedMenuPermission, err := MenuKeys(h.db, ancestors)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
 	// 转换为响应格式
 	c.JSON(http.StatusOK, common.RespOk("get role detail success", gin.H{
-		"role":           role,
-		"menuPermission": menuPermission,
+		"role":                    role,
+		"menuPermission":          menuPermission,
+		"permissions":             permissions,
+		"inheritedMenuPermission": inheritedMenuPermission,
+		"inheritedPermissions":    inheritedPermissions,
 	}, h.info))
 }
 
+// Update 修改角色，parentId省略时保持原父角色，为0时取消父角色
 func (h *Handler) Update(c *gin.Context) {
 	type rBody struct {
 		ID             string   `json:"ID"`
 		Name           string   `json:"name"`
+		RequireMFA     bool     `json:"requireMfa"`
+		ParentID       *uint    `json:"parentId"`
 		MenuPermission []string `json:"menuPermission"`
+		Permissions    []string `json:"permissions"`
 	}
 	var req rBody
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
 		return
 	}
+	if err := validatePermissions(req.Permissions); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
 
 	uid, err := common.ParseID(req.ID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
 		return
 	}
+	if req.ParentID != nil && !h.checkParent(c, uid, *req.ParentID) {
+		return
+	}
 
-	// 首先更新角色
-	role := Role{
-		Model: gorm.Model{
-			ID: uid,
-		},
-		Name: req.Name,
+	var role Role
+	if err := h.db.Where("id = ?", uid).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("role not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
 	}
-	if err := h.db.Save(&role).Error; err != nil {
+	if role.IsSystem && req.Name != role.Name {
+		c.JSON(http.StatusBadRequest, common.RespErr("system role cannot be renamed", h.info))
+		return
+	}
+	if role.IsSystem || role.IsSuperAdmin {
+		super, err := h.isSuperAdmin(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+			return
+		}
+		if !super {
+			c.JSON(http.StatusForbidden, common.RespErr(ErrSuperAdminRequired.Error(), h.info))
+			return
+		}
+	}
+	var parentID uint
+	if req.ParentID != nil {
+		parentID = *req.ParentID
+	}
+	if !h.checkWildcard(c, req.Permissions, parentID) {
+		return
+	}
+
+	// 首先更新角色，系统角色和超级管理员标记不允许通过接口修改
+	updates := map[string]any{
+		"name":        req.Name,
+		"require_mfa": req.RequireMFA,
+	}
+	if req.ParentID != nil {
+		updates["parent_id"] = parentOf(*req.ParentID)
+	}
+	if err := h.db.Model(&role).Updates(updates).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, common.RespErr("role name already exists", h.info))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
 		return
 	}
@@ -192,6 +293,16 @@ func (h *Handler) Update(c *gin.Context) {
 		}
 	}
 
+	// 未传permissions时保留原有权限码，避免旧版前端修改角色时清空权限
+	if req.Permissions != nil {
+		if err := savePermissions(h.db, uid, req.Permissions); err != nil {
+			c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+			return
+		}
+		h.l.Info("role permissions updated", "id", uid, "permissions", req.Permissions, common.Operator(c))
+	}
+
+	h.reload(c)
 	c.JSON(http.StatusOK, common.RespOk("update role success", nil, h.info))
 }
 func (h *Handler) Del(c *gin.Context) {
@@ -203,36 +314,147 @@ func (h *Handler) Del(c *gin.Context) {
 		return
 	}
 
+	var role Role
+	if err := h.db.Where("id = ?", uid).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("role not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	if role.IsSystem {
+		c.JSON(http.StatusBadRequest, common.RespErr("system role cannot be deleted", h.info))
+		return
+	}
+	var children int64
+	if err := h.db.Model(&Role{}).Where("parent_id = ?", uid).Count(&children).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	if children > 0 {
+		c.JSON(http.StatusConflict, common.RespErr("role has child roles", h.info))
+		return
+	}
+
 	// 首先删除角色菜单关系
 	if err := h.db.Where("rid = ?", uid).Delete(&RoleMenu{}).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
 		return
 	}
 
+	if err := h.db.Where("rid = ?", uid).Unscoped().Delete(&RolePermission{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
 	// 然后删除角色
 	if err := h.db.Delete(&Role{}, uid).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
 		return
 	}
 
+	h.l.Info("role deleted", "id", uid, "name", role.Name, common.Operator(c))
+	h.reload(c)
 	c.JSON(http.StatusOK, common.RespOk("delete role success", nil, h.info))
 }
 
+// GetTree 按父角色组装的角色树
 func (h *Handler) GetTree(c *gin.Context) {
 	var roles []Role
-	if err := h.db.Find(&roles).Error; err != nil {
+	if err := h.db.Order("id").Find(&roles).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
 		return
 	}
 
-	var roleTree []RoleTree
-	for _, role := range roles {
-		roleTree = append(roleTree, RoleTree{
-			Title:    role.Name,
-			Key:      fmt.Sprintf("%d", role.ID),
-			Children: []*RoleTree{},
-		})
+	c.JSON(http.StatusOK, common.RespOk("get role tree success", buildTree(roles), h.info))
+}
+
+// ListPermission 所有可授予角色的接口权限码
+func (h *Handler) ListPermission(c *gin.Context) {
+	c.JSON(http.StatusOK, common.RespOk("get permission list success", common.Permissions, h.info))
+}
+
+// reload 角色权限已保存，重新加载失败时由定期加载兜底
+func (h *Handler) reload(c *gin.Context) {
+	if err := h.authz.Reload(c); err != nil {
+		h.l.Error("reload authorization policies failed", "err", err)
 	}
+}
 
-	c.JSON(http.StatusOK, common.RespOk("get role tree success", roleTree, h.info))
+// checkParent 校验父角色，不通过时写入响应并返回false
+func (h *Handler) checkParent(c *gin.Context, id, parentID uint) bool {
+	err := validateParent(h.db, id, parentID)
+	if errors.Is(err, ErrParentNotFound) || errors.Is(err, ErrRoleCycle) {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return false
+	}
+	return true
+}
+
+// isSuperAdmin 操作人是否拥有超级管理员角色
+func (h *Handler) isSuperAdmin(c *gin.Context) (bool, error) {
+	supers, err := SuperAdminRoles(h.db)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(c.GetUintSlice("role"), func(id uint) bool { return slices.Contains(supers, id) }), nil
+}
+
+// checkWildcard 通配权限码只能由超级管理员授予，包括通过父角色继承，不通过时写入响应并返回false
+func (h *Handler) checkWildcard(c *gin.Context, codes []string, parentID uint) bool {
+	inherited, err := inheritsWildcard(h.db, parentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return false
+	}
+	if !inherited && !slices.ContainsFunc(codes, common.IsWildcardPermission) {
+		return true
+	}
+	super, err := h.isSuperAdmin(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return false
+	}
+	if !super {
+		c.JSON(http.StatusForbidden, common.RespErr(ErrSuperAdminRequired.Error(), h.info))
+		return false
+	}
+	return true
+}
+
+// parentOf 请求中的父角色，0表示根角色
+func parentOf(id uint) *uint {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
+
+func validatePermissions(codes []string) error {
+	for _, code := range codes {
+		if !common.ValidPermission(code) {
+			return fmt.Errorf("unknown permission: %s", code)
+		}
+	}
+	return nil
+}
+
+// savePermissions 覆盖角色的权限码
+func savePermissions(db *gorm.DB, rid uint, codes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("rid = ?", rid).Unscoped().Delete(&RolePermission{}).Error; err != nil {
+			return err
+		}
+		for _, code := range slices.Compact(slices.Sorted(slices.Values(codes))) {
+			if err := tx.Create(&RolePermission{Rid: rid, Permission: code}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
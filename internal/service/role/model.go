@@ -4,9 +4,20 @@ import (
 	"gorm.io/gorm"
 )
 
+// legacyAdminRoleID 超级管理员标记上线前以ID为1的角色作为管理员
+const legacyAdminRoleID = 1
+
 type Role struct {
 	gorm.Model
 	Name string `json:"name" gorm:"unique not null"`
+	// RequireMFA 拥有该角色的用户登录时必须完成MFA
+	RequireMFA bool `json:"requireMfa" gorm:"default:false"`
+	// IsSystem 系统内置角色，不能删除或改名，只能通过初始化创建，只有超级管理员可以修改
+	IsSystem bool `json:"isSystem" gorm:"default:false"`
+	// IsSuperAdmin 拥有全部接口和菜单权限，只能通过初始化或直接修改数据库设置
+	IsSuperAdmin bool `json:"isSuperAdmin" gorm:"default:false"`
+	// ParentID 父角色，角色继承父角色及其祖先的菜单权限和接口权限码
+	ParentID *uint `json:"parentId" gorm:"index"`
 }
 
 type RoleMenu struct {
@@ -15,6 +26,13 @@ type RoleMenu struct {
 	MenuKey string `json:"menuKey" gorm:"index"`
 }
 
+// RolePermission 角色绑定的接口权限码
+type RolePermission struct {
+	gorm.Model
+	Rid        uint   `json:"rid" gorm:"index"`
+	Permission string `json:"permission" gorm:"size:100;index"`
+}
+
 type RoleTree struct {
 	Title    string      `json:"title"`
 	Key      string      `json:"key"`
@@ -29,20 +47,70 @@ func (Role) TableName() string {
 	return "role"
 }
 
+// parent 父角色ID，根角色为0
+func (r Role) parent() uint {
+	if r.ParentID == nil {
+		return 0
+	}
+	return *r.ParentID
+}
+
+func (RolePermission) TableName() string {
+	return "role_permission"
+}
+
+// Permissions 查询角色拥有的权限码，已去重
+func Permissions(db *gorm.DB, roleIDs []uint) ([]string, error) {
+	perms := make([]string, 0)
+	if len(roleIDs) == 0 {
+		return perms, nil
+	}
+	err := db.Model(&RolePermission{}).Where("rid IN ?", roleIDs).Distinct().Pluck("permission", &perms).Error
+	return perms, err
+}
+
+// MenuKeys 查询角色拥有的菜单权限，已去重
+func MenuKeys(db *gorm.DB, roleIDs []uint) ([]string, error) {
+	keys := make([]string, 0)
+	if len(roleIDs) == 0 {
+		return keys, nil
+	}
+	err := db.Model(&RoleMenu{}).Where("rid IN ?", roleIDs).Distinct().Pluck("menu_key", &keys).Error
+	return keys, err
+}
+
+// SuperAdminRoles 所有超级管理员角色的ID
+func SuperAdminRoles(db *gorm.DB) ([]uint, error) {
+	ids := make([]uint, 0)
+	err := db.Model(&Role{}).Where("is_super_admin = ?", true).Pluck("id", &ids).Error
+	return ids, err
+}
+
 func InitRoleTable(db *gorm.DB) {
 	db.AutoMigrate(&Role{})
 	db.AutoMigrate(&RoleMenu{})
+	db.AutoMigrate(&RolePermission{})
 
 	var count int64
 	db.Model(&Role{}).Count(&count)
-	if count > 0 {
-		return
+	if count == 0 {
+		db.Create(&Role{
+			Name:         "admin",
+			IsSystem:     true,
+			IsSuperAdmin: true,
+		})
+		db.Create(&Role{
+			Name:     "user",
+			IsSystem: true,
+		})
 	}
 
-	db.Create(&Role{
-		Name: "admin",
-	})
-	db.Create(&Role{
-		Name: "user",
-	})
+	// 升级时没有超级管理员角色，将原来写死的管理员角色迁移为系统超级管理员角色
+	db.Model(&Role{}).Where("is_super_admin = ?", true).Count(&count)
+	if count == 0 {
+		db.Model(&Role{}).Where("id = ?", legacyAdminRoleID).Updates(map[string]any{
+			"is_system":      true,
+			"is_super_admin": true,
+		})
+	}
 }
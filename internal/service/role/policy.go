@@ -0,0 +1,71 @@
+package role
+
+import (
+	"context"
+
+	"github.com/z876730060/auth/internal/service/authz"
+	"gorm.io/gorm"
+)
+
+// Policies 将超级管理员角色、角色的接口权限码和菜单权限转换为授权策略，作为授权引擎的策略来源
+// 角色继承祖先角色的接口权限码和菜单权限，在加载时展开，授权时不需要再遍历层级
+func Policies(ctx context.Context, db *gorm.DB) ([]authz.Policy, error) {
+	db = db.WithContext(ctx)
+	supers, err := SuperAdminRoles(db)
+	if err != nil {
+		return nil, err
+	}
+	h, err := loadHierarchy(db)
+	if err != nil {
+		return nil, err
+	}
+	var permissions []RolePermission
+	if err := db.Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	var menus []RoleMenu
+	if err := db.Find(&menus).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make(map[uint][]string)
+	for _, p := range permissions {
+		codes[p.Rid] = append(codes[p.Rid], p.Permission)
+	}
+	keys := make(map[uint][]string)
+	for _, m := range menus {
+		keys[m.Rid] = append(keys[m.Rid], m.MenuKey)
+	}
+
+	policies := make([]authz.Policy, 0, len(supers)+len(permissions)+len(menus))
+	for _, id := range supers {
+		policies = append(policies, authz.Policy{
+			Subject: authz.RoleSubject(id),
+			Object:  authz.Any,
+			Action:  authz.Any,
+			Effect:  authz.EffectAllow,
+		})
+	}
+	for id := range h {
+		subject := authz.RoleSubject(id)
+		for _, ancestor := range h.ancestors(id) {
+			for _, code := range codes[ancestor] {
+				policies = append(policies, authz.Policy{
+					Subject: subject,
+					Object:  code,
+					Action:  authz.ActionCall,
+					Effect:  authz.EffectAllow,
+				})
+			}
+			for _, key := range keys[ancestor] {
+				policies = append(policies, authz.Policy{
+					Subject: subject,
+					Object:  key,
+					Action:  authz.ActionView,
+					Effect:  authz.EffectAllow,
+				})
+			}
+		}
+	}
+	return policies, nil
+}
@@ -0,0 +1,137 @@
+package role
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/z876730060/auth/internal/service/common"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrRoleCycle 设置父角色后角色层级出现环
+	ErrRoleCycle = errors.New("parent role would create a cycle")
+	// ErrParentNotFound 父角色不存在
+	ErrParentNotFound = errors.New("parent role not found")
+	// ErrSuperAdminRequired 只有超级管理员可以授予通配权限码或修改系统角色、超级管理员角色
+	ErrSuperAdminRequired = errors.New("only super administrators can grant wildcard permissions or modify system roles")
+)
+
+// hierarchy 角色ID到父角色ID，一次查询全部角色后在内存中遍历
+type hierarchy map[uint]uint
+
+func loadHierarchy(db *gorm.DB) (hierarchy, error) {
+	var roles []Role
+	if err := db.Select("id", "parent_id").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	h := make(hierarchy, len(roles))
+	for _, r := range roles {
+		h[r.ID] = r.parent()
+	}
+	return h, nil
+}
+
+// ancestors 角色自身及其所有祖先角色，由近到远，数据中存在环时在重复处停止
+func (h hierarchy) ancestors(id uint) []uint {
+	var ids []uint
+	for id != 0 && !slices.Contains(ids, id) {
+		if _, ok := h[id]; !ok {
+			break
+		}
+		ids = append(ids, id)
+		id = h[id]
+	}
+	return ids
+}
+
+// Ancestors 角色及其所有祖先角色，已去重，用于计算继承的有效权限
+func Ancestors(db *gorm.DB, roleIDs []uint) ([]uint, error) {
+	if len(roleIDs) == 0 {
+		return []uint{}, nil
+	}
+	h, err := loadHierarchy(db)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint, 0, len(roleIDs))
+	for _, id := range roleIDs {
+		ids = append(ids, h.ancestors(id)...)
+	}
+	return slices.Compact(slices.Sorted(slices.Values(ids))), nil
+}
+
+// validateParent 父角色必须存在，且不能是角色自身或其子孙角色，id为0表示新建角色
+func validateParent(db *gorm.DB, id, parentID uint) error {
+	if parentID == 0 {
+		return nil
+	}
+	h, err := loadHierarchy(db)
+	if err != nil {
+		return err
+	}
+	if _, ok := h[parentID]; !ok {
+		return ErrParentNotFound
+	}
+	if id != 0 && slices.Contains(h.ancestors(parentID), id) {
+		return ErrRoleCycle
+	}
+	return nil
+}
+
+// inheritsWildcard 父角色或其祖先角色是否拥有通配权限码，parentID为0时返回false
+func inheritsWildcard(db *gorm.DB, parentID uint) (bool, error) {
+	if parentID == 0 {
+		return false, nil
+	}
+	h, err := loadHierarchy(db)
+	if err != nil {
+		return false, err
+	}
+	var codes []string
+	if err := db.Model(&RolePermission{}).Where("rid IN ?", h.ancestors(parentID)).Pluck("permission", &codes).Error; err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(codes, common.IsWildcardPermission), nil
+}
+
+// buildTree 按父角色组装角色树，父角色不存在或处于环中的角色作为根节点
+func buildTree(roles []Role) []*RoleTree {
+	nodes := make(map[uint]*RoleTree, len(roles))
+	children := make(map[uint][]uint)
+	for _, r := range roles {
+		nodes[r.ID] = &RoleTree{
+			Title:    r.Name,
+			Key:      fmt.Sprintf("%d", r.ID),
+			Children: []*RoleTree{},
+		}
+		children[r.parent()] = append(children[r.parent()], r.ID)
+	}
+
+	roots := make([]*RoleTree, 0)
+	attached := make(map[uint]bool, len(roles))
+	var attach func(id uint)
+	attach = func(id uint) {
+		attached[id] = true
+		for _, child := range children[id] {
+			if !attached[child] {
+				nodes[id].Children = append(nodes[id].Children, nodes[child])
+				attach(child)
+			}
+		}
+	}
+	for _, r := range roles {
+		if _, ok := nodes[r.parent()]; !ok {
+			roots = append(roots, nodes[r.ID])
+			attach(r.ID)
+		}
+	}
+	for _, r := range roles {
+		if !attached[r.ID] {
+			roots = append(roots, nodes[r.ID])
+			attach(r.ID)
+		}
+	}
+	return roots
+}
@@ -0,0 +1,26 @@
+package saml
+
+import "time"
+
+// Config SAML服务提供方(SP)配置
+type Config struct {
+	// RootURL 本服务的外部访问地址，用于生成SP实体ID和ACS地址
+	RootURL string `json:"rooturl"`
+	// CallbackURL 前端单点登录回调页，成功时带上code参数，失败时带上error参数
+	CallbackURL string `json:"callbackurl"`
+	// CertFile、KeyFile SP证书和RSA私钥，用于签名认证请求和解密加密的断言，为空时不签名
+	CertFile string `json:"certfile"`
+	KeyFile  string `json:"keyfile"`
+	// RequestTTL 等待IdP响应的时限
+	RequestTTL time.Duration `json:"requestttl"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.CallbackURL == "" {
+		c.CallbackURL = "/sso/callback"
+	}
+	if c.RequestTTL == 0 {
+		c.RequestTTL = 5 * time.Minute
+	}
+	return c
+}
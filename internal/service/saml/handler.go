@@ -0,0 +1,342 @@
+package saml
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	crewsaml "github.com/crewjam/saml"
+	"github.com/gin-contrib/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/authn"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/user"
+	"gorm.io/gorm"
+)
+
+const (
+	requestKey   = "saml:request:%s"
+	assertionKey = "saml:assertion:%s:%s"
+)
+
+// 回调前端时的错误码
+const (
+	errInvalidIdP       = "invalid_idp"
+	errInvalidResponse  = "invalid_response"
+	errAccountConflict  = "account_conflict"
+	errMissingUsername  = "missing_username"
+	errServerError      = "server_error"
+	errUnsolicitedLogin = "unsolicited_response"
+)
+
+// CodeIssuer 签发一次性登录码，由login.Handler实现
+type CodeIssuer interface {
+	NewSSOCode(ctx context.Context, u *user.User) (string, error)
+}
+
+// Handler SAML服务提供方，每个IdP使用独立的实体ID和ACS地址
+type Handler struct {
+	l     *slog.Logger
+	db    *gorm.DB
+	rdb   *redis.Client
+	codes CodeIssuer
+	info  common.Info
+	cfg   Config
+	key   *rsa.PrivateKey
+	cert  *x509.Certificate
+}
+
+func NewHandler(l *slog.Logger, db *gorm.DB, rdb *redis.Client, codes CodeIssuer, info common.Info, cfg Config) (*Handler, error) {
+	h := &Handler{l: l, db: db, rdb: rdb, codes: codes, info: info, cfg: cfg.withDefaults()}
+	var err error
+	if h.cfg.RootURL, err = common.ParseRootURL("saml rooturl", cfg.RootURL); err != nil {
+		return nil, err
+	}
+	if cfg.CertFile == "" && cfg.KeyFile == "" {
+		return h, nil
+	}
+
+	pair, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load saml sp key pair: %w", err)
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("saml sp key must be an rsa private key")
+	}
+	if h.cert, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("parse saml sp certificate: %w", err)
+	}
+	h.key = key
+	return h, nil
+}
+
+func (h *Handler) Register(e *gin.Engine) {
+	e.GET("/saml/:idp/metadata", h.Metadata)
+	e.GET("/saml/:idp/login", h.Login)
+	e.POST("/saml/:idp/acs", h.ACS)
+}
+
+// Metadata SP元数据，配置到IdP中
+func (h *Handler) Metadata(c *gin.Context) {
+	idp, err := h.findIdP(c.Param("idp"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("identity provider not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	sp, err := h.serviceProvider(c, idp)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	data, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	c.Data(http.StatusOK, "application/samlmetadata+xml", data)
+}
+
+// Login 发起SP登录，跳转到IdP，redirect参数为登录完成后前端跳转的页面
+func (h *Handler) Login(c *gin.Context) {
+	idp, err := h.findIdP(c.Param("idp"))
+	if err != nil {
+		h.fail(c, errInvalidIdP, err)
+		return
+	}
+	sp, err := h.serviceProvider(c, idp)
+	if err != nil {
+		h.fail(c, errServerError, err)
+		return
+	}
+
+	location := sp.GetSSOBindingLocation(crewsaml.HTTPRedirectBinding)
+	if location == "" {
+		h.fail(c, errInvalidIdP, errors.New("idp has no http-redirect sso endpoint"))
+		return
+	}
+	req, err := sp.MakeAuthenticationRequest(location, crewsaml.HTTPRedirectBinding, crewsaml.HTTPPostBinding)
+	if err != nil {
+		h.fail(c, errServerError, err)
+		return
+	}
+
+	state, err := common.RandomString(32)
+	if err != nil {
+		h.fail(c, errServerError, err)
+		return
+	}
+	data, err := json.Marshal(pendingRequest{Slug: idp.Slug, RequestID: req.ID, Redirect: safeRedirect(c.Query("redirect"))})
+	if err != nil {
+		h.fail(c, errServerError, err)
+		return
+	}
+	if err := h.rdb.Set(c, fmt.Sprintf(requestKey, common.HashKey(state)), data, h.cfg.RequestTTL).Err(); err != nil {
+		h.fail(c, errServerError, err)
+		return
+	}
+
+	redirect, err := req.Redirect(state, sp)
+	if err != nil {
+		h.fail(c, errServerError, err)
+		return
+	}
+	c.Redirect(http.StatusFound, redirect.String())
+}
+
+// ACS 断言消费端点，校验IdP签名的响应后创建或更新本地账号，再带一次性登录码跳回前端
+func (h *Handler) ACS(c *gin.Context) {
+	idp, err := h.findIdP(c.Param("idp"))
+	if err != nil {
+		h.fail(c, errInvalidIdP, err)
+		return
+	}
+	sp, err := h.serviceProvider(c, idp)
+	if err != nil {
+		h.fail(c, errServerError, err)
+		return
+	}
+
+	// SP发起的登录只接受对应请求的响应，请求状态只能使用一次
+	pending, err := h.takeRequest(c, c.PostForm("RelayState"))
+	if err != nil {
+		h.fail(c, errServerError, err)
+		return
+	}
+	var possibleIDs []string
+	redirect := ""
+	if pending != nil && pending.Slug == idp.Slug {
+		possibleIDs = []string{pending.RequestID}
+		redirect = pending.Redirect
+	} else if idp.AllowUnsolicited {
+		sp.AllowIDPInitiated = true
+	} else {
+		h.fail(c, errUnsolicitedLogin, errors.New("no pending authn request"))
+		return
+	}
+
+	assertion, err := sp.ParseResponse(c.Request, possibleIDs)
+	if err != nil {
+		var invalid *crewsaml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		h.fail(c, errInvalidResponse, err)
+		return
+	}
+	if err := h.markAssertion(c, idp, assertion); err != nil {
+		h.fail(c, errInvalidResponse, err)
+		return
+	}
+
+	profile := idp.profile(assertion)
+	if profile.Username == "" {
+		h.fail(c, errMissingUsername, errors.New("assertion has no username"))
+		return
+	}
+	roles := idp.roles(assertion)
+	u, err := authn.Provision(c, h.db, idp.Source(), profile, roles)
+	if err != nil {
+		if errors.Is(err, authn.ErrAccountConflict) {
+			h.fail(c, errAccountConflict, fmt.Errorf("username %q belongs to another source", profile.Username))
+			return
+		}
+		h.fail(c, errServerError, err)
+		return
+	}
+
+	code, err := h.codes.NewSSOCode(c, u)
+	if err != nil {
+		h.fail(c, errServerError, err)
+		return
+	}
+
+	h.l.Info("saml login", "idp", idp.Slug, "userId", u.ID, "username", u.Username, "roles", roles.Granted, "requestId", requestid.Get(c))
+	c.Redirect(http.StatusFound, h.callback(url.Values{"code": {code}, "redirect": {redirect}}))
+}
+
+// serviceProvider 按IdP配置创建SP
+func (h *Handler) serviceProvider(c *gin.Context, idp *IdentityProvider) (*crewsaml.ServiceProvider, error) {
+	entity, err := idp.EntityDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	base := h.cfg.RootURL + "/saml/" + idp.Slug
+	metadataURL, err := url.Parse(base + "/metadata")
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := url.Parse(base + "/acs")
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &crewsaml.ServiceProvider{
+		EntityID:          metadataURL.String(),
+		Key:               h.key,
+		Certificate:       h.cert,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       entity,
+		AuthnNameIDFormat: crewsaml.UnspecifiedNameIDFormat,
+	}
+	if idp.NameIDFormat != "" {
+		sp.AuthnNameIDFormat = crewsaml.NameIDFormat(idp.NameIDFormat)
+	}
+	if h.key != nil {
+		sp.SignatureMethod = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	}
+	return sp, nil
+}
+
+func (h *Handler) findIdP(slug string) (*IdentityProvider, error) {
+	var idp IdentityProvider
+	if err := h.db.Where("slug = ? AND enabled = ?", slug, true).First(&idp).Error; err != nil {
+		return nil, err
+	}
+	return &idp, nil
+}
+
+// takeRequest 取出并删除RelayState对应的认证请求
+func (h *Handler) takeRequest(ctx context.Context, state string) (*pendingRequest, error) {
+	if state == "" {
+		return nil, nil
+	}
+	data, err := h.rdb.GetDel(ctx, fmt.Sprintf(requestKey, common.HashKey(state))).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pending pendingRequest
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, err
+	}
+	return &pending, nil
+}
+
+// markAssertion 记录已使用的断言ID直到断言过期，防止重放
+func (h *Handler) markAssertion(ctx context.Context, idp *IdentityProvider, assertion *crewsaml.Assertion) error {
+	ttl := crewsaml.MaxIssueDelay
+	if assertion.Conditions != nil && !assertion.Conditions.NotOnOrAfter.IsZero() {
+		ttl = time.Until(assertion.Conditions.NotOnOrAfter) + crewsaml.MaxClockSkew
+	}
+	ok, err := h.rdb.SetNX(ctx, fmt.Sprintf(assertionKey, idp.Slug, common.HashKey(assertion.ID)), 1, ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("assertion already used")
+	}
+	return nil
+}
+
+// fail 记录错误并带错误码跳回前端回调页
+func (h *Handler) fail(c *gin.Context, code string, err error) {
+	if code == errServerError {
+		h.l.Error("saml login failed", "idp", c.Param("idp"), "err", err, "requestId", requestid.Get(c))
+	} else {
+		h.l.Warn("saml login rejected", "idp", c.Param("idp"), "error", code, "err", err, "requestId", requestid.Get(c))
+	}
+	c.Redirect(http.StatusFound, h.callback(url.Values{"error": {code}}))
+}
+
+func (h *Handler) callback(params url.Values) string {
+	u, err := url.Parse(h.cfg.CallbackURL)
+	if err != nil {
+		return h.cfg.CallbackURL
+	}
+	query := u.Query()
+	for k, v := range params {
+		if len(v) > 0 && v[0] != "" {
+			query[k] = v
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// safeRedirect 只允许站内相对路径，防止开放重定向
+func safeRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return ""
+	}
+	return redirect
+}
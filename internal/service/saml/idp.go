@@ -0,0 +1,173 @@
+package saml
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/authz"
+	"github.com/z876730060/auth/internal/service/common"
+	"gorm.io/gorm"
+)
+
+// IdPHandler SAML IdP配置管理
+type IdPHandler struct {
+	l     *slog.Logger
+	db    *gorm.DB
+	authz authz.Authorizer
+	info  common.Info
+}
+
+func NewIdPHandler(l *slog.Logger, db *gorm.DB, authorizer authz.Authorizer, info common.Info) *IdPHandler {
+	return &IdPHandler{l: l, db: db, authz: authorizer, info: info}
+}
+
+func (h *IdPHandler) Register(e *gin.Engine) {
+	e.POST("/saml/idp/list", authz.RequirePermission(h.authz, h.info, common.PermSAMLIdPList), h.List)
+	e.POST("/saml/idp", authz.RequirePermission(h.authz, h.info, common.PermSAMLIdPCreate), h.Add)
+	e.GET("/saml/idp/:id", authz.RequirePermission(h.authz, h.info, common.PermSAMLIdPList), h.GetDetail)
+	e.PUT("/saml/idp", authz.RequirePermission(h.authz, h.info, common.PermSAMLIdPUpdate), h.Update)
+	e.DELETE("/saml/idp/:id", authz.RequirePermission(h.authz, h.info, common.PermSAMLIdPDelete), h.Del)
+}
+
+func (p *IdentityProvider) Validate() error {
+	if !slugPattern.MatchString(p.Slug) || p.Slug == reservedSlug {
+		return errors.New("invalid slug")
+	}
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	if _, err := p.EntityDescriptor(); err != nil {
+		return err
+	}
+	for _, gr := range p.GroupRoles {
+		if gr.Group == "" {
+			return errors.New("group is required in group roles")
+		}
+	}
+	return nil
+}
+
+// List IdP列表
+func (h *IdPHandler) List(c *gin.Context) {
+	type rBody struct {
+		common.Page
+	}
+	var req rBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	var data []IdentityProvider
+	var count int64
+	h.db.Model(&IdentityProvider{}).Count(&count).Order("id").Offset((req.Page.Page - 1) * req.Size).Limit(req.Size).Find(&data)
+
+	c.JSON(http.StatusOK, common.RespOk("get identity provider list success", gin.H{
+		"records": data,
+		"total":   count,
+	}, h.info))
+}
+
+// Add 添加IdP
+func (h *IdPHandler) Add(c *gin.Context) {
+	var idp IdentityProvider
+	if err := c.ShouldBindJSON(&idp); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+	if err := idp.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+	idp.Model = gorm.Model{}
+
+	if err := h.db.Create(&idp).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, common.RespErr("slug already exists", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("saml idp added", "slug", idp.Slug, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("add identity provider success", idp, h.info))
+}
+
+// GetDetail IdP详情
+func (h *IdPHandler) GetDetail(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	var idp IdentityProvider
+	if err := h.db.Where("id = ?", id).First(&idp).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("identity provider not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("get identity provider detail success", idp, h.info))
+}
+
+// Update 修改IdP，slug决定SP地址和账号来源，不可修改
+func (h *IdPHandler) Update(c *gin.Context) {
+	var req IdentityProvider
+	if err := c.ShouldBindJSON(&req); err != nil || req.ID == 0 {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	var idp IdentityProvider
+	if err := h.db.Where("id = ?", req.ID).First(&idp).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("identity provider not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	req.Model = idp.Model
+	req.Slug = idp.Slug
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	if err := h.db.Save(&req).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("saml idp updated", "slug", req.Slug, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("update identity provider success", req, h.info))
+}
+
+// Del 删除IdP，已创建的本地账号保留
+func (h *IdPHandler) Del(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	result := h.db.Unscoped().Delete(&IdentityProvider{}, id)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(result.Error.Error(), h.info))
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, common.RespErr("identity provider not found", h.info))
+		return
+	}
+
+	h.l.Info("saml idp deleted", "id", id, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("delete identity provider success", nil, h.info))
+}
@@ -0,0 +1,147 @@
+package saml
+
+import (
+	"encoding/xml"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+
+	crewsaml "github.com/crewjam/saml"
+	"github.com/z876730060/auth/internal/service/authn"
+	"gorm.io/gorm"
+)
+
+// reservedSlug 与IdP管理接口的路径冲突
+const reservedSlug = "idp"
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,23}$`)
+
+// IdentityProvider 企业客户的SAML IdP配置
+type IdentityProvider struct {
+	gorm.Model
+	// Slug 出现在SP地址中，如 /saml/{slug}/acs，同时作为本地账号来源的一部分，创建后不可修改
+	Slug    string `json:"slug" gorm:"size:24;uniqueIndex"`
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// Metadata IdP元数据XML，包含SSO地址和签名证书
+	Metadata string `json:"metadata" gorm:"type:text"`
+	// NameIDFormat 请求的NameID格式，为空时不指定
+	NameIDFormat string `json:"nameIdFormat"`
+	// AllowUnsolicited 是否接受IdP发起的登录，此时无法校验InResponseTo
+	AllowUnsolicited bool `json:"allowUnsolicited"`
+	// UsernameAttr 用户名属性，为空时使用NameID
+	UsernameAttr string `json:"usernameAttr"`
+	FullnameAttr string `json:"fullnameAttr"`
+	EmailAttr    string `json:"emailAttr"`
+	PhoneAttr    string `json:"phoneAttr"`
+	// GroupsAttr 组属性，按GroupRoles映射为角色
+	GroupsAttr   string      `json:"groupsAttr"`
+	GroupRoles   []GroupRole `json:"groupRoles" gorm:"serializer:json"`
+	DefaultRoles []uint      `json:"defaultRoles" gorm:"serializer:json"`
+}
+
+func (IdentityProvider) TableName() string {
+	return "saml_idp"
+}
+
+// GroupRole SAML组属性值映射的角色
+type GroupRole struct {
+	Group   string `json:"group"`
+	RoleIDs []uint `json:"roleIds"`
+}
+
+// Source 该IdP创建的本地账号来源
+func (p *IdentityProvider) Source() string {
+	return "saml:" + p.Slug
+}
+
+// EntityDescriptor 解析IdP元数据，兼容包含多个实体的EntitiesDescriptor
+func (p *IdentityProvider) EntityDescriptor() (*crewsaml.EntityDescriptor, error) {
+	var entity crewsaml.EntityDescriptor
+	if err := xml.Unmarshal([]byte(p.Metadata), &entity); err == nil {
+		if len(entity.IDPSSODescriptors) == 0 {
+			return nil, errors.New("metadata has no idp sso descriptor")
+		}
+		return &entity, nil
+	}
+
+	var entities crewsaml.EntitiesDescriptor
+	if err := xml.Unmarshal([]byte(p.Metadata), &entities); err != nil {
+		return nil, errors.New("invalid idp metadata")
+	}
+	for i := range entities.EntityDescriptors {
+		if len(entities.EntityDescriptors[i].IDPSSODescriptors) > 0 {
+			return &entities.EntityDescriptors[i], nil
+		}
+	}
+	return nil, errors.New("metadata has no idp sso descriptor")
+}
+
+// profile 按属性映射读取用户信息
+func (p *IdentityProvider) profile(assertion *crewsaml.Assertion) authn.Profile {
+	profile := authn.Profile{
+		Username: firstValue(assertion, p.UsernameAttr),
+		Fullname: firstValue(assertion, p.FullnameAttr),
+		Email:    firstValue(assertion, p.EmailAttr),
+		Phone:    firstValue(assertion, p.PhoneAttr),
+	}
+	if p.UsernameAttr == "" && assertion.Subject != nil && assertion.Subject.NameID != nil {
+		profile.Username = assertion.Subject.NameID.Value
+	}
+	return profile
+}
+
+// roles 按组属性映射角色
+func (p *IdentityProvider) roles(assertion *crewsaml.Assertion) authn.Roles {
+	groups := attributeValues(assertion, p.GroupsAttr)
+	mapping := make([]authn.GroupRole, len(p.GroupRoles))
+	for i, gr := range p.GroupRoles {
+		mapping[i] = authn.GroupRole{Group: gr.Group, RoleIDs: gr.RoleIDs}
+	}
+	roles := authn.MapRoles(mapping, func(group string) bool {
+		return slices.Contains(groups, group)
+	})
+	roles.Defaults = p.DefaultRoles
+	return roles
+}
+
+// pendingRequest 已发出等待IdP响应的认证请求
+type pendingRequest struct {
+	Slug      string `json:"slug"`
+	RequestID string `json:"requestId"`
+	// Redirect 登录完成后前端跳转的页面
+	Redirect string `json:"redirect"`
+}
+
+func InitSAMLTable(db *gorm.DB) {
+	db.AutoMigrate(&IdentityProvider{})
+}
+
+// attributeValues 按Name或FriendlyName读取属性的全部值
+func attributeValues(assertion *crewsaml.Assertion, name string) []string {
+	if name == "" {
+		return nil
+	}
+	var values []string
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			if attr.Name != name && attr.FriendlyName != name {
+				continue
+			}
+			for _, v := range attr.Values {
+				if value := strings.TrimSpace(v.Value); value != "" {
+					values = append(values, value)
+				}
+			}
+		}
+	}
+	return values
+}
+
+func firstValue(assertion *crewsaml.Assertion, name string) string {
+	if values := attributeValues(assertion, name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,295 @@
+package serviceaccount
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/authz"
+	"github.com/z876730060/auth/internal/service/common"
+	"gorm.io/gorm"
+)
+
+// AccountHandler 服务账号管理
+type AccountHandler struct {
+	l     *slog.Logger
+	db    *gorm.DB
+	authz authz.Authorizer
+	info  common.Info
+}
+
+func NewAccountHandler(l *slog.Logger, db *gorm.DB, authorizer authz.Authorizer, info common.Info) *AccountHandler {
+	return &AccountHandler{l: l, db: db, authz: authorizer, info: info}
+}
+
+func (h *AccountHandler) Register(e *gin.Engine) {
+	e.POST("/service-account/list", authz.RequirePermission(h.authz, h.info, common.PermServiceAccountList), h.List)
+	e.POST("/service-account", authz.RequirePermission(h.authz, h.info, common.PermServiceAccountCreate), h.Add)
+	e.GET("/service-account/:id", authz.RequirePermission(h.authz, h.info, common.PermServiceAccountList), h.GetDetail)
+	e.PUT("/service-account", authz.RequirePermission(h.authz, h.info, common.PermServiceAccountUpdate), h.Update)
+	e.DELETE("/service-account/:id", authz.RequirePermission(h.authz, h.info, common.PermServiceAccountDelete), h.Del)
+	e.POST("/service-account/:id/secret", authz.RequirePermission(h.authz, h.info, common.PermServiceAccountUpdate), h.RotateSecret)
+}
+
+type accountReq struct {
+	ID          uint   `json:"id"`
+	ClientID    string `json:"clientId"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	PublicKey   string `json:"publicKey"`
+	RoleIDs     []uint `json:"roleIds"`
+}
+
+func (r *accountReq) Validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if r.PublicKey != "" {
+		if _, _, err := parsePublicKey(r.PublicKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accountDetail 服务账号详情，包含绑定的角色
+type accountDetail struct {
+	ServiceAccount
+	HasSecret bool   `json:"hasSecret"`
+	RoleIDs   []uint `json:"roleIds"`
+}
+
+// List 服务账号列表
+func (h *AccountHandler) List(c *gin.Context) {
+	type rBody struct {
+		common.Page
+	}
+	var req rBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+
+	var data []ServiceAccount
+	var count int64
+	h.db.Model(&ServiceAccount{}).Count(&count).Order("id").Offset((req.Page.Page - 1) * req.Size).Limit(req.Size).Find(&data)
+
+	c.JSON(http.StatusOK, common.RespOk("get service account list success", gin.H{
+		"records": data,
+		"total":   count,
+	}, h.info))
+}
+
+// Add 创建服务账号，未提供公钥时生成客户端密钥，密钥只在创建时返回一次
+func (h *AccountHandler) Add(c *gin.Context) {
+	var req accountReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	account := ServiceAccount{
+		ClientID:    req.ClientID,
+		Name:        req.Name,
+		Description: req.Description,
+		Enabled:     req.Enabled,
+		PublicKey:   req.PublicKey,
+	}
+	if account.ClientID == "" {
+		id, err := common.RandomString(16)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+			return
+		}
+		account.ClientID = "sa-" + id
+	}
+	var secret string
+	if account.PublicKey == "" {
+		var err error
+		if secret, err = common.RandomString(32); err != nil {
+			c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+			return
+		}
+		account.SecretHash = common.HashKey(secret)
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&account).Error; err != nil {
+			return err
+		}
+		return saveRoles(tx, account.ID, req.RoleIDs)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, common.RespErr("client id already exists", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("service account created", "clientId", account.ClientID, "roleIds", req.RoleIDs, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("add service account success", gin.H{
+		"serviceAccount": account,
+		"clientSecret":   secret,
+	}, h.info))
+}
+
+// GetDetail 服务账号详情
+func (h *AccountHandler) GetDetail(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	var account ServiceAccount
+	if err := h.db.Where("id = ?", id).First(&account).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("service account not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	roles, err := roleIDs(h.db, account.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("get service account detail success", accountDetail{
+		ServiceAccount: account,
+		HasSecret:      account.HasSecret(),
+		RoleIDs:        roles,
+	}, h.info))
+}
+
+// Update 修改服务账号及其角色，client id和密钥不可修改
+func (h *AccountHandler) Update(c *gin.Context) {
+	var req accountReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.ID == 0 {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	var account ServiceAccount
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", req.ID).First(&account).Error; err != nil {
+			return err
+		}
+		if req.PublicKey == "" && !account.HasSecret() {
+			return errNoCredential
+		}
+		account.Name = req.Name
+		account.Description = req.Description
+		account.Enabled = req.Enabled
+		account.PublicKey = req.PublicKey
+		if err := tx.Save(&account).Error; err != nil {
+			return err
+		}
+		return saveRoles(tx, account.ID, req.RoleIDs)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, common.RespErr("service account not found", h.info))
+		case errors.Is(err, errNoCredential):
+			c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		default:
+			c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		}
+		return
+	}
+
+	h.l.Info("service account updated", "clientId", account.ClientID, "enabled", account.Enabled, "roleIds", req.RoleIDs, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("update service account success", account, h.info))
+}
+
+// Del 删除服务账号及其角色绑定，已签发的令牌在下次访问时失效
+func (h *AccountHandler) Del(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var account ServiceAccount
+		if err := tx.Where("id = ?", id).First(&account).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("service_account_id = ?", id).Delete(&AccountRole{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&account).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("service account not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	h.l.Info("service account deleted", "id", id, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("delete service account success", nil, h.info))
+}
+
+// RotateSecret 重新生成客户端密钥，旧密钥立即失效
+func (h *AccountHandler) RotateSecret(c *gin.Context) {
+	id, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		return
+	}
+
+	secret, err := common.RandomString(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(err.Error(), h.info))
+		return
+	}
+	result := h.db.Model(&ServiceAccount{}).Where("id = ?", id).Update("secret_hash", common.HashKey(secret))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr(result.Error.Error(), h.info))
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, common.RespErr("service account not found", h.info))
+		return
+	}
+
+	h.l.Info("service account secret rotated", "id", id, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("rotate service account secret success", gin.H{
+		"clientSecret": secret,
+	}, h.info))
+}
+
+// errNoCredential 既没有密钥也没有公钥的服务账号无法认证
+var errNoCredential = errors.New("public key is required for service account without secret")
+
+// saveRoles 覆盖服务账号绑定的角色
+func saveRoles(tx *gorm.DB, id uint, roleIDs []uint) error {
+	if err := tx.Unscoped().Where("service_account_id = ?", id).Delete(&AccountRole{}).Error; err != nil {
+		return err
+	}
+	if len(roleIDs) == 0 {
+		return nil
+	}
+	roles := make([]AccountRole, 0, len(roleIDs))
+	for _, rid := range roleIDs {
+		roles = append(roles, AccountRole{ServiceAccountID: id, RoleID: rid})
+	}
+	return tx.Create(&roles).Error
+}
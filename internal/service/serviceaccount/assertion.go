@@ -0,0 +1,85 @@
+package serviceaccount
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AssertionType RFC 7523 私钥签名的client_assertion类型
+const AssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// parsePublicKey 解析PEM格式的公钥，支持RSA、ECDSA和Ed25519，返回对应的签名算法
+func parsePublicKey(data string) (crypto.PublicKey, []string, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, nil, errors.New("invalid pem public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, nil, errors.New("invalid pem public key")
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return nil, nil, errors.New("rsa public key must be at least 2048 bits")
+		}
+		return k, []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}, nil
+	case *ecdsa.PublicKey:
+		return k, []string{"ES256", "ES384", "ES512"}, nil
+	case ed25519.PublicKey:
+		return k, []string{"EdDSA"}, nil
+	default:
+		return nil, nil, errors.New("unsupported public key type")
+	}
+}
+
+// assertionIssuer 读取未校验的client_assertion中的签发者，即服务账号的client_id
+func assertionIssuer(assertion string) (string, error) {
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(assertion, &claims); err != nil {
+		return "", err
+	}
+	return claims.Issuer, nil
+}
+
+// verifyAssertion 校验client_assertion的签名、签发者、受众和有效期，返回其中的声明
+// iss和sub必须为服务账号的client_id，exp不能超过maxTTL
+func (a *ServiceAccount) verifyAssertion(assertion, audience string, maxTTL time.Duration) (*jwt.RegisteredClaims, error) {
+	if a.PublicKey == "" {
+		return nil, errors.New("service account has no public key")
+	}
+	key, methods, err := parsePublicKey(a.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims jwt.RegisteredClaims
+	_, err = jwt.ParseWithClaims(assertion, &claims, func(*jwt.Token) (any, error) {
+		return key, nil
+	},
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuer(a.ClientID),
+		jwt.WithSubject(a.ClientID),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30*time.Second),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ID == "" {
+		return nil, errors.New("client assertion has no jti")
+	}
+	if time.Until(claims.ExpiresAt.Time) > maxTTL {
+		return nil, errors.New("client assertion expires too late")
+	}
+	return &claims, nil
+}
@@ -0,0 +1,18 @@
+package serviceaccount
+
+import "time"
+
+// Config 服务账号令牌端点配置
+type Config struct {
+	// RootURL 本服务的外部访问地址，client_assertion的aud必须为 {rooturl}/service-account/token
+	RootURL string `json:"rooturl"`
+	// AssertionTTL client_assertion允许的最长有效期
+	AssertionTTL time.Duration `json:"assertionttl"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.AssertionTTL == 0 {
+		c.AssertionTTL = 5 * time.Minute
+	}
+	return c
+}
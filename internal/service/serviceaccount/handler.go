@@ -0,0 +1,175 @@
+package serviceaccount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/session"
+	"gorm.io/gorm"
+)
+
+const tokenPath = "/service-account/token"
+
+// Handler 服务账号令牌端点，使用client_credentials换取access token
+type Handler struct {
+	l        *slog.Logger
+	db       *gorm.DB
+	rdb      *redis.Client
+	sessions *session.Store
+	info     common.Info
+	cfg      Config
+}
+
+func NewHandler(l *slog.Logger, db *gorm.DB, rdb *redis.Client, sessions *session.Store, info common.Info, cfg Config) (*Handler, error) {
+	cfg = cfg.withDefaults()
+	var err error
+	if cfg.RootURL, err = common.ParseRootURL("service account rooturl", cfg.RootURL); err != nil {
+		return nil, err
+	}
+	return &Handler{l: l, db: db, rdb: rdb, sessions: sessions, info: info, cfg: cfg}, nil
+}
+
+func (h *Handler) Register(e *gin.Engine) {
+	e.POST(tokenPath, h.Token)
+}
+
+// TokenResp 令牌端点响应，与OAuth2一致
+type TokenResp struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Token 令牌端点，支持client_secret_basic、client_secret_post和private_key_jwt
+func (h *Handler) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	if c.PostForm("grant_type") != "client_credentials" {
+		common.TokenErr(c, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	account, method, err := h.authenticate(c)
+	if err != nil {
+		if errors.Is(err, errAuthFailed) {
+			h.l.Warn("service account authentication failed", "clientId", account.ClientID, "method", method, "ip", c.ClientIP(), "requestId", requestid.Get(c))
+			if method == "client_secret_basic" {
+				c.Header("WWW-Authenticate", `Basic realm="service-account"`)
+			}
+			common.TokenErr(c, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+			return
+		}
+		h.serverErr(c, err)
+		return
+	}
+
+	pair, err := h.sessions.IssueServiceAccount(account.ID, account.ClientID)
+	if err != nil {
+		h.serverErr(c, err)
+		return
+	}
+	h.db.Model(account).Update("last_used_at", time.Now())
+
+	h.l.Info("service account token issued", "clientId", account.ClientID, "method", method, "ip", c.ClientIP(), "requestId", requestid.Get(c))
+	c.JSON(http.StatusOK, TokenResp{
+		AccessToken: pair.AccessToken,
+		TokenType:   pair.TokenType,
+		ExpiresIn:   pair.ExpiresIn,
+	})
+}
+
+// errAuthFailed 客户端认证失败，不区分账号不存在、已停用和凭据错误
+var errAuthFailed = errors.New("client authentication failed")
+
+// authenticate 认证服务账号，失败时返回的账号只包含client_id，用于记录日志
+func (h *Handler) authenticate(c *gin.Context) (*ServiceAccount, string, error) {
+	if assertion := c.PostForm("client_assertion"); assertion != "" {
+		return h.authenticateAssertion(c, assertion)
+	}
+
+	method := "client_secret_post"
+	clientID, secret, basic := common.ClientCredentials(c)
+	if basic {
+		method = "client_secret_basic"
+	}
+
+	account, err := h.find(c, clientID)
+	if err != nil {
+		return account, method, err
+	}
+	if !account.VerifySecret(secret) {
+		return account, method, errAuthFailed
+	}
+	return account, method, nil
+}
+
+// authenticateAssertion 校验私钥签名的client_assertion，jti在有效期内只能使用一次
+func (h *Handler) authenticateAssertion(c *gin.Context, assertion string) (*ServiceAccount, string, error) {
+	const method = "private_key_jwt"
+	if c.PostForm("client_assertion_type") != AssertionType {
+		return &ServiceAccount{}, method, errAuthFailed
+	}
+	clientID, err := assertionIssuer(assertion)
+	if err != nil {
+		return &ServiceAccount{}, method, errAuthFailed
+	}
+	// client_id为可选参数，传递时必须与签发者一致
+	if id := c.PostForm("client_id"); id != "" && id != clientID {
+		return &ServiceAccount{ClientID: id}, method, errAuthFailed
+	}
+
+	account, err := h.find(c, clientID)
+	if err != nil {
+		return account, method, err
+	}
+	claims, err := account.verifyAssertion(assertion, h.cfg.RootURL+tokenPath, h.cfg.AssertionTTL)
+	if err != nil {
+		h.l.Debug("invalid client assertion", "clientId", clientID, "err", err)
+		return account, method, errAuthFailed
+	}
+
+	key := fmt.Sprintf("serviceaccount:assertion:%s:%s", common.HashKey(account.ClientID), common.HashKey(claims.ID))
+	ok, err := h.rdb.SetNX(c, key, 1, time.Until(claims.ExpiresAt.Time)+time.Minute).Result()
+	if err != nil {
+		return account, method, err
+	}
+	if !ok {
+		h.l.Warn("client assertion replayed", "clientId", clientID, "jti", claims.ID)
+		return account, method, errAuthFailed
+	}
+	return account, method, nil
+}
+
+// find 查询启用的服务账号
+func (h *Handler) find(ctx context.Context, clientID string) (*ServiceAccount, error) {
+	account := &ServiceAccount{ClientID: clientID}
+	if clientID == "" {
+		return account, errAuthFailed
+	}
+	var found ServiceAccount
+	err := h.db.WithContext(ctx).Where("client_id = ?", clientID).First(&found).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return account, errAuthFailed
+	}
+	if err != nil {
+		return account, err
+	}
+	if !found.Enabled {
+		return &found, errAuthFailed
+	}
+	return &found, nil
+}
+
+func (h *Handler) serverErr(c *gin.Context, err error) {
+	h.l.Error("service account token request failed", "err", err, "requestId", requestid.Get(c))
+	common.TokenErr(c, http.StatusInternalServerError, "server_error", "")
+}
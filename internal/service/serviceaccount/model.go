@@ -0,0 +1,84 @@
+package serviceaccount
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"github.com/z876730060/auth/internal/service/common"
+	"gorm.io/gorm"
+)
+
+// ErrNotFound 服务账号不存在或已停用
+var ErrNotFound = errors.New("service account not found or disabled")
+
+// ServiceAccount 微服务等机器调用方的身份，与用户账号分开，不能交互式登录
+type ServiceAccount struct {
+	gorm.Model
+	ClientID    string `json:"clientId" gorm:"size:64;uniqueIndex"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	// SecretHash 客户端密钥为高熵随机值，只保存sha256，为空时不能使用密钥认证
+	SecretHash string `json:"-"`
+	// PublicKey PEM格式的公钥，用于校验私钥签名的client_assertion，为空时不能使用密钥对认证
+	PublicKey  string     `json:"publicKey" gorm:"type:text"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+func (ServiceAccount) TableName() string {
+	return "service_account"
+}
+
+// HasSecret 是否已生成客户端密钥
+func (a *ServiceAccount) HasSecret() bool {
+	return a.SecretHash != ""
+}
+
+// VerifySecret 校验客户端密钥
+func (a *ServiceAccount) VerifySecret(secret string) bool {
+	if a.SecretHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(a.SecretHash), []byte(common.HashKey(secret))) == 1
+}
+
+// AccountRole 服务账号绑定的角色
+type AccountRole struct {
+	gorm.Model
+	ServiceAccountID uint `json:"serviceAccountId" gorm:"index"`
+	RoleID           uint `json:"roleId"`
+}
+
+func (AccountRole) TableName() string {
+	return "service_account_role"
+}
+
+// Resolve 查询启用的服务账号及其角色，供认证中间件使用
+func Resolve(ctx context.Context, db *gorm.DB, id uint) (*ServiceAccount, []uint, error) {
+	var a ServiceAccount
+	err := db.WithContext(ctx).Where("id = ? AND enabled = ?", id, true).First(&a).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	roles, err := roleIDs(db.WithContext(ctx), id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &a, roles, nil
+}
+
+func roleIDs(db *gorm.DB, id uint) ([]uint, error) {
+	roles := make([]uint, 0)
+	err := db.Model(&AccountRole{}).Where("service_account_id = ?", id).Order("role_id").Pluck("role_id", &roles).Error
+	return roles, err
+}
+
+func InitServiceAccountTable(db *gorm.DB) {
+	db.AutoMigrate(&ServiceAccount{})
+	db.AutoMigrate(&AccountRole{})
+}
@@ -0,0 +1,38 @@
+package session
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TokenFromRequest 优先读取Authorization请求头，其次读取access token cookie
+func (s *Store) TokenFromRequest(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); header != "" {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	if s.cfg.Cookie.Name == "" {
+		return ""
+	}
+	token, _ := c.Cookie(s.cfg.Cookie.Name)
+	return token
+}
+
+// SetCookie 登录成功后写入access token cookie，未配置cookie名称时不处理
+func (s *Store) SetCookie(c *gin.Context, pair *TokenPair) {
+	if s.cfg.Cookie.Name == "" {
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(s.cfg.Cookie.Name, pair.AccessToken, int(pair.ExpiresIn), s.cfg.Cookie.Path, s.cfg.Cookie.Domain, s.cfg.Cookie.Secure, true)
+}
+
+// ClearCookie 退出登录时清除access token cookie
+func (s *Store) ClearCookie(c *gin.Context) {
+	if s.cfg.Cookie.Name == "" {
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(s.cfg.Cookie.Name, "", -1, s.cfg.Cookie.Path, s.cfg.Cookie.Domain, s.cfg.Cookie.Secure, true)
+}
@@ -0,0 +1,161 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// DeviceFromRequest 读取发起登录请求的客户端信息
+func DeviceFromRequest(c *gin.Context) Device {
+	ua := c.Request.UserAgent()
+	if len(ua) > 512 {
+		ua = ua[:512]
+	}
+	return Device{UserAgent: ua, IP: c.ClientIP()}
+}
+
+// Touch 记录会话的最近活动时间和IP，由认证中间件在每次请求时调用
+func (s *Store) Touch(ctx context.Context, sid, ip string) error {
+	key := fmt.Sprintf(activityKey, sid)
+	_, err := s.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, key, "at", time.Now().Unix(), "ip", ip)
+		p.Expire(ctx, key, s.cfg.RefreshTTL)
+		return nil
+	})
+	return err
+}
+
+// List 用户的在线会话，按创建时间倒序
+func (s *Store) List(ctx context.Context, userID uint) ([]Info, error) {
+	entries, stale, err := families(ctx, s.rdb, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(stale) > 0 {
+		s.rdb.SRem(ctx, fmt.Sprintf(userFamilyKey, userID), stale...)
+	}
+
+	pipe := s.rdb.Pipeline()
+	activity := make([]*redis.MapStringStringCmd, len(entries))
+	ttl := make([]*redis.DurationCmd, len(entries))
+	for i, e := range entries {
+		activity[i] = pipe.HGetAll(ctx, fmt.Sprintf(activityKey, e.id))
+		ttl[i] = pipe.TTL(ctx, fmt.Sprintf(familyKey, e.id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	now := time.Now()
+	sessions := make([]Info, 0, len(entries))
+	for i, e := range entries {
+		info := Info{
+			ID:           e.id,
+			ClientID:     e.ClientID,
+			UserAgent:    e.UserAgent,
+			IP:           e.IP,
+			CreatedAt:    e.CreatedAt,
+			LastActiveAt: e.CreatedAt,
+			LastIP:       e.IP,
+			ExpiresAt:    now.Add(ttl[i].Val()),
+		}
+		if at, err := strconv.ParseInt(activity[i].Val()["at"], 10, 64); err == nil {
+			info.LastActiveAt = time.Unix(at, 0)
+			info.LastIP = activity[i].Val()["ip"]
+		}
+		sessions = append(sessions, info)
+	}
+	slices.SortFunc(sessions, func(a, b Info) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return sessions, nil
+}
+
+// RevokeSession 结束用户的单个会话，该会话签发的access token和refresh token立即失效
+func (s *Store) RevokeSession(ctx context.Context, userID uint, sid string) error {
+	ok, err := s.rdb.SIsMember(ctx, fmt.Sprintf(userFamilyKey, userID), sid).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrSessionNotFound
+	}
+	_, err = s.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		delFamily(ctx, p, userID, sid)
+		return nil
+	})
+	return err
+}
+
+// familyEntry 用户集合中仍然有效的会话
+type familyEntry struct {
+	id string
+	*family
+}
+
+// families 读取用户的所有有效会话，同时返回集合中已过期的会话ID
+func families(ctx context.Context, c redis.Cmdable, userID uint) ([]familyEntry, []any, error) {
+	fids, err := c.SMembers(ctx, fmt.Sprintf(userFamilyKey, userID)).Result()
+	if err != nil || len(fids) == 0 {
+		return nil, nil, err
+	}
+	keys := make([]string, len(fids))
+	for i, fid := range fids {
+		keys[i] = fmt.Sprintf(familyKey, fid)
+	}
+	values, err := c.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := make([]familyEntry, 0, len(fids))
+	var stale []any
+	for i, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			stale = append(stale, fids[i])
+			continue
+		}
+		var f family
+		if err := json.Unmarshal([]byte(data), &f); err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, familyEntry{id: fids[i], family: &f})
+	}
+	return entries, stale, nil
+}
+
+// overflow 按会话上限计算需要结束的登录会话，策略为拒绝时返回ErrSessionLimit
+func (s *Store) overflow(ctx context.Context, c redis.Cmdable, userID uint) ([]string, error) {
+	entries, _, err := families(ctx, c, userID)
+	if err != nil {
+		return nil, err
+	}
+	entries = slices.DeleteFunc(entries, func(e familyEntry) bool {
+		return e.ClientID != ""
+	})
+	n := len(entries) - s.cfg.MaxSessions + 1
+	if n <= 0 {
+		return nil, nil
+	}
+	if s.cfg.OverflowPolicy == PolicyReject {
+		return nil, ErrSessionLimit
+	}
+
+	slices.SortFunc(entries, func(a, b familyEntry) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	evict := make([]string, 0, n)
+	for _, e := range entries[:n] {
+		evict = append(evict, e.id)
+	}
+	return evict, nil
+}
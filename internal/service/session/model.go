@@ -0,0 +1,106 @@
+package session
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrInvalidRefreshToken refresh token不存在、已过期或所属会话已撤销
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused 已使用过的refresh token被再次提交
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrSessionLimit 已达到同时在线的会话上限，且策略为拒绝新登录
+	ErrSessionLimit = errors.New("too many active sessions")
+	// ErrSessionNotFound 会话不存在、已过期或不属于该用户
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// 超过会话上限时的处理策略
+const (
+	// PolicyKickOldest 结束最早创建的会话
+	PolicyKickOldest = "kick_oldest"
+	// PolicyReject 拒绝新的登录
+	PolicyReject = "reject"
+)
+
+// Config 令牌有效期配置
+type Config struct {
+	AccessTTL  time.Duration `json:"accessttl"`
+	RefreshTTL time.Duration `json:"refreshttl"`
+	Cookie     CookieConfig  `json:"cookie"`
+	// MaxSessions 每个用户同时在线的登录会话上限，0为不限制，OAuth2客户端的会话不计入
+	MaxSessions int `json:"maxsessions"`
+	// OverflowPolicy 超过上限时的处理策略: kick_oldest 或 reject
+	OverflowPolicy string `json:"overflowpolicy"`
+}
+
+// CookieConfig 浏览器跳转场景（如OAuth2授权页）使用的access token cookie，Name为空时不设置
+type CookieConfig struct {
+	Name   string `json:"name"`
+	Domain string `json:"domain"`
+	Path   string `json:"path"`
+	Secure bool   `json:"secure"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.AccessTTL == 0 {
+		c.AccessTTL = 15 * time.Minute
+	}
+	if c.RefreshTTL == 0 {
+		c.RefreshTTL = 7 * 24 * time.Hour
+	}
+	if c.Cookie.Path == "" {
+		c.Cookie.Path = "/"
+	}
+	if c.OverflowPolicy == "" {
+		c.OverflowPolicy = PolicyKickOldest
+	}
+	return c
+}
+
+// TokenPair 登录或刷新后返回给前端的令牌
+type TokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int64  `json:"expiresIn"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// Grant 签发令牌的授权信息，ClientID不为空表示通过OAuth2客户端授权
+type Grant struct {
+	UserID   uint     `json:"userId"`
+	Username string   `json:"username"`
+	Roles    []string `json:"roles,omitempty"`
+	ClientID string   `json:"clientId,omitempty"`
+	Scope    string   `json:"scope,omitempty"`
+}
+
+// Device 创建会话的客户端信息
+type Device struct {
+	UserAgent string `json:"userAgent"`
+	IP        string `json:"ip"`
+}
+
+// Info 用户的在线会话，ID与access token中的sid一致
+type Info struct {
+	ID           string    `json:"id"`
+	ClientID     string    `json:"clientId,omitempty"`
+	UserAgent    string    `json:"userAgent"`
+	IP           string    `json:"ip"`
+	CreatedAt    time.Time `json:"createdAt"`
+	LastActiveAt time.Time `json:"lastActiveAt"`
+	LastIP       string    `json:"lastIp"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	// Current 是否为发起请求的会话
+	Current bool `json:"current"`
+}
+
+// family 同一次登录产生的refresh token族，即一个会话，Current为当前唯一可用token的哈希
+type family struct {
+	Grant
+	Device
+	Current   string    `json:"current"`
+	CreatedAt time.Time `json:"createdAt"`
+}
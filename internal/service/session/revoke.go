@@ -0,0 +1,144 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/common"
+)
+
+const (
+	revokedTokenKey = "jwt:revoked:%s"
+	// userRevokedAtKey 用户最近一次撤销全部令牌的时间，单位毫秒
+	userRevokedAtKey = "jwt:user:%d:revoked"
+	// userAllowKey 与撤销时间处于同一秒、但在撤销之后签发的令牌ID
+	userAllowKey = "jwt:user:%d:allow"
+)
+
+// allowScript 令牌签发时间与撤销时间在同一秒且晚于撤销时间时，把令牌ID加入白名单
+// iat只精确到秒，白名单用于区分同一秒内撤销前后签发的令牌
+var allowScript = redis.NewScript(`
+local at = tonumber(redis.call('GET', KEYS[1]))
+if at and math.floor(at / 1000) == tonumber(ARGV[1]) and at < tonumber(ARGV[2]) then
+	redis.call('SADD', KEYS[2], ARGV[3])
+	redis.call('PEXPIRE', KEYS[2], ARGV[4])
+end
+return 0
+`)
+
+var (
+	// ErrInvalidToken access token签名无效或已过期
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenRevoked access token已被撤销
+	ErrTokenRevoked = errors.New("token revoked")
+)
+
+// Authenticate 校验access token的签名、有效期及撤销状态
+func (s *Store) Authenticate(ctx context.Context, token string) (*common.CompatibleClaims, error) {
+	claims, err := common.ValidateJavaJWT(token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := s.Check(ctx, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Check 校验access token是否已被撤销
+// 依次检查令牌ID、用户级的撤销时间以及所属会话是否仍然存在
+func (s *Store) Check(ctx context.Context, claims *common.CompatibleClaims) error {
+	pipe := s.rdb.Pipeline()
+	revoked := pipe.Exists(ctx, fmt.Sprintf(revokedTokenKey, claims.ID))
+	revokedAt := pipe.Get(ctx, fmt.Sprintf(userRevokedAtKey, claims.UserID))
+	allowed := pipe.SIsMember(ctx, fmt.Sprintf(userAllowKey, claims.UserID), claims.ID)
+	var alive *redis.IntCmd
+	if claims.SessionID != "" {
+		alive = pipe.Exists(ctx, fmt.Sprintf(familyKey, claims.SessionID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	if revoked.Val() > 0 {
+		return ErrTokenRevoked
+	}
+	if alive != nil && alive.Val() == 0 {
+		return ErrTokenRevoked
+	}
+	if at, err := strconv.ParseInt(revokedAt.Val(), 10, 64); err == nil {
+		if claims.IssuedAt == nil {
+			return ErrTokenRevoked
+		}
+		iat, sec := claims.IssuedAt.Unix(), at/1000
+		if iat < sec || iat == sec && !allowed.Val() {
+			return ErrTokenRevoked
+		}
+	}
+	return nil
+}
+
+// allow 签发用户令牌时调用，撤销后同一秒内签发的令牌加入白名单
+func (s *Store) allow(ctx context.Context, p redis.Pipeliner, claims *common.CompatibleClaims, issuedAt time.Time) {
+	keys := []string{fmt.Sprintf(userRevokedAtKey, claims.UserID), fmt.Sprintf(userAllowKey, claims.UserID)}
+	allowScript.Eval(ctx, p, keys, issuedAt.Unix(), issuedAt.UnixMilli(), claims.ID, s.cfg.AccessTTL.Milliseconds())
+}
+
+// RevokeToken 撤销单个access token，并结束其所属会话
+func (s *Store) RevokeToken(ctx context.Context, claims *common.CompatibleClaims) error {
+	ttl := time.Minute
+	if claims.ExpiresAt != nil {
+		ttl = time.Until(claims.ExpiresAt.Time)
+	}
+	_, err := s.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		if ttl > 0 {
+			p.Set(ctx, fmt.Sprintf(revokedTokenKey, claims.ID), 1, ttl)
+		}
+		if claims.SessionID != "" {
+			delFamily(ctx, p, claims.UserID, claims.SessionID)
+		}
+		return nil
+	})
+	return err
+}
+
+// OnRevokeUser 注册撤销用户全部令牌时同时执行的撤销操作，只在初始化时调用
+func (s *Store) OnRevokeUser(fn func(ctx context.Context, userID uint) error) {
+	s.revokers = append(s.revokers, fn)
+}
+
+// RevokeUser 撤销用户在此之前签发的所有令牌、会话以及注册的其他凭据
+func (s *Store) RevokeUser(ctx context.Context, userID uint) error {
+	fids, err := s.rdb.SMembers(ctx, fmt.Sprintf(userFamilyKey, userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, fmt.Sprintf(userRevokedAtKey, userID), time.Now().UnixMilli(), s.notBeforeTTL())
+		p.Del(ctx, fmt.Sprintf(userAllowKey, userID))
+		for _, fid := range fids {
+			p.Del(ctx, fmt.Sprintf(familyKey, fid), fmt.Sprintf(activityKey, fid))
+		}
+		p.Del(ctx, fmt.Sprintf(userFamilyKey, userID))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, revoke := range s.revokers {
+		if err := revoke(ctx, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notBeforeTTL 用户级撤销时间需要保留到此前签发的令牌全部过期
+func (s *Store) notBeforeTTL() time.Duration {
+	return max(s.cfg.AccessTTL, s.cfg.RefreshTTL, 24*time.Hour)
+}
@@ -0,0 +1,296 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/common"
+)
+
+const (
+	refreshTokenKey = "refresh:token:%s"
+	familyKey       = "refresh:family:%s"
+	userFamilyKey   = "refresh:user:%d"
+	activityKey     = "refresh:family:%s:active"
+)
+
+// Store 基于redis的会话存储，负责签发access token和轮换refresh token
+type Store struct {
+	rdb *redis.Client
+	cfg Config
+	// revokers 撤销用户全部令牌时同时撤销的其他凭据，如个人访问令牌
+	revokers []func(ctx context.Context, userID uint) error
+}
+
+func NewStore(rdb *redis.Client, cfg Config) *Store {
+	return &Store{rdb: rdb, cfg: cfg.withDefaults()}
+}
+
+// Issue 登录成功后创建新的令牌族并签发令牌
+func (s *Store) Issue(ctx context.Context, userID uint, username string, device Device) (*TokenPair, error) {
+	return s.IssueGrant(ctx, Grant{UserID: userID, Username: username}, device)
+}
+
+// IssueGrant 按授权信息创建新的令牌族并签发令牌
+// 登录会话超过上限时按策略结束最早的会话或返回ErrSessionLimit
+func (s *Store) IssueGrant(ctx context.Context, grant Grant, device Device) (*TokenPair, error) {
+	f := &family{
+		Grant:     grant,
+		Device:    device,
+		CreatedAt: time.Now(),
+	}
+	if s.cfg.MaxSessions <= 0 || grant.ClientID != "" {
+		return s.issue(ctx, s.rdb, newID(), f)
+	}
+
+	// 监视用户的会话集合，并发登录时重新统计
+	var pair *TokenPair
+	var err error
+	for range 3 {
+		err = s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			evict, err := s.overflow(ctx, tx, grant.UserID)
+			if err != nil {
+				return err
+			}
+			pair, err = s.issue(ctx, tx, newID(), f, evict...)
+			return err
+		}, fmt.Sprintf(userFamilyKey, grant.UserID))
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// IssueClient 签发client_credentials模式的access token，不包含refresh token
+func (s *Store) IssueClient(clientID, scope string) (*TokenPair, error) {
+	claims := common.NewCompatibleClaims(0, "", []string{}, s.cfg.AccessTTL)
+	claims.Subject = clientID
+	claims.ClientID = clientID
+	claims.Scope = scope
+	accessToken, err := common.SignClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.cfg.AccessTTL.Seconds()),
+		Scope:       scope,
+	}, nil
+}
+
+// IssueServiceAccount 签发服务账号的access token，不包含refresh token，到期后重新获取
+func (s *Store) IssueServiceAccount(id uint, clientID string) (*TokenPair, error) {
+	claims := common.NewCompatibleClaims(0, clientID, []string{}, s.cfg.AccessTTL)
+	claims.Subject = clientID
+	claims.ServiceAccountID = id
+	accessToken, err := common.SignClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.cfg.AccessTTL.Seconds()),
+	}, nil
+}
+
+// Refresh 使用refresh token换取新的令牌，旧token立即失效
+// 已失效的token被再次使用时撤销整个令牌族
+func (s *Store) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	return s.RefreshClient(ctx, refreshToken, "")
+}
+
+// RefreshClient 校验refresh token属于指定的OAuth2客户端后换取新的令牌
+// clientID为空时只接受登录接口签发的refresh token
+func (s *Store) RefreshClient(ctx context.Context, refreshToken, clientID string) (*TokenPair, error) {
+	hash := hashToken(refreshToken)
+	fid, err := s.rdb.Get(ctx, fmt.Sprintf(refreshTokenKey, hash)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var pair *TokenPair
+	err = s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		f, err := getFamily(ctx, tx, fid)
+		if err != nil {
+			return err
+		}
+		if f.ClientID != clientID {
+			return ErrInvalidRefreshToken
+		}
+		if f.Current != hash {
+			if err := tx.Del(ctx, fmt.Sprintf(familyKey, fid), fmt.Sprintf(activityKey, fid)).Err(); err != nil {
+				return err
+			}
+			return ErrRefreshTokenReused
+		}
+		pair, err = s.issue(ctx, tx, fid, f)
+		return err
+	}, fmt.Sprintf(familyKey, fid))
+	if errors.Is(err, redis.TxFailedErr) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// Lookup 查询refresh token对应的授权信息及过期时间，已轮换的token视为无效
+func (s *Store) Lookup(ctx context.Context, refreshToken string) (*Grant, time.Time, error) {
+	hash := hashToken(refreshToken)
+	fid, err := s.rdb.Get(ctx, fmt.Sprintf(refreshTokenKey, hash)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, time.Time{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	f, err := getFamily(ctx, s.rdb, fid)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if f.Current != hash {
+		return nil, time.Time{}, ErrInvalidRefreshToken
+	}
+	ttl, err := s.rdb.TTL(ctx, fmt.Sprintf(familyKey, fid)).Result()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return &f.Grant, time.Now().Add(ttl), nil
+}
+
+// RevokeRefresh 撤销refresh token所属的令牌族，该会话签发的access token同时失效
+// clientID必须与签发时的客户端一致
+func (s *Store) RevokeRefresh(ctx context.Context, refreshToken, clientID string) error {
+	fid, err := s.rdb.Get(ctx, fmt.Sprintf(refreshTokenKey, hashToken(refreshToken))).Result()
+	if errors.Is(err, redis.Nil) {
+		return ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+	f, err := getFamily(ctx, s.rdb, fid)
+	if err != nil {
+		return err
+	}
+	if f.ClientID != clientID {
+		return ErrInvalidRefreshToken
+	}
+	_, err = s.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		delFamily(ctx, p, f.UserID, fid)
+		return nil
+	})
+	return err
+}
+
+// issue 为令牌族生成新的refresh token并签发access token，同时结束evict中的会话
+func (s *Store) issue(ctx context.Context, c redis.Cmdable, fid string, f *family, evict ...string) (*TokenPair, error) {
+	refreshToken, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	hash := hashToken(refreshToken)
+	f.Current = hash
+
+	roles := f.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+	now := time.Now()
+	claims := common.NewCompatibleClaims(f.UserID, f.Username, roles, s.cfg.AccessTTL)
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.SessionID = fid
+	claims.ClientID = f.ClientID
+	claims.Scope = f.Scope
+	accessToken, err := common.SignClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		for _, id := range evict {
+			delFamily(ctx, p, f.UserID, id)
+		}
+		p.Set(ctx, fmt.Sprintf(familyKey, fid), data, s.cfg.RefreshTTL)
+		p.Set(ctx, fmt.Sprintf(refreshTokenKey, hash), fid, s.cfg.RefreshTTL)
+		p.SAdd(ctx, fmt.Sprintf(userFamilyKey, f.UserID), fid)
+		p.Expire(ctx, fmt.Sprintf(userFamilyKey, f.UserID), s.cfg.RefreshTTL)
+		s.allow(ctx, p, &claims, now)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.cfg.AccessTTL.Seconds()),
+		Scope:        f.Scope,
+	}, nil
+}
+
+// delFamily 删除会话及其活动记录，该会话签发的access token随之失效
+func delFamily(ctx context.Context, p redis.Pipeliner, userID uint, fid string) {
+	p.Del(ctx, fmt.Sprintf(familyKey, fid), fmt.Sprintf(activityKey, fid))
+	p.SRem(ctx, fmt.Sprintf(userFamilyKey, userID), fid)
+}
+
+func getFamily(ctx context.Context, c redis.Cmdable, fid string) (*family, error) {
+	data, err := c.Get(ctx, fmt.Sprintf(familyKey, fid)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f family
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// newToken 生成不透明的随机令牌
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// hashToken redis中只保存令牌的哈希
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -8,7 +8,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/authz"
 	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/session"
 	"gorm.io/gorm"
 )
 
@@ -16,21 +18,26 @@ type Handler struct {
 	l           *slog.Logger
 	db          *gorm.DB
 	redisClient *redis.Client
+	sessions    *session.Store
+	authz       authz.Authorizer
 	info        common.Info
 }
 
-func NewHandler(l *slog.Logger, db *gorm.DB, redisClient *redis.Client, info common.Info) *Handler {
-	return &Handler{l: l, db: db, redisClient: redisClient, info: info}
+func NewHandler(l *slog.Logger, db *gorm.DB, redisClient *redis.Client, sessions *session.Store, authorizer authz.Authorizer, info common.Info) *Handler {
+	return &Handler{l: l, db: db, redisClient: redisClient, sessions: sessions, authz: authorizer, info: info}
 }
 
 func (h *Handler) Register(e *gin.Engine) {
-	e.POST("/user/list", h.List)
-	e.POST("/user", h.Add)
-	e.DELETE("/user/:id", h.Del)
-	e.GET("/user/:id", h.GetDetail)
-	e.PUT("/user", h.Update)
-	e.POST("/user/role", h.BindRole)
-	e.GET("/user/role/:id", h.GetRole)
+	e.POST("/user/list", authz.RequirePermission(h.authz, h.info, common.PermUserList), h.List)
+	e.POST("/user", authz.RequirePermission(h.authz, h.info, common.PermUserCreate), h.Add)
+	e.DELETE("/user/:id", authz.RequirePermission(h.authz, h.info, common.PermUserDelete), h.Del)
+	e.GET("/user/:id", authz.RequirePermission(h.authz, h.info, common.PermUserList), h.GetDetail)
+	e.PUT("/user", authz.RequirePermission(h.authz, h.info, common.PermUserUpdate), h.Update)
+	e.POST("/user/role", authz.RequirePermission(h.authz, h.info, common.PermUserUpdate), h.BindRole)
+	e.GET("/user/role/:id", authz.RequirePermission(h.authz, h.info, common.PermUserList), h.GetRole)
+	e.POST("/user/:id/revoke", authz.RequirePermission(h.authz, h.info, common.PermUserRevoke), h.Revoke)
+	e.GET("/user/:id/sessions", authz.RequirePermission(h.authz, h.info, common.PermUserList), h.Sessions)
+	e.DELETE("/user/:id/sessions/:sid", authz.RequirePermission(h.authz, h.info, common.PermUserRevoke), h.RevokeSession)
 }
 
 func (h *Handler) List(c *gin.Context) {
@@ -49,7 +56,7 @@ func (h *Handler) List(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	h.l.Info("reqBody: %+v", rbody)
+	h.l.Debug("List user", "page", rbody.Page.Page, "size", rbody.Size)
 
 	var datas []User
 	var count int64
@@ -74,6 +81,9 @@ func (h *Handler) List(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	for i := range datas {
+		datas[i].Password = ""
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"data": gin.H{
@@ -99,17 +109,38 @@ func (h *Handler) Add(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.Create(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrDuplicatedKey) {
+	// 管理接口只能创建本地账号
+	user.Source = ""
+
+	// 校验密码是否为空
+	if user.Password == "" {
+		c.JSON(http.StatusBadRequest, common.RespErr("password cannot be empty", h.info))
+		return
+	}
+
+	// 管理员设置的初始密码，用户首次登录时必须修改
+	plain := user.Password
+	user.Password = ""
+	user.PasswordChangedAt = nil
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return ChangePassword(tx, &user, plain, true)
+	})
+	if err != nil {
+		switch {
+		case IsPasswordPolicyErr(err):
+			c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+		case errors.Is(err, gorm.ErrDuplicatedKey):
 			c.JSON(http.StatusConflict, common.RespErr("username already exists", h.info))
-			return
-		} else {
+		default:
 			c.JSON(http.StatusInternalServerError, common.RespErr("create user failed", h.info))
-			return
 		}
+		return
 	}
 
-	h.l.Info("Add user: %+v", user)
+	h.l.Info("Add user", "id", user.ID, "username", user.Username)
 
 	c.JSON(http.StatusOK, common.RespOk("create user success", nil, h.info))
 }
@@ -123,22 +154,36 @@ func (h *Handler) Del(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.Delete(&User{Model: gorm.Model{ID: uid}}).Error; err != nil {
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkSuperAdminChange(tx, uid, nil, c.GetUintSlice("role")); err != nil {
+			return err
+		}
+		return tx.Delete(&User{Model: gorm.Model{ID: uid}}).Error
+	})
+	if h.superAdminErr(c, err) {
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, common.RespErr("delete user failed", h.info))
 		return
 	}
 
+	// 撤销已删除用户的令牌
+	if err := h.sessions.RevokeUser(c, uid); err != nil {
+		h.l.Error("revoke user tokens failed", "userId", uid, "err", err)
+	}
+
 	c.JSON(http.StatusOK, common.RespOk("delete user success", nil, h.info))
 }
 
 func (h *Handler) Update(c *gin.Context) {
 	var user User
-	if err := c.ShouldBindJSON(&user); err != nil {
+	if err := c.ShouldBindJSON(&user); err != nil || user.ID == 0 {
 		c.JSON(http.StatusBadRequest, common.RespErr("invalid request body", h.info))
 		return
 	}
 
-	h.l.Info("Update user: %+v", user)
+	h.l.Info("Update user", "id", user.ID, "username", user.Username)
 
 	// 校验用户名是否存在
 	var count int64
@@ -148,17 +193,54 @@ func (h *Handler) Update(c *gin.Context) {
 		return
 	}
 
-	// 校验密码是否为空
-	if user.Password == "" {
-		c.JSON(http.StatusBadRequest, common.RespErr("password cannot be empty", h.info))
+	// 密码为空时保留原密码，否则重新生成哈希
+	var old User
+	if err := h.db.First(&old, user.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr("user not found", h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr("update user failed", h.info))
 		return
 	}
-
-	if err := h.db.Save(&user).Error; err != nil {
+	// 账号来源不允许修改
+	user.Source = old.Source
+	// 密码相关字段只能通过设置新密码修改，管理员设置的新密码用户下次登录时必须修改
+	plain := user.Password
+	user.Password = old.Password
+	user.MustChangePassword = old.MustChangePassword
+	user.PasswordChangedAt = old.PasswordChangedAt
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := CheckSuperAdminTarget(tx, user.ID, c.GetUintSlice("role")); err != nil {
+			return err
+		}
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		if plain == "" {
+			return nil
+		}
+		return ChangePassword(tx, &user, plain, true)
+	})
+	if h.superAdminErr(c, err) {
+		return
+	}
+	if err != nil {
+		if IsPasswordPolicyErr(err) {
+			c.JSON(http.StatusBadRequest, common.RespErr(err.Error(), h.info))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, common.RespErr("update user failed", h.info))
 		return
 	}
 
+	// 管理员重置密码后撤销该用户已签发的令牌
+	if plain != "" {
+		if err := h.sessions.RevokeUser(c, user.ID); err != nil {
+			h.l.Error("revoke user tokens failed", "userId", user.ID, "err", err)
+		}
+	}
+
 	c.JSON(http.StatusOK, common.RespOk("update user success", nil, h.info))
 }
 
@@ -176,6 +258,7 @@ func (h *Handler) GetDetail(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, common.RespErr("get user detail failed", h.info))
 		return
 	}
+	user.Password = ""
 
 	c.JSON(http.StatusOK, common.RespOk("get user detail success", user, h.info))
 }
@@ -196,10 +279,28 @@ func (h *Handler) BindRole(c *gin.Context) {
 		return
 	}
 
-	h.l.Info("BindRole reqBody: %+v", reqBody)
+	roleIDs := make([]uint, 0, len(reqBody.RoleKeys))
+	for _, roleKey := range reqBody.RoleKeys {
+		roleID, err := common.ParseID(roleKey)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, common.RespErr("invalid role key", h.info))
+			return
+		}
+		roleIDs = append(roleIDs, roleID)
+	}
+
+	h.l.Info("BindRole", "userId", uid, "roleIds", roleIDs, common.Operator(c))
 	tx := h.db.Begin()
 	defer tx.Rollback()
 
+	if err := checkSuperAdminChange(tx, uid, roleIDs, c.GetUintSlice("role")); err != nil {
+		if h.superAdminErr(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr("bind role failed", h.info))
+		return
+	}
+
 	// 删除用户角色
 	if err := tx.Where(UserRole{UserID: uid}).Delete(&UserRole{}).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, common.RespErr("bind role failed", h.info))
@@ -207,12 +308,7 @@ func (h *Handler) BindRole(c *gin.Context) {
 	}
 
 	// 绑定用户角色
-	for _, roleKey := range reqBody.RoleKeys {
-		roleID, err := common.ParseID(roleKey)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, common.RespErr("invalid role key", h.info))
-			return
-		}
+	for _, roleID := range roleIDs {
 		if err := tx.Create(&UserRole{UserID: uid, RoleID: roleID}).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, common.RespErr("bind role failed", h.info))
 			return
@@ -220,6 +316,12 @@ func (h *Handler) BindRole(c *gin.Context) {
 	}
 
 	tx.Commit()
+
+	// 角色变更后令牌立即失效，用户需重新登录
+	if err := h.sessions.RevokeUser(c, uid); err != nil {
+		h.l.Error("revoke user tokens failed", "userId", uid, "err", err)
+	}
+
 	c.JSON(http.StatusOK, common.RespOk("bind role success", nil, h.info))
 }
 
@@ -246,3 +348,86 @@ func (h *Handler) GetRole(c *gin.Context) {
 
 	c.JSON(http.StatusOK, common.RespOk("get role success", roleKeys, h.info))
 }
+
+// Revoke 管理员强制撤销用户的所有令牌
+func (h *Handler) Revoke(c *gin.Context) {
+	id := c.Param("id")
+
+	uid, err := common.ParseID(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid id", h.info))
+		return
+	}
+
+	if err := CheckSuperAdminTarget(h.db, uid, c.GetUintSlice("role")); err != nil {
+		if !h.superAdminErr(c, err) {
+			c.JSON(http.StatusInternalServerError, common.RespErr("revoke user tokens failed", h.info))
+		}
+		return
+	}
+	if err := h.sessions.RevokeUser(c, uid); err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr("revoke user tokens failed", h.info))
+		return
+	}
+
+	h.l.Info("Revoke user tokens", "userId", uid, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("revoke user tokens success", nil, h.info))
+}
+
+// Sessions 管理员查看用户的在线会话
+func (h *Handler) Sessions(c *gin.Context) {
+	uid, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid id", h.info))
+		return
+	}
+
+	sessions, err := h.sessions.List(c, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.RespErr("get user sessions failed", h.info))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.RespOk("get user sessions success", sessions, h.info))
+}
+
+// RevokeSession 管理员结束用户的指定会话
+func (h *Handler) RevokeSession(c *gin.Context) {
+	uid, err := common.ParseID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.RespErr("invalid id", h.info))
+		return
+	}
+
+	if err := CheckSuperAdminTarget(h.db, uid, c.GetUintSlice("role")); err != nil {
+		if !h.superAdminErr(c, err) {
+			c.JSON(http.StatusInternalServerError, common.RespErr("revoke user session failed", h.info))
+		}
+		return
+	}
+	sid := c.Param("sid")
+	if err := h.sessions.RevokeSession(c, uid, sid); err != nil {
+		if errors.Is(err, session.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, common.RespErr(err.Error(), h.info))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.RespErr("revoke user session failed", h.info))
+		return
+	}
+
+	h.l.Info("Revoke user session", "userId", uid, "sessionId", sid, common.Operator(c))
+	c.JSON(http.StatusOK, common.RespOk("revoke user session success", nil, h.info))
+}
+
+// superAdminErr 超级管理员校验失败时写入响应并返回true
+func (h *Handler) superAdminErr(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, ErrSuperAdminRequired):
+		c.JSON(http.StatusForbidden, common.RespErr(err.Error(), h.info))
+	case errors.Is(err, ErrLastSuperAdmin):
+		c.JSON(http.StatusConflict, common.RespErr(err.Error(), h.info))
+	default:
+		return false
+	}
+	return true
+}
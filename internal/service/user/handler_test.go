@@ -0,0 +1,151 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/role"
+	"github.com/z876730060/auth/internal/service/session"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type testEnv struct {
+	db     *gorm.DB
+	engine *gin.Engine
+	// admin 初始化创建的超级管理员，operator 只有普通角色的管理员
+	admin, operator User
+	superRole       uint
+	userRole        uint
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	role.InitRoleTable(db)
+	InitUserTable(db)
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	env := &testEnv{db: db}
+	if err := db.Where("username = ?", "admin").First(&env.admin).Error; err != nil {
+		t.Fatal(err)
+	}
+	var roles []role.Role
+	db.Find(&roles)
+	for _, r := range roles {
+		if r.IsSuperAdmin {
+			env.superRole = r.ID
+		} else {
+			env.userRole = r.ID
+		}
+	}
+	env.operator = User{Username: "operator"}
+	db.Create(&env.operator)
+	db.Create(&UserRole{UserID: env.operator.ID, RoleID: env.userRole})
+
+	h := NewHandler(slog.New(slog.DiscardHandler), db, rdb, session.NewStore(rdb, session.Config{}), nil, common.Info{})
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	// 操作人由X-User-Id模拟，角色从数据库读取，跳过接口权限校验
+	e.Use(func(c *gin.Context) {
+		id, _ := common.ParseID(c.GetHeader("X-User-Id"))
+		var roleIDs []uint
+		db.Model(&UserRole{}).Where("user_id = ?", id).Pluck("role_id", &roleIDs)
+		c.Set("userId", id)
+		c.Set("role", roleIDs)
+	})
+	e.PUT("/user", h.Update)
+	e.POST("/user/:id/revoke", h.Revoke)
+	e.DELETE("/user/:id/sessions/:sid", h.RevokeSession)
+	env.engine = e
+	return env
+}
+
+func (env *testEnv) do(t *testing.T, operator uint, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-Id", strconv.FormatUint(uint64(operator), 10))
+	w := httptest.NewRecorder()
+	env.engine.ServeHTTP(w, req)
+	return w
+}
+
+func TestUpdateSuperAdminRequiresSuperAdmin(t *testing.T) {
+	env := newTestEnv(t)
+
+	// 普通管理员不能重置超级管理员的密码
+	w := env.do(t, env.operator.ID, http.MethodPut, "/user", map[string]any{
+		"ID":       env.admin.ID,
+		"username": env.admin.Username,
+		"password": "Takeover-Passw0rd!",
+	})
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusForbidden, w.Body)
+	}
+	var admin User
+	env.db.First(&admin, env.admin.ID)
+	if admin.Password != env.admin.Password {
+		t.Fatal("super admin password changed by non super admin")
+	}
+
+	// 也不能修改超级管理员的资料
+	w = env.do(t, env.operator.ID, http.MethodPut, "/user", map[string]any{
+		"ID":       env.admin.ID,
+		"username": env.admin.Username,
+		"email":    "attacker@example.com",
+	})
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusForbidden, w.Body)
+	}
+	env.db.First(&admin, env.admin.ID)
+	if admin.Email != env.admin.Email {
+		t.Fatal("super admin email changed by non super admin")
+	}
+
+	// 超级管理员可以重置其他超级管理员和普通用户的密码
+	w = env.do(t, env.admin.ID, http.MethodPut, "/user", map[string]any{
+		"ID":       env.operator.ID,
+		"username": env.operator.Username,
+		"password": "Reset-Passw0rd!",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+}
+
+func TestRevokeSuperAdminRequiresSuperAdmin(t *testing.T) {
+	env := newTestEnv(t)
+	id := strconv.FormatUint(uint64(env.admin.ID), 10)
+
+	if w := env.do(t, env.operator.ID, http.MethodPost, "/user/"+id+"/revoke", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("revoke status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := env.do(t, env.operator.ID, http.MethodDelete, "/user/"+id+"/sessions/any", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("revoke session status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := env.do(t, env.admin.ID, http.MethodPost, "/user/"+id+"/revoke", nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
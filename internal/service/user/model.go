@@ -1,7 +1,22 @@
 package user
 
 import (
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/z876730060/auth/internal/service/password"
+	"github.com/z876730060/auth/internal/service/role"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrLastSuperAdmin 操作后系统将没有任何超级管理员用户
+	ErrLastSuperAdmin = errors.New("cannot remove the last super administrator")
+	// ErrSuperAdminRequired 只有超级管理员可以授予、移除超级管理员角色或修改、删除超级管理员
+	ErrSuperAdminRequired = errors.New("only super administrators can manage super administrators")
 )
 
 type User struct {
@@ -11,8 +26,17 @@ type User struct {
 	Fullname string `json:"fullname"`
 	Email    string `json:"email"`
 	Phone    string `json:"phone"`
+	// Source 账号来源，空为本地账号，其余为首次登录时自动创建的外部目录账号
+	Source string `json:"source" gorm:"size:32"`
+	// MustChangePassword 下次登录时必须修改密码，管理员创建或重置密码后设置
+	MustChangePassword bool `json:"mustChangePassword"`
+	// PasswordChangedAt 密码最近修改时间，为空时按创建时间计算密码有效期
+	PasswordChangedAt *time.Time `json:"passwordChangedAt"`
 }
 
+// SourceLDAP LDAP/AD目录同步的账号
+const SourceLDAP = "ldap"
+
 func (u *User) TableName() string {
 	return "user"
 }
@@ -30,6 +54,7 @@ func (u *UserRole) TableName() string {
 func InitUserTable(db *gorm.DB) {
 	db.AutoMigrate(&User{})
 	db.AutoMigrate(&UserRole{})
+	db.AutoMigrate(&PasswordHistory{})
 
 	var count int64
 	db.Model(&User{}).Count(&count)
@@ -37,16 +62,109 @@ func InitUserTable(db *gorm.DB) {
 		return
 	}
 
-	db.Create(&User{
+	hashed, err := password.Hash("123456")
+	if err != nil {
+		slog.Error("hash admin password failed", "err", err)
+		return
+	}
+
+	admin := User{
 		Username: "admin",
-		Password: "123456",
+		Password: hashed,
 		Fullname: "Admin",
 		Email:    "admin@example.com",
 		Phone:    "1234567890",
-	})
+		// 初始密码为公开的默认值，首次登录时必须修改
+		MustChangePassword: true,
+	}
+	db.Create(&admin)
+
+	supers, err := role.SuperAdminRoles(db)
+	if err != nil {
+		slog.Error("query super admin roles failed", "err", err)
+		return
+	}
+	for _, id := range supers {
+		db.Create(&UserRole{
+			UserID: admin.ID,
+			RoleID: id,
+		})
+	}
+}
+
+// CheckSuperAdminTarget 修改用户资料、重置密码、解锁或撤销会话前校验，目标是超级管理员时操作人callerRoles必须也是超级管理员
+func CheckSuperAdminTarget(db *gorm.DB, uid uint, callerRoles []uint) error {
+	supers, err := role.SuperAdminRoles(db)
+	if err != nil || len(supers) == 0 {
+		return err
+	}
+	if slices.ContainsFunc(callerRoles, func(id uint) bool { return slices.Contains(supers, id) }) {
+		return nil
+	}
+	var count int64
+	if err := db.Model(&UserRole{}).Where("user_id = ? AND role_id IN ?", uid, supers).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrSuperAdminRequired
+	}
+	return nil
+}
+
+// CheckRoleSync 外部身份源同步角色前校验，必须在事务中调用
+// 同步没有超级管理员操作人，授予granted或移除revoked中包含超级管理员角色时返回ErrSuperAdminRequired，因此也不会移除最后一个超级管理员
+func CheckRoleSync(tx *gorm.DB, granted, revoked []uint) error {
+	if len(granted) == 0 && len(revoked) == 0 {
+		return nil
+	}
+	supers, err := role.SuperAdminRoles(tx)
+	if err != nil {
+		return err
+	}
+	isSuper := func(id uint) bool { return slices.Contains(supers, id) }
+	if slices.ContainsFunc(granted, isSuper) || slices.ContainsFunc(revoked, isSuper) {
+		return ErrSuperAdminRequired
+	}
+	return nil
+}
+
+// checkSuperAdminChange 用户的角色将变为roleIDs前校验，删除用户时roleIDs为空，必须在事务中调用
+// 涉及超级管理员时操作人callerRoles必须也是超级管理员，并且不能移除最后一个超级管理员
+// 锁定超级管理员角色行，使并发的修改串行执行，避免同时移除不同的超级管理员
+func checkSuperAdminChange(tx *gorm.DB, uid uint, roleIDs, callerRoles []uint) error {
+	supers, err := role.SuperAdminRoles(tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}))
+	if err != nil || len(supers) == 0 {
+		return err
+	}
+	isSuper := func(ids []uint) bool {
+		return slices.ContainsFunc(ids, func(id uint) bool { return slices.Contains(supers, id) })
+	}
 
-	db.Create(&UserRole{
-		UserID: 1,
-		RoleID: 1,
-	})
+	var current []uint
+	if err := tx.Model(&UserRole{}).Where("user_id = ?", uid).Pluck("role_id", &current).Error; err != nil {
+		return err
+	}
+	if !isSuper(current) && !isSuper(roleIDs) {
+		return nil
+	}
+	if !isSuper(callerRoles) {
+		return ErrSuperAdminRequired
+	}
+	if isSuper(roleIDs) {
+		return nil
+	}
+
+	// 已删除的用户不计入
+	var others int64
+	if err := tx.Model(&UserRole{}).
+		Where("role_id IN ?", supers).
+		Where("user_id <> ?", uid).
+		Where("user_id IN (?)", tx.Model(&User{}).Select("id")).
+		Count(&others).Error; err != nil {
+		return err
+	}
+	if others == 0 {
+		return ErrLastSuperAdmin
+	}
+	return nil
 }
@@ -0,0 +1,116 @@
+package user
+
+import (
+	"errors"
+	"time"
+
+	"github.com/z876730060/auth/internal/service/password"
+	"gorm.io/gorm"
+)
+
+// ErrPasswordReused 新密码与最近使用过的密码相同
+var ErrPasswordReused = errors.New("password was used recently")
+
+// PasswordHistory 用户使用过的密码哈希，用于禁止重复使用
+type PasswordHistory struct {
+	gorm.Model
+	UserID   uint   `json:"userId" gorm:"index"`
+	Password string `json:"-"`
+}
+
+func (PasswordHistory) TableName() string {
+	return "user_password_history"
+}
+
+// IsPasswordPolicyErr 是否为密码不满足策略的错误，这类错误应返回给用户
+func IsPasswordPolicyErr(err error) bool {
+	return errors.Is(err, password.ErrWeakPassword) || errors.Is(err, ErrPasswordReused)
+}
+
+// ChangePassword 按密码策略校验并设置已存在用户的密码，同时记录历史密码
+// mustChange为true时用户下次登录必须再次修改，用于管理员设置的密码
+func ChangePassword(db *gorm.DB, u *User, plain string, mustChange bool) error {
+	if err := ValidatePassword(db, u, plain); err != nil {
+		return err
+	}
+	hashed, err := password.Hash(plain)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(u).Updates(map[string]any{
+			"password":             hashed,
+			"must_change_password": mustChange,
+			"password_changed_at":  now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&PasswordHistory{UserID: u.ID, Password: hashed}).Error; err != nil {
+			return err
+		}
+		return trimHistory(tx, u.ID)
+	})
+	if err != nil {
+		return err
+	}
+	u.Password, u.MustChangePassword, u.PasswordChangedAt = hashed, mustChange, &now
+	return nil
+}
+
+// ValidatePassword 校验新密码是否满足密码策略且未被重复使用
+func ValidatePassword(db *gorm.DB, u *User, plain string) error {
+	if err := password.Validate(plain, u.Username); err != nil {
+		return err
+	}
+	return checkReuse(db, u, plain)
+}
+
+// PasswordExpired 本地账号的密码是否超过最长使用时间
+func (u *User) PasswordExpired() bool {
+	if u.Source != "" || u.Password == "" {
+		return false
+	}
+	changedAt := u.CreatedAt
+	if u.PasswordChangedAt != nil {
+		changedAt = *u.PasswordChangedAt
+	}
+	return password.Expired(changedAt)
+}
+
+// checkReuse 新密码不能与当前密码及最近的历史密码相同
+func checkReuse(db *gorm.DB, u *User, plain string) error {
+	size := password.HistorySize()
+	if size <= 0 {
+		return nil
+	}
+	hashes := []string{u.Password}
+	var history []string
+	err := db.Model(&PasswordHistory{}).Where("user_id = ?", u.ID).Order("id DESC").Limit(size).Pluck("password", &history).Error
+	if err != nil {
+		return err
+	}
+	hashes = append(hashes, history...)
+	for _, h := range hashes {
+		if h == "" {
+			continue
+		}
+		if ok, _, err := password.Verify(h, plain); err == nil && ok {
+			return ErrPasswordReused
+		}
+	}
+	return nil
+}
+
+// trimHistory 只保留策略要求的历史密码数量
+func trimHistory(tx *gorm.DB, userID uint) error {
+	size := max(password.HistorySize(), 1)
+	var ids []uint
+	err := tx.Model(&PasswordHistory{}).Where("user_id = ?", userID).Order("id DESC").Pluck("id", &ids).Error
+	if err != nil || len(ids) <= size {
+		return err
+	}
+	return tx.Unscoped().Where("id IN ?", ids[size:]).Delete(&PasswordHistory{}).Error
+}
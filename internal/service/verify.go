@@ -0,0 +1,187 @@
+package service
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/z876730060/auth/internal/service/authz"
+	"github.com/z876730060/auth/internal/service/common"
+	"github.com/z876730060/auth/internal/service/menu"
+	"github.com/z876730060/auth/internal/service/pat"
+	"github.com/z876730060/auth/internal/service/session"
+	"gorm.io/gorm"
+)
+
+// VerifyHandler 网关转发认证（Traefik forwardAuth、nginx auth_request）
+// 认证通过后通过响应头把用户信息传给后端微应用
+type VerifyHandler struct {
+	l        *slog.Logger
+	db       *gorm.DB
+	sessions *session.Store
+	pats     *pat.Service
+	authz    authz.Authorizer
+	cfg      Gateway
+}
+
+func NewVerifyHandler(l *slog.Logger, db *gorm.DB, sessions *session.Store, pats *pat.Service, authorizer authz.Authorizer, cfg Gateway) *VerifyHandler {
+	return &VerifyHandler{l: l, db: db, sessions: sessions, pats: pats, authz: authorizer, cfg: cfg}
+}
+
+func (h *VerifyHandler) Register(e *gin.Engine) {
+	e.Any("/auth/verify", h.Verify)
+}
+
+// Verify 校验令牌，通过返回200并设置X-User-*响应头，否则返回401/403
+func (h *VerifyHandler) Verify(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	claims, roles, status := authenticate(c, h.l, h.sessions, h.pats, forwardedMethod(c))
+	if status != http.StatusOK {
+		c.AbortWithStatus(status)
+		return
+	}
+
+	if h.cfg.CheckMenu {
+		uri := forwardedURI(c)
+		sub := &authz.Subject{
+			UserID:           claims.UserID,
+			ServiceAccountID: claims.ServiceAccountID,
+			Roles:            roles,
+			Attrs:            map[string]string{"username": claims.Username},
+		}
+		allowed, err := h.allowed(c, uri, sub)
+		if err != nil {
+			h.l.Error("check menu permission failed", "err", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			h.l.Warn("forwarded uri not permitted", "userId", claims.UserID, "serviceAccountId", claims.ServiceAccountID, "uri", uri)
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+	}
+
+	ids := make([]string, len(roles))
+	for i, id := range roles {
+		ids[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	// 服务账号的X-User-Id为0，X-Username为client_id，后端可根据X-Principal-Type区分
+	if claims.ServiceAccountID != 0 {
+		c.Header("X-Principal-Type", common.PrincipalServiceAccount)
+		c.Header("X-Service-Account-Id", strconv.FormatUint(uint64(claims.ServiceAccountID), 10))
+	} else {
+		c.Header("X-Principal-Type", common.PrincipalUser)
+	}
+	c.Header("X-User-Id", strconv.FormatUint(uint64(claims.UserID), 10))
+	c.Header("X-Username", claims.Username)
+	c.Header("X-User-Roles", strings.Join(ids, ","))
+	c.Status(http.StatusOK)
+}
+
+// allowed 按最长路径前缀找到URI对应的菜单，由授权引擎判断调用方能否查看该菜单
+// 未对应任何菜单的URI只有配置为公开路径时才允许访问
+func (h *VerifyHandler) allowed(c *gin.Context, uri string, sub *authz.Subject) (bool, error) {
+	if h.cfg.public(uri) {
+		return true, nil
+	}
+	appId := h.cfg.app(forwardedHost(c))
+	query := h.db.Model(&menu.MenuTable{}).Where("path <> ?", "")
+	if appId != "" {
+		query = query.Where("micro_app = ?", appId)
+	}
+	var menus []menu.MenuTable
+	if err := query.Find(&menus).Error; err != nil {
+		return false, err
+	}
+
+	var matched *menu.MenuTable
+	for i := range menus {
+		if matchPath(menus[i].Path, uri) && (matched == nil || len(menus[i].Path) > len(matched.Path)) {
+			matched = &menus[i]
+		}
+	}
+	if matched == nil {
+		return false, nil
+	}
+
+	return h.authz.Authorize(c, &authz.Request{
+		Subject: sub,
+		Domain:  appId,
+		Object:  matched.Key,
+		Action:  authz.ActionView,
+		Env:     authz.NewEnv(c, forwardedMethod(c), uri),
+	})
+}
+
+// app 转发请求的Host对应的微应用key，Host由网关设置，不使用客户端可控的MicroAppId请求头
+func (g Gateway) app(host string) string {
+	for _, a := range g.Apps {
+		if strings.EqualFold(a.Host, host) {
+			return a.App
+		}
+	}
+	return ""
+}
+
+// public 路径是否不需要菜单权限
+func (g Gateway) public(uri string) bool {
+	for _, p := range g.PublicPaths {
+		if matchPath(p, uri) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedURI 网关转发的原始请求路径，Traefik使用X-Forwarded-Uri，nginx需配置X-Original-URI
+func forwardedURI(c *gin.Context) string {
+	uri := c.GetHeader("X-Forwarded-Uri")
+	if uri == "" {
+		uri = c.GetHeader("X-Original-URI")
+	}
+	if u, err := url.Parse(uri); err == nil {
+		uri = u.Path
+	}
+	if uri == "" {
+		return "/"
+	}
+	return path.Clean("/" + uri)
+}
+
+// forwardedHost 网关转发的原始请求Host，不含端口
+func forwardedHost(c *gin.Context) string {
+	host := c.GetHeader("X-Forwarded-Host")
+	if host == "" {
+		host = c.Request.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// forwardedMethod 网关转发的原始请求方法，未传递时使用当前请求方法
+func forwardedMethod(c *gin.Context) string {
+	if method := c.GetHeader("X-Forwarded-Method"); method != "" {
+		return strings.ToUpper(method)
+	}
+	if method := c.GetHeader("X-Original-Method"); method != "" {
+		return strings.ToUpper(method)
+	}
+	return c.Request.Method
+}
+
+// matchPath 菜单路径是否覆盖该URI，按路径段匹配，根路径只匹配自身
+func matchPath(menuPath, uri string) bool {
+	menuPath = strings.TrimSuffix(menuPath, "/")
+	if menuPath == "" {
+		return uri == "/"
+	}
+	return uri == menuPath || strings.HasPrefix(uri, menuPath+"/")
+}